@@ -0,0 +1,332 @@
+package blockchainlistener
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/blockchain"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var tokenNetworkAbi abi.ABI
+var topicToEventName map[common.Hash]string
+
+func init() {
+	var err error
+	tokenNetworkAbi, err = abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	if err != nil {
+		panic(fmt.Sprintf("tokenNetworkAbi parse err %s", err))
+	}
+	//pfs只关心与通道图相关的事件
+	topicToEventName = make(map[common.Hash]string)
+	for _, name := range []string{
+		params.NameTokenNetworkCreated,
+		params.NameChannelOpenedAndDeposit,
+		params.NameChannelNewDeposit,
+		params.NameChannelWithdraw,
+		params.NameChannelClosed,
+		params.NameChannelSettled,
+		params.NameChannelCooperativeSettled,
+	} {
+		topicToEventName[tokenNetworkAbi.Events[name].Id()] = name
+	}
+}
+
+//SyncProgress 历史事件的同步进度
+type SyncProgress struct {
+	Syncing      bool  `json:"syncing"`
+	CurrentBlock int64 `json:"current_block"` //已经处理完毕的块
+	HeadBlock    int64 `json:"head_block"`    //链上最新块
+}
+
+//SyncProgress returns the progress of processing events on chain
+func (ce *ChainEvents) SyncProgress() SyncProgress {
+	ce.syncLock.RLock()
+	defer ce.syncLock.RUnlock()
+	return ce.progress
+}
+
+//IsSyncing returns true before backfill catches up with chain head
+func (ce *ChainEvents) IsSyncing() bool {
+	return ce.SyncProgress().Syncing
+}
+
+/*
+IsSynced 已处理块与链上最新块相差不超过SyncedThreshold,并且没有在回放历史事件.
+链上最新块由pollHeadBlock定时更新,不会访问以太坊节点
+*/
+func (ce *ChainEvents) IsSynced() bool {
+	p := ce.SyncProgress()
+	return !p.Syncing && p.HeadBlock-p.CurrentBlock <= pparams.SyncedThreshold
+}
+
+func (ce *ChainEvents) updateProgress(current, head int64) {
+	ce.syncLock.Lock()
+	defer ce.syncLock.Unlock()
+	ce.progress.CurrentBlock = current
+	if head > ce.progress.HeadBlock {
+		ce.progress.HeadBlock = head
+	}
+}
+
+func (ce *ChainEvents) setSyncing(syncing bool) {
+	ce.syncLock.Lock()
+	defer ce.syncLock.Unlock()
+	ce.progress.Syncing = syncing
+}
+
+func (ce *ChainEvents) updateHeadBlock(head int64) {
+	ce.syncLock.Lock()
+	defer ce.syncLock.Unlock()
+	if head > ce.progress.HeadBlock {
+		ce.progress.HeadBlock = head
+	}
+}
+
+//refreshHeadBlock 获取链上最新块,更新同步进度
+func (ce *ChainEvents) refreshHeadBlock() {
+	head, err := ce.getHeadBlockNumber()
+	if err != nil {
+		log.Error(fmt.Sprintf("get head block number err %s", err))
+		return
+	}
+	ce.updateHeadBlock(head)
+}
+
+//pollHeadBlock 每隔HeadBlockPollInterval更新一次链上最新块,直到Stop
+func (ce *ChainEvents) pollHeadBlock() {
+	ticker := time.NewTicker(pparams.HeadBlockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ce.refreshHeadBlock()
+		case <-ce.quitChan:
+			return
+		}
+	}
+}
+
+func (ce *ChainEvents) getHeadBlockNumber() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+	defer cancel()
+	h, err := ce.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return h.Number.Int64(), nil
+}
+
+/*
+backfill 分批回放上次处理的块到链上最新块之间的历史事件.
+每一批最多batchSize块,这一批的事件以及已处理块数在同一个数据库事务中提交,
+中途退出不会出现事件处理了一半的情况,某个事件处理失败时整批回滚并重试.
+当已处理块数与链上最新块相差不超过SyncedThreshold时返回,剩下的交给正常的事件监听处理.
+*/
+func (ce *ChainEvents) backfill(batchSize int64) (lastBlock int64, err error) {
+	lastBlock = ce.getLatestBlockNumber()
+	for !ce.isStopped() {
+		var head int64
+		head, err = ce.getHeadBlockNumber()
+		if err != nil {
+			log.Error(fmt.Sprintf("backfill get latest block number err %s", err))
+			time.Sleep(pparams.BackfillRetryInterval)
+			continue
+		}
+		ce.updateProgress(lastBlock, head)
+		if head-lastBlock <= pparams.SyncedThreshold {
+			log.Info(fmt.Sprintf("backfill complete at block %d, head=%d", lastBlock, head))
+			return
+		}
+		from := lastBlock + 1
+		to := lastBlock + batchSize
+		if to > head {
+			to = head
+		}
+		var stateChanges []mediatedtransfer.ContractStateChange
+		stateChanges, err = ce.queryStateChanges(from, to)
+		if err != nil {
+			log.Error(fmt.Sprintf("backfill query events %d-%d err %s", from, to, err))
+			time.Sleep(pparams.BackfillRetryInterval)
+			continue
+		}
+		err = ce.applyBatch(stateChanges, to)
+		if err != nil {
+			log.Error(fmt.Sprintf("backfill %d-%d err %s, retry", from, to, err))
+			time.Sleep(pparams.BackfillRetryInterval)
+			continue
+		}
+		lastBlock = to
+		ce.updateProgress(lastBlock, head)
+		log.Info(fmt.Sprintf("backfill %d events, block %d/%d (%.2f%%)",
+			len(stateChanges), lastBlock, head, float64(lastBlock)*100/float64(head)))
+	}
+	err = fmt.Errorf("backfill stopped at %d", lastBlock)
+	return
+}
+
+/*
+applyBatch 在一个数据库事务中处理一批事件并把已处理块数更新为to,
+任何一个事件处理失败都整批回滚,内存中的通道图可能已经部分更新,从数据库重新加载
+*/
+func (ce *ChainEvents) applyBatch(stateChanges []mediatedtransfer.ContractStateChange, to int64) error {
+	err := ce.db.Transaction(func(tx *model.ModelDB) error {
+		for _, sc := range stateChanges {
+			if err := ce.applyStateChange(tx, sc); err != nil {
+				return err
+			}
+		}
+		tx.UpdateBlockNumber(to)
+		return nil
+	})
+	if err != nil {
+		if err2 := ce.TokenNetwork.loadChannels(); err2 != nil {
+			log.Error(fmt.Sprintf("reload channels err %s", err2))
+		}
+	}
+	return err
+}
+
+//queryStateChanges 获取区块fromBlock到toBlock之间(包含两端)的通道相关事件,按块排序
+func (ce *ChainEvents) queryStateChanges(fromBlock, toBlock int64) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
+	logs, err := rpc.EventsGetInternal(rpc.GetQueryConext(), []common.Address{ce.bcs.GetRegistryAddress()}, fromBlock, toBlock, ce.client)
+	if err != nil {
+		return
+	}
+	for i := range logs {
+		var scs []mediatedtransfer.ContractStateChange
+		scs, err = parseLog(&logs[i])
+		if err != nil {
+			return
+		}
+		stateChanges = append(stateChanges, scs...)
+	}
+	//open and deposit会产生两个state change,必须保持先后顺序
+	sort.SliceStable(stateChanges, func(i, j int) bool {
+		return stateChanges[i].GetBlockNumber() < stateChanges[j].GetBlockNumber()
+	})
+	return
+}
+
+//parseLog 与photon中的处理保持一致,忽略与通道图无关的事件
+func parseLog(l *types.Log) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
+	if len(l.Topics) == 0 {
+		return
+	}
+	eventName, ok := topicToEventName[l.Topics[0]]
+	if !ok {
+		return
+	}
+	blockNumber := int64(l.BlockNumber)
+	switch eventName {
+	case params.NameTokenNetworkCreated:
+		e := &contracts.TokensNetworkTokenNetworkCreated{}
+		if err = blockchain.UnpackLog(&tokenNetworkAbi, e, eventName, l); err != nil {
+			return
+		}
+		stateChanges = append(stateChanges, &mediatedtransfer.ContractTokenAddedStateChange{
+			TokenAddress: e.TokenAddress,
+			BlockNumber:  blockNumber,
+		})
+	case params.NameChannelOpenedAndDeposit:
+		e := &contracts.TokensNetworkChannelOpenedAndDeposit{}
+		if err = blockchain.UnpackLog(&tokenNetworkAbi, e, eventName, l); err != nil {
+			return
+		}
+		channelID := calcChannelID(e.Token, l.Address, e.Participant, e.Partner)
+		stateChanges = append(stateChanges, &mediatedtransfer.ContractNewChannelStateChange{
+			ChannelIdentifier: &contracts.ChannelUniqueID{
+				ChannelIdentifier: channelID,
+				OpenBlockNumber:   blockNumber,
+			},
+			Participant1:  e.Participant,
+			Participant2:  e.Partner,
+			SettleTimeout: int(e.SettleTimeout),
+			BlockNumber:   blockNumber,
+			TokenAddress:  e.Token,
+		}, &mediatedtransfer.ContractBalanceStateChange{
+			ChannelIdentifier:  channelID,
+			ParticipantAddress: e.Participant,
+			BlockNumber:        blockNumber,
+			Balance:            e.Participant1Deposit,
+		})
+	case params.NameChannelNewDeposit:
+		e := &contracts.TokensNetworkChannelNewDeposit{}
+		if err = blockchain.UnpackLog(&tokenNetworkAbi, e, eventName, l); err != nil {
+			return
+		}
+		stateChanges = append(stateChanges, &mediatedtransfer.ContractBalanceStateChange{
+			ChannelIdentifier:  e.ChannelIdentifier,
+			ParticipantAddress: e.Participant,
+			BlockNumber:        blockNumber,
+			Balance:            e.TotalDeposit,
+		})
+	case params.NameChannelWithdraw:
+		e := &contracts.TokensNetworkChannelWithdraw{}
+		if err = blockchain.UnpackLog(&tokenNetworkAbi, e, eventName, l); err != nil {
+			return
+		}
+		sc := &mediatedtransfer.ContractChannelWithdrawStateChange{
+			ChannelIdentifier: &contracts.ChannelUniqueID{
+				ChannelIdentifier: e.ChannelIdentifier,
+				OpenBlockNumber:   blockNumber,
+			},
+			Participant1:        e.Participant1,
+			Participant2:        e.Participant2,
+			Participant1Balance: e.Participant1Balance,
+			Participant2Balance: e.Participant2Balance,
+			BlockNumber:         blockNumber,
+		}
+		if sc.Participant1Balance == nil {
+			sc.Participant1Balance = new(big.Int)
+		}
+		if sc.Participant2Balance == nil {
+			sc.Participant2Balance = new(big.Int)
+		}
+		stateChanges = append(stateChanges, sc)
+	case params.NameChannelClosed:
+		e := &contracts.TokensNetworkChannelClosed{}
+		if err = blockchain.UnpackLog(&tokenNetworkAbi, e, eventName, l); err != nil {
+			return
+		}
+		stateChanges = append(stateChanges, &mediatedtransfer.ContractClosedStateChange{
+			ChannelIdentifier: e.ChannelIdentifier,
+			ClosingAddress:    e.ClosingParticipant,
+			LocksRoot:         e.Locksroot,
+			ClosedBlock:       blockNumber,
+			TransferredAmount: e.TransferredAmount,
+		})
+	case params.NameChannelSettled:
+		e := &contracts.TokensNetworkChannelSettled{}
+		if err = blockchain.UnpackLog(&tokenNetworkAbi, e, eventName, l); err != nil {
+			return
+		}
+		stateChanges = append(stateChanges, &mediatedtransfer.ContractSettledStateChange{
+			ChannelIdentifier: e.ChannelIdentifier,
+			SettledBlock:      blockNumber,
+		})
+	case params.NameChannelCooperativeSettled:
+		e := &contracts.TokensNetworkChannelCooperativeSettled{}
+		if err = blockchain.UnpackLog(&tokenNetworkAbi, e, eventName, l); err != nil {
+			return
+		}
+		stateChanges = append(stateChanges, &mediatedtransfer.ContractCooperativeSettledStateChange{
+			ChannelIdentifier: e.ChannelIdentifier,
+			SettledBlock:      blockNumber,
+		})
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package blockchainlistener
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestLog(t *testing.T, name string, contract common.Address, blockNumber uint64, topics []common.Hash, args ...interface{}) *types.Log {
+	ev := tokenNetworkAbi.Events[name]
+	data, err := ev.Inputs.NonIndexed().Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.Log{
+		Address:     contract,
+		Topics:      append([]common.Hash{ev.Id()}, topics...),
+		Data:        data,
+		BlockNumber: blockNumber,
+	}
+}
+
+func TestParseLog(t *testing.T) {
+	ast := assert.New(t)
+	tokensNetwork := utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+
+	l := makeTestLog(t, params.NameChannelOpenedAndDeposit, tokensNetwork, 30,
+		[]common.Hash{common.BytesToHash(token[:])}, p1, p2, uint64(100), big.NewInt(20))
+	scs, err := parseLog(l)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !ast.EqualValues(2, len(scs)) {
+		return
+	}
+	channelID := calcChannelID(token, tokensNetwork, p1, p2)
+	open, ok := scs[0].(*mediatedtransfer.ContractNewChannelStateChange)
+	ast.True(ok)
+	ast.EqualValues(channelID, open.ChannelIdentifier.ChannelIdentifier)
+	ast.EqualValues(token, open.TokenAddress)
+	ast.EqualValues(30, open.BlockNumber)
+	deposit, ok := scs[1].(*mediatedtransfer.ContractBalanceStateChange)
+	ast.True(ok)
+	ast.EqualValues(p1, deposit.ParticipantAddress)
+	ast.EqualValues(big.NewInt(20), deposit.Balance)
+
+	l = makeTestLog(t, params.NameChannelNewDeposit, tokensNetwork, 31,
+		[]common.Hash{channelID}, p2, big.NewInt(50))
+	scs, err = parseLog(l)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !ast.EqualValues(1, len(scs)) {
+		return
+	}
+	deposit, ok = scs[0].(*mediatedtransfer.ContractBalanceStateChange)
+	ast.True(ok)
+	ast.EqualValues(channelID, deposit.ChannelIdentifier)
+	ast.EqualValues(p2, deposit.ParticipantAddress)
+	ast.EqualValues(big.NewInt(50), deposit.Balance)
+
+	//与通道图无关的事件直接忽略
+	l.Topics[0] = utils.NewRandomHash()
+	scs, err = parseLog(l)
+	ast.Nil(err)
+	ast.EqualValues(0, len(scs))
+}
+
+//一批事件中有一个处理失败,整批回滚,内存中的通道图也恢复,重试时可以重新处理
+func TestChainEvents_ApplyBatchRollback(t *testing.T) {
+	ast := assert.New(t)
+	db := model.SetupTestDB()
+	tokensNetwork := utils.NewRandomAddress()
+	tn, _ := newTestTokenNetwork(db, tokensNetwork)
+	ce := &ChainEvents{db: db, TokenNetwork: tn}
+	token := utils.NewRandomAddress()
+	ast.Nil(tn.handleTokenNetworkAdded(db, token, 1, 0))
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	channelID := calcChannelID(token, tokensNetwork, p1, p2)
+	open := &mediatedtransfer.ContractNewChannelStateChange{
+		ChannelIdentifier: &contracts.ChannelUniqueID{
+			ChannelIdentifier: channelID,
+			OpenBlockNumber:   30,
+		},
+		Participant1: p1,
+		Participant2: p2,
+		BlockNumber:  30,
+		TokenAddress: token,
+	}
+	deposit := &mediatedtransfer.ContractBalanceStateChange{
+		ChannelIdentifier:  channelID,
+		ParticipantAddress: p1,
+		BlockNumber:        30,
+		Balance:            big.NewInt(100),
+	}
+	badDeposit := &mediatedtransfer.ContractBalanceStateChange{
+		ChannelIdentifier:  utils.NewRandomHash(),
+		ParticipantAddress: p1,
+		BlockNumber:        31,
+		Balance:            big.NewInt(100),
+	}
+
+	err := ce.applyBatch([]mediatedtransfer.ContractStateChange{open, deposit, badDeposit}, 50)
+	ast.NotNil(err)
+	ast.EqualValues(0, db.GetLatestBlockNumber())
+	_, err = db.GetChannel(channelID.String())
+	ast.NotNil(err, "channel open should rollback")
+	ast.Nil(tn.channels[channelID], "channel view should reload")
+
+	err = ce.applyBatch([]mediatedtransfer.ContractStateChange{open, deposit}, 50)
+	if !ast.Nil(err) {
+		return
+	}
+	ast.EqualValues(50, db.GetLatestBlockNumber())
+	c := tn.channels[channelID]
+	if !ast.NotNil(c) {
+		return
+	}
+	b1 := c.Participant1Balance
+	if c.Participant1 != p1 {
+		b1 = c.Participant2Balance
+	}
+	ast.EqualValues(big.NewInt(100), b1)
+}
@@ -0,0 +1,54 @@
+package blockchainlistener
+
+import (
+	"math/rand"
+	"time"
+)
+
+//backoff 连接失败以后的重试间隔,每次失败加倍,最大为max,并加上随机抖动避免所有pfs同时重连
+type backoff struct {
+	min time.Duration
+	max time.Duration
+	cur time.Duration
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if min <= 0 {
+		min = time.Second
+	}
+	if max < min {
+		max = min
+	}
+	return &backoff{min: min, max: max}
+}
+
+//next 下一次重试前需要等待的时间,在[cur/2,cur)之间
+func (b *backoff) next() time.Duration {
+	if b.cur < b.min {
+		b.cur = b.min
+	} else {
+		b.cur *= 2
+		if b.cur > b.max {
+			b.cur = b.max
+		}
+	}
+	half := b.cur / 2
+	return half + time.Duration(rand.Int63n(int64(b.cur-half)+1))
+}
+
+//reset 连接成功以后从min重新开始
+func (b *backoff) reset() {
+	b.cur = 0
+}
+
+//sleep 等待d,quit关闭时立即返回false
+func sleep(d time.Duration, quit <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-quit:
+		return false
+	}
+}
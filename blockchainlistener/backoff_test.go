@@ -0,0 +1,24 @@
+package blockchainlistener
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	ast := assert.New(t)
+	b := newBackoff(time.Second, 4*time.Second)
+	for _, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		d := b.next()
+		ast.True(d >= max/2 && d <= max, "%s not in [%s,%s]", d, max/2, max)
+	}
+	b.reset()
+	ast.True(b.next() <= time.Second)
+
+	quit := make(chan struct{})
+	close(quit)
+	ast.False(sleep(time.Hour, quit))
+	ast.True(sleep(time.Millisecond, make(chan struct{})))
+}
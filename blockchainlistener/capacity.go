@@ -0,0 +1,99 @@
+package blockchainlistener
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/dijkstra"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//Capacity source当前最多能给target转多少钱
+type Capacity struct {
+	//单条路径最多能转多少
+	MaxSinglePath *big.Int `json:"max_single_path"`
+	//瓶颈最大的路径,包含source和target
+	Path []common.Address `json:"path"`
+	//同时使用多条路径最多能转多少
+	MaxFlow *big.Int `json:"max_flow"`
+}
+
+//capacityGraph 通道p1->p2方向的容量是p1的余额,反方向是p2的余额
+type capacityGraph struct {
+	g       *dijkstra.CapacityGraph
+	index   map[common.Address]int
+	address []common.Address
+}
+
+func newCapacityGraph(cs []*channel) *capacityGraph {
+	cg := &capacityGraph{
+		g:     dijkstra.NewCapacityGraph(),
+		index: make(map[common.Address]int),
+	}
+	vertex := func(addr common.Address) int {
+		i, ok := cg.index[addr]
+		if !ok {
+			i = cg.g.AddVertex()
+			cg.index[addr] = i
+			cg.address = append(cg.address, addr)
+		}
+		return i
+	}
+	for _, c := range cs {
+		i1, i2 := vertex(c.Participant1), vertex(c.Participant2)
+		cg.g.AddEdge(i1, i2, c.Participant1Balance)
+		cg.g.AddEdge(i2, i1, c.Participant2Balance)
+	}
+	return cg
+}
+
+//widestPath 单条路径最多能转多少钱以及这条路径,没有路径时返回0
+func widestPath(cs []*channel, source, target common.Address) (width *big.Int, path []common.Address) {
+	cg := newCapacityGraph(cs)
+	s, ok1 := cg.index[source]
+	d, ok2 := cg.index[target]
+	if !ok1 || !ok2 {
+		return new(big.Int), nil
+	}
+	width, indexes := cg.g.WidestPath(s, d)
+	for _, i := range indexes {
+		path = append(path, cg.address[i])
+	}
+	return
+}
+
+//usableChannels 与GetPaths使用相同的规则过滤掉不能用于source到target路由的通道
+func (t *TokenNetwork) usableChannels(cs []*channel, source, target common.Address) (usable []*channel) {
+	t.nodeLock.Lock()
+	defer t.nodeLock.Unlock()
+	for _, c := range cs {
+		if t.filterChannel(c, source, target) == filterNone {
+			usable = append(usable, c)
+		}
+	}
+	return
+}
+
+/*
+GetCapacity 计算source当前最多能给target转多少钱,不考虑手续费.
+*/
+func (t *TokenNetwork) GetCapacity(source, target, tokenAddress common.Address) (c *Capacity, err error) {
+	t.viewlock.RLock()
+	cs, ok := t.channelViews[tokenAddress]
+	t.viewlock.RUnlock()
+	if !ok {
+		err = fmt.Errorf("unkown token %s", tokenAddress.String())
+		return
+	}
+	usable := t.usableChannels(cs, source, target)
+	c = &Capacity{}
+	c.MaxSinglePath, c.Path = widestPath(usable, source, target)
+	cg := newCapacityGraph(usable)
+	c.MaxFlow = new(big.Int)
+	s, ok1 := cg.index[source]
+	d, ok2 := cg.index[target]
+	if ok1 && ok2 {
+		c.MaxFlow = cg.g.MaxFlow(s, d)
+	}
+	return
+}
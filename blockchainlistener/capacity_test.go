@@ -0,0 +1,68 @@
+package blockchainlistener
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenNetwork_GetCapacity(t *testing.T) {
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	addr1, addr2, addr3, addr4 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	mobile := utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address, b1, b2 int64) *channel {
+		return &channel{
+			Participant1:        p1,
+			Participant2:        p2,
+			Participant1Balance: big.NewInt(b1),
+			Participant2Balance: big.NewInt(b2),
+			Token:               token,
+		}
+	}
+	/*
+		1->2->4 瓶颈30
+		1->3->4 瓶颈20
+		1->mobile->4 手机节点不能作为中间节点
+	*/
+	tn := buildTestTN([]*channel{
+		newChannel(addr1, addr2, 100, 0),
+		newChannel(addr2, addr4, 30, 5),
+		newChannel(addr1, addr3, 20, 0),
+		newChannel(addr3, addr4, 50, 0),
+		newChannel(addr1, mobile, 1000, 0),
+		newChannel(mobile, addr4, 1000, 0),
+	})
+	testPresence(tn).Mobile(mobile)
+	c, err := tn.GetCapacity(addr1, addr4, token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(big.NewInt(30), c.MaxSinglePath)
+	ast.EqualValues([]common.Address{addr1, addr2, addr4}, c.Path)
+	ast.EqualValues(big.NewInt(50), c.MaxFlow)
+
+	//手机节点作为接收方是可以的
+	c, err = tn.GetCapacity(addr1, mobile, token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(big.NewInt(1000), c.MaxSinglePath)
+
+	c, err = tn.GetCapacity(addr4, addr1, token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(big.NewInt(0), c.MaxSinglePath)
+	ast.EqualValues(big.NewInt(0), c.MaxFlow)
+	ast.Nil(c.Path)
+
+	_, err = tn.GetCapacity(addr1, addr4, utils.NewRandomAddress())
+	ast.NotNil(err)
+}
@@ -0,0 +1,155 @@
+package blockchainlistener
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//PathConstraints 用户对路径的额外要求
+type PathConstraints struct {
+	ExcludeNodes    []common.Address //不能经过这些节点中转
+	ExcludeChannels []common.Hash    //不能使用这些通道
+	ViaNodes        []common.Address //必须依次经过这些节点中转
+	MaxHops         int              //最多经过多少个中间节点,也就是PathResult.PathHop的最大值,0表示不限制
+	MaxFee          *big.Int         //最多收取多少费用,nil表示不限制
+}
+
+//searchLimits 搜索路径时的限制,需要跳过的节点和通道,以及路径的边数和费用上限
+type searchLimits struct {
+	nodes    map[common.Address]bool
+	channels map[*channel]bool
+	maxEdges int      //<=0表示不限制
+	maxFee   *big.Int //nil表示不限制
+}
+
+//constrained 是否需要在搜索时限制边数或者费用
+func (ex *searchLimits) constrained() bool {
+	return ex != nil && (ex.maxEdges > 0 || ex.maxFee != nil)
+}
+
+func (ex *searchLimits) excluded(c *channel) bool {
+	if ex == nil {
+		return false
+	}
+	return ex.nodes[c.Participant1] || ex.nodes[c.Participant2] || ex.channels[c]
+}
+
+func (ex *searchLimits) clone() *searchLimits {
+	ex2 := &searchLimits{
+		nodes:    make(map[common.Address]bool),
+		channels: ex.channels,
+		maxEdges: ex.maxEdges,
+		maxFee:   ex.maxFee,
+	}
+	for n := range ex.nodes {
+		ex2.nodes[n] = true
+	}
+	return ex2
+}
+
+func (t *TokenNetwork) newSearchLimits(pc *PathConstraints) *searchLimits {
+	ex := &searchLimits{
+		nodes:    make(map[common.Address]bool),
+		channels: make(map[*channel]bool),
+	}
+	if pc == nil {
+		return ex
+	}
+	if pc.MaxHops > 0 {
+		ex.maxEdges = pc.MaxHops + 1
+	}
+	ex.maxFee = pc.MaxFee
+	for _, n := range pc.ExcludeNodes {
+		ex.nodes[n] = true
+	}
+	t.viewlock.RLock()
+	for _, id := range pc.ExcludeChannels {
+		if c := t.channels[id]; c != nil {
+			ex.channels[c] = true
+		}
+	}
+	t.viewlock.RUnlock()
+	return ex
+}
+
+/*
+GetPathsWithConstraints 和GetPaths一样,但是满足pc中的要求.
+有ViaNodes时,依次计算source->via1->via2...->target每一段费用最低的路径再拼接起来,只返回一条路径.
+拼接时后面的段不会再经过前面已经用过的节点,避免出现环路.
+MaxHops和MaxFee在搜索时就起作用,有ViaNodes时每一段只能使用前面的段剩下的额度
+*/
+func (t *TokenNetwork) GetPathsWithConstraints(source common.Address, target common.Address, tokenAddress common.Address,
+	value *big.Int, limitPaths int, sortDemand string, sourceChargeFee bool, pc *PathConstraints) (pathinfos []*PathResult, err error) {
+	ex := t.newSearchLimits(pc)
+	if ex.nodes[source] || ex.nodes[target] {
+		return nil, fmt.Errorf("peer_from and peer_to cannot be excluded")
+	}
+	if ex.maxFee != nil && ex.maxFee.Sign() < 0 {
+		return nil, fmt.Errorf("max fee must not be negative")
+	}
+	if pc == nil || len(pc.ViaNodes) == 0 {
+		return t.getPaths(source, target, tokenAddress, value, limitPaths, sortDemand, sourceChargeFee, ex)
+	}
+	stops := []common.Address{source}
+	stops = append(stops, pc.ViaNodes...)
+	stops = append(stops, target)
+	seen := make(map[common.Address]bool)
+	for _, n := range stops {
+		if seen[n] {
+			return nil, fmt.Errorf("duplicate node %s in path", n.String())
+		}
+		seen[n] = true
+		if ex.nodes[n] {
+			return nil, fmt.Errorf("via node %s is excluded", n.String())
+		}
+	}
+	for _, n := range pc.ViaNodes {
+		state, _ := t.GetNodeState(n)
+		if !state.Online || state.Mobile || state.IgnoreMediatedTransfer {
+			return nil, fmt.Errorf("via node %s cannot mediate transfers", n.String())
+		}
+	}
+	result := &PathResult{
+		Fee:         new(big.Int),
+		Probability: 1,
+	}
+	for i := 0; i < len(stops)-1; i++ {
+		segEx := ex.clone()
+		//后面要经过的节点不能出现在这一段中
+		for _, n := range stops[i+2:] {
+			segEx.nodes[n] = true
+		}
+		//后面的每一段至少需要一条边
+		if ex.maxEdges > 0 {
+			segEx.maxEdges = ex.maxEdges - (len(result.Result) + len(stops) - i - 2)
+			if segEx.maxEdges <= 0 {
+				return nil, fmt.Errorf("too many via nodes for max hops")
+			}
+		}
+		if ex.maxFee != nil {
+			segEx.maxFee = new(big.Int).Sub(ex.maxFee, result.Fee)
+		}
+		//via节点作为中间节点,总是要收费的
+		var paths []*PathResult
+		paths, err = t.getPaths(stops[i], stops[i+1], tokenAddress, value, 1, sortDemand, sourceChargeFee || i > 0, segEx)
+		if err == nil && len(paths) == 0 {
+			err = fmt.Errorf("There is no suitable path")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("no path from %s to %s: %s", stops[i].String(), stops[i+1].String(), err)
+		}
+		p := paths[0]
+		result.Fee.Add(result.Fee, p.Fee)
+		result.Probability *= p.Probability
+		result.Result = append(result.Result, p.Result...)
+		//已经经过的节点以后不能再经过
+		ex.nodes[stops[i]] = true
+		for _, n := range p.Result[:len(p.Result)-1] {
+			ex.nodes[n] = true
+		}
+	}
+	result.PathHop = len(result.Result) - 1
+	return []*PathResult{result}, nil
+}
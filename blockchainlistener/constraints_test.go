@@ -0,0 +1,178 @@
+package blockchainlistener
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenNetwork_GetPathsWithConstraints(t *testing.T) {
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	addr1, addr2, addr3, addr4, mobile := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address, fee1, fee2 int64) *channel {
+		return &channel{
+			Participant1: p1,
+			Participant2: p2,
+			Participant1Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee1),
+			},
+			Participant2Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee2),
+			},
+			Participant1Balance: big.NewInt(100),
+			Participant2Balance: big.NewInt(100),
+			Token:               token,
+		}
+	}
+	c24 := newChannel(addr2, addr4, 1, 1)
+	tn := buildTestTN([]*channel{
+		newChannel(addr1, addr2, 1, 1),
+		c24,
+		newChannel(addr1, addr3, 2, 2),
+		newChannel(addr3, addr4, 2, 2),
+		newChannel(addr3, addr2, 2, 2),
+		newChannel(addr1, mobile, 1, 1),
+		newChannel(mobile, addr4, 1, 1),
+	})
+	testPresence(tn).Mobile(mobile)
+	var c24ID common.Hash
+	for id, c := range tn.channels {
+		if c == c24 {
+			c24ID = id
+		}
+	}
+	value := big.NewInt(10)
+	paths, err := tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, nil)
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr2, addr4}, paths[0].Result)
+
+	paths, err = tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, &PathConstraints{
+		ExcludeNodes: []common.Address{addr2},
+	})
+	ast.Nil(err)
+	ast.EqualValues(1, len(paths))
+	ast.EqualValues([]common.Address{addr3, addr4}, paths[0].Result)
+
+	paths, err = tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, &PathConstraints{
+		ExcludeChannels: []common.Hash{c24ID},
+	})
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr3, addr4}, paths[0].Result)
+
+	_, err = tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, &PathConstraints{
+		ExcludeNodes: []common.Address{addr2, addr3},
+	})
+	ast.NotNil(err)
+
+	//依次经过addr3和addr2
+	paths, err = tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, &PathConstraints{
+		ViaNodes: []common.Address{addr3, addr2},
+	})
+	ast.Nil(err)
+	ast.EqualValues(1, len(paths))
+	ast.EqualValues([]common.Address{addr3, addr2, addr4}, paths[0].Result)
+	ast.EqualValues(2, paths[0].PathHop)
+	ast.EqualValues(3, paths[0].Fee.Int64())
+
+	paths, err = tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, &PathConstraints{
+		ViaNodes: []common.Address{addr2, addr3},
+	})
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr2, addr3, addr4}, paths[0].Result)
+	//addr2->addr3这一段不能再回到addr1,也不能经过后面的addr4
+	_, err = tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, &PathConstraints{
+		ViaNodes:        []common.Address{addr2, addr3},
+		ExcludeChannels: []common.Hash{calcChannelID(token, tn.TokensNetworkAddress, addr3, addr2)},
+	})
+	ast.NotNil(err)
+
+	for _, pc := range []*PathConstraints{
+		{ExcludeNodes: []common.Address{addr1}},
+		{ExcludeNodes: []common.Address{addr4}},
+		{ViaNodes: []common.Address{addr2}, ExcludeNodes: []common.Address{addr2}},
+		{ViaNodes: []common.Address{addr2, addr2}},
+		{ViaNodes: []common.Address{mobile}},
+	} {
+		_, err = tn.GetPathsWithConstraints(addr1, addr4, token, value, 5, "", false, pc)
+		ast.NotNil(err)
+	}
+}
+
+func TestTokenNetwork_GetPathsMaxHopsMaxFee(t *testing.T) {
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	src, dst := utils.NewRandomAddress(), utils.NewRandomAddress()
+	a, b, c, d := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address, fee int64) *channel {
+		return &channel{
+			Participant1: p1,
+			Participant2: p2,
+			Participant1Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee),
+			},
+			Participant2Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee),
+			},
+			Participant1Balance: big.NewInt(100),
+			Participant2Balance: big.NewInt(100),
+			Token:               token,
+		}
+	}
+	//src->a->b->c->dst 费用3, src->d->dst 费用5
+	tn := buildTestTN([]*channel{
+		newChannel(src, a, 1),
+		newChannel(a, b, 1),
+		newChannel(b, c, 1),
+		newChannel(c, dst, 1),
+		newChannel(src, d, 5),
+		newChannel(d, dst, 5),
+	})
+	value := big.NewInt(10)
+	paths, err := tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{})
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{a, b, c, dst}, paths[0].Result)
+	ast.EqualValues(3, paths[0].PathHop)
+
+	paths, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{MaxHops: 3})
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{a, b, c, dst}, paths[0].Result)
+	ast.EqualValues(3, paths[0].Fee.Int64())
+
+	//最便宜的路径太长,不能只在最便宜的路径里面过滤
+	paths, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{MaxHops: 2})
+	ast.Nil(err)
+	ast.EqualValues(1, len(paths))
+	ast.EqualValues([]common.Address{d, dst}, paths[0].Result)
+	ast.EqualValues(5, paths[0].Fee.Int64())
+
+	paths, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{MaxFee: big.NewInt(3)})
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{a, b, c, dst}, paths[0].Result)
+	_, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{MaxFee: big.NewInt(2)})
+	ast.NotNil(err)
+	_, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{MaxHops: 2, MaxFee: big.NewInt(4)})
+	ast.NotNil(err)
+	//源节点收费时,源节点的费用也计算在内
+	_, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", true, &PathConstraints{MaxFee: big.NewInt(3)})
+	ast.NotNil(err)
+
+	//有via节点时,限制作用于整条路径
+	paths, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{ViaNodes: []common.Address{b}, MaxHops: 3, MaxFee: big.NewInt(3)})
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{a, b, c, dst}, paths[0].Result)
+	_, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{ViaNodes: []common.Address{b}, MaxHops: 2})
+	ast.NotNil(err)
+	_, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{ViaNodes: []common.Address{b}, MaxFee: big.NewInt(2)})
+	ast.NotNil(err)
+	_, err = tn.GetPathsWithConstraints(src, dst, token, value, 5, "", false, &PathConstraints{MaxFee: big.NewInt(-1)})
+	ast.NotNil(err)
+}
@@ -0,0 +1,70 @@
+package blockchainlistener
+
+import (
+	"math"
+	"time"
+
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+)
+
+/*
+damping 节点上下线的迟滞处理.
+节点报告下线以后,OfflineGracePeriod之内仍然用于路由;
+节点下线后FlapWindow之内重新上线记为一次抖动,抖动惩罚每过FlapPenaltyHalfLife减半,
+惩罚超过FlapSuppressThreshold时节点暂时不用于路由,直到惩罚衰减到FlapReuseThreshold以下
+*/
+type damping struct {
+	offlineSince    time.Time //报告下线的时间,为空表示在线或者重启前就已经下线
+	flapPenalty     float64
+	lastFlap        time.Time
+	suppressedUntil time.Time
+}
+
+//penalty 当前的抖动惩罚
+func (d *damping) penalty(now time.Time) float64 {
+	if d.flapPenalty == 0 || !now.After(d.lastFlap) {
+		return d.flapPenalty
+	}
+	return d.flapPenalty * math.Pow(0.5, float64(now.Sub(d.lastFlap))/float64(pparams.FlapPenaltyHalfLife))
+}
+
+//wentOffline 节点报告下线
+func (d *damping) wentOffline(now time.Time) {
+	if d.offlineSince.IsZero() {
+		d.offlineSince = now
+	}
+}
+
+//cameOnline 节点报告上线,如果是下线以后FlapWindow之内重新上线,记为一次抖动
+func (d *damping) cameOnline(now time.Time) {
+	if d.offlineSince.IsZero() {
+		return
+	}
+	offline := now.Sub(d.offlineSince)
+	d.offlineSince = time.Time{}
+	if offline >= pparams.FlapWindow {
+		return
+	}
+	d.flapPenalty = d.penalty(now) + 1
+	d.lastFlap = now
+	if d.flapPenalty >= pparams.FlapSuppressThreshold {
+		//惩罚衰减到FlapReuseThreshold需要的时间
+		halves := math.Log2(d.flapPenalty / pparams.FlapReuseThreshold)
+		d.suppressedUntil = now.Add(time.Duration(halves * float64(pparams.FlapPenaltyHalfLife)))
+	}
+}
+
+//offlinePending 节点已经报告下线,但是还在宽限期内
+func (d *damping) offlinePending(now time.Time) bool {
+	return !d.offlineSince.IsZero() && now.Sub(d.offlineSince) < pparams.OfflineGracePeriod
+}
+
+//suppressed 节点因为频繁抖动暂时不用于路由
+func (d *damping) suppressed(now time.Time) bool {
+	return now.Before(d.suppressedUntil)
+}
+
+//routable 节点是否可以用于路由,考虑了宽限期和抖动抑制
+func (ns *nodeStatus) routable(now time.Time) bool {
+	return (ns.isOnline || ns.offlinePending(now)) && !ns.suppressed(now)
+}
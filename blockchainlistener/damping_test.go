@@ -0,0 +1,95 @@
+package blockchainlistener
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+//noOfflineGrace 节点报告下线以后立即不用于路由,返回恢复设置的函数
+func noOfflineGrace() func() {
+	old := pparams.OfflineGracePeriod
+	pparams.OfflineGracePeriod = 0
+	return func() {
+		pparams.OfflineGracePeriod = old
+	}
+}
+
+func TestDamping(t *testing.T) {
+	ast := assert.New(t)
+	now := time.Now()
+	ns := &nodeStatus{isOnline: true}
+	ast.True(ns.routable(now))
+	ns.isOnline = false
+	ns.wentOffline(now)
+	ast.True(ns.routable(now.Add(pparams.OfflineGracePeriod / 2)))
+	ast.False(ns.routable(now.Add(pparams.OfflineGracePeriod)))
+
+	//重新上线两次,惩罚还不够抑制
+	for i := 0; i < 2; i++ {
+		ns.isOnline = true
+		ns.cameOnline(now)
+		ns.isOnline = false
+		ns.wentOffline(now)
+	}
+	ns.isOnline = true
+	ast.InDelta(2, ns.penalty(now), 0.001)
+	ast.True(ns.routable(now))
+	ast.InDelta(1, ns.penalty(now.Add(pparams.FlapPenaltyHalfLife)), 0.001)
+	//第三次抖动被抑制,直到惩罚衰减到FlapReuseThreshold
+	ns.cameOnline(now)
+	ast.True(ns.suppressed(now))
+	ast.False(ns.routable(now))
+	reuse := now.Add(time.Duration(float64(pparams.FlapPenaltyHalfLife) * 1.585))
+	ast.True(ns.routable(reuse))
+	ast.InDelta(pparams.FlapReuseThreshold, ns.penalty(reuse), 0.01)
+}
+
+//下线超过FlapWindow以后重新上线不算抖动
+func TestDampingFlapWindow(t *testing.T) {
+	ast := assert.New(t)
+	now := time.Now()
+	ns := &nodeStatus{isOnline: true}
+	for i := 0; i < int(pparams.FlapSuppressThreshold)+1; i++ {
+		ns.isOnline = false
+		ns.wentOffline(now)
+		now = now.Add(pparams.FlapWindow)
+		ns.isOnline = true
+		ns.cameOnline(now)
+	}
+	ast.EqualValues(0, ns.penalty(now))
+	ast.True(ns.routable(now))
+
+	ns.isOnline = false
+	ns.wentOffline(now)
+	now = now.Add(pparams.FlapWindow - time.Second)
+	ns.isOnline = true
+	ns.cameOnline(now)
+	ast.InDelta(1, ns.penalty(now), 0.001)
+}
+
+func TestTokenNetwork_NodeStateDamping(t *testing.T) {
+	ast := assert.New(t)
+	tn, presence := newTestTokenNetwork(model.SetupTestDB(), utils.NewRandomAddress())
+	addr := utils.NewRandomAddress()
+	presence.Online(addr, "other")
+	presence.Offline(addr)
+	state, _ := tn.GetNodeState(addr)
+	ast.True(state.Online)
+	ast.False(state.ReportedOnline)
+	ast.True(state.OfflinePending)
+	for i := 0; i <= int(pparams.FlapSuppressThreshold); i++ {
+		presence.Offline(addr)
+		presence.Online(addr, "other")
+	}
+	state, _ = tn.GetNodeState(addr)
+	ast.True(state.ReportedOnline)
+	ast.False(state.Online)
+	ast.True(state.Suppressed)
+	ast.True(state.SuppressedUntil > time.Now().Unix())
+	ast.True(state.FlapPenalty >= pparams.FlapSuppressThreshold)
+}
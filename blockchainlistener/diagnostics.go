@@ -0,0 +1,71 @@
+package blockchainlistener
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//PathDiagnostics 解释为什么找不到路径,统计作图时每条规则过滤掉的通道数量
+type PathDiagnostics struct {
+	TokenKnown                     bool      `json:"token_known"`
+	TotalChannels                  int       `json:"total_channels"`
+	FilteredOffline                int       `json:"filtered_offline"`
+	FilteredMobile                 int       `json:"filtered_mobile"`
+	FilteredIgnoreMediatedTransfer int       `json:"filtered_ignore_mediated_transfer"`
+	FilteredInsufficientBalance    int       `json:"filtered_insufficient_balance"`
+	Source                         NodeState `json:"source"`
+	Target                         NodeState `json:"target"`
+	SourceInGraph                  bool      `json:"source_in_graph"`
+	TargetInGraph                  bool      `json:"target_in_graph"`
+	//不考虑金额时,单条路径最多能从source转给target多少钱
+	MaxRoutableAmount *big.Int `json:"max_routable_amount"`
+}
+
+/*
+DiagnosePaths 按照GetPaths作图的规则统计通道被过滤的原因,
+并计算当前source到target单条路径能够通过的最大金额
+*/
+func (t *TokenNetwork) DiagnosePaths(source, target, tokenAddress common.Address, value *big.Int) (d *PathDiagnostics) {
+	d = &PathDiagnostics{
+		MaxRoutableAmount: new(big.Int),
+	}
+	d.Source, _ = t.GetNodeState(source)
+	d.Target, _ = t.GetNodeState(target)
+	t.viewlock.RLock()
+	cs, ok := t.channelViews[tokenAddress]
+	t.viewlock.RUnlock()
+	if !ok {
+		return
+	}
+	d.TokenKnown = true
+	d.TotalChannels = len(cs)
+	var usable []*channel
+	inGraph := make(map[common.Address]bool)
+	t.nodeLock.Lock()
+	for _, c := range cs {
+		switch t.filterChannel(c, source, target) {
+		case filterOffline:
+			d.FilteredOffline++
+			continue
+		case filterMobile:
+			d.FilteredMobile++
+			continue
+		case filterIgnoreMediatedTransfer:
+			d.FilteredIgnoreMediatedTransfer++
+			continue
+		}
+		usable = append(usable, c)
+		if c.Participant1Balance.Cmp(value) < 0 && c.Participant2Balance.Cmp(value) < 0 {
+			d.FilteredInsufficientBalance++
+			continue
+		}
+		inGraph[c.Participant1] = true
+		inGraph[c.Participant2] = true
+	}
+	t.nodeLock.Unlock()
+	d.SourceInGraph = inGraph[source]
+	d.TargetInGraph = inGraph[target]
+	d.MaxRoutableAmount, _ = widestPath(usable, source, target)
+	return
+}
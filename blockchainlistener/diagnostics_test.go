@@ -0,0 +1,57 @@
+package blockchainlistener
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenNetwork_DiagnosePaths(t *testing.T) {
+	defer noOfflineGrace()()
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	offline, mobile, addr6 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address, b1, b2 int64) *channel {
+		return &channel{
+			Participant1:        p1,
+			Participant2:        p2,
+			Participant1Balance: big.NewInt(b1),
+			Participant2Balance: big.NewInt(b2),
+			Token:               token,
+		}
+	}
+	tn := buildTestTN([]*channel{
+		newChannel(addr1, addr2, 100, 0),
+		newChannel(addr2, addr3, 30, 0),
+		newChannel(addr1, offline, 1000, 1000),
+		newChannel(addr1, mobile, 1000, 1000),
+		newChannel(mobile, addr3, 1000, 1000),
+		newChannel(addr1, addr6, 20, 0),
+		newChannel(addr6, addr3, 20, 0),
+	})
+	testPresence(tn).Offline(offline)
+	testPresence(tn).Mobile(mobile)
+
+	d := tn.DiagnosePaths(addr1, addr3, token, big.NewInt(50))
+	ast.True(d.TokenKnown)
+	ast.EqualValues(7, d.TotalChannels)
+	ast.EqualValues(1, d.FilteredOffline)
+	ast.EqualValues(2, d.FilteredMobile)
+	ast.EqualValues(0, d.FilteredIgnoreMediatedTransfer)
+	ast.EqualValues(3, d.FilteredInsufficientBalance)
+	ast.True(d.SourceInGraph)
+	ast.False(d.TargetInGraph)
+	ast.True(d.Source.Online)
+	//两条路径中瓶颈较大的一条
+	ast.EqualValues(big.NewInt(30), d.MaxRoutableAmount)
+	//反方向没有余额
+	ast.EqualValues(big.NewInt(0), tn.DiagnosePaths(addr3, addr1, token, big.NewInt(50)).MaxRoutableAmount)
+
+	d = tn.DiagnosePaths(addr1, addr3, utils.NewRandomAddress(), big.NewInt(50))
+	ast.False(d.TokenKnown)
+	ast.EqualValues(big.NewInt(0), d.MaxRoutableAmount)
+}
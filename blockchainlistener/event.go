@@ -2,11 +2,15 @@ package blockchainlistener
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"github.com/SmartMeshFoundation/Photon/notify"
 	"math/big"
+	"sync"
+	"time"
 
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
 	"github.com/SmartMeshFoundation/Photon/log"
 
 	"github.com/SmartMeshFoundation/Photon/blockchain"
@@ -25,17 +29,21 @@ type ChainEvents struct {
 	bcs               *rpc.BlockChainService
 	key               *ecdsa.PrivateKey
 	quitChan          chan struct{}
-	updateBalanceChan chan *userRequestUpdateBalanceProof
+	updateBalanceChan chan transfer.StateChange
+	stopLock          sync.RWMutex //保护stopped,持有读锁才能向updateBalanceChan发送,Stop可以安全关闭它
 	stopped           bool
+	loopDone          chan struct{} //事件处理线程退出时关闭
 	TokenNetwork      *TokenNetwork
-}
-type dbXMPPWrapper struct {
+	db                *model.ModelDB
+	chainID           *big.Int
+	syncLock          sync.RWMutex
+	progress          SyncProgress
 }
 
 /*
 userRequestUpdateBalanceProof 将用户的update balance proof请求
 合并到statechange中处理,避免加锁
-
+处理完毕以后填写channel和err,然后关闭done
 */
 type userRequestUpdateBalanceProof struct {
 	participant            common.Address
@@ -43,21 +51,40 @@ type userRequestUpdateBalanceProof struct {
 	lockedAmount           *big.Int
 	partnerBalanceProof    *model.BalanceProof
 	ignoreMediatedTransfer bool
+	done                   chan struct{}
+	channel                *model.Channel
+	err                    error
 }
 
-func (dbXMPPWrapper) XMPPIsAddrSubed(addr common.Address) bool {
-	return model.XMPPIsAddrSubed(addr)
-}
-func (dbXMPPWrapper) XMPPMarkAddrSubed(addr common.Address) {
-	model.XMPPMarkAddrSubed(addr)
+var (
+	//ErrStopping pfs正在退出,不再处理用户请求
+	ErrStopping = errors.New("pfs is stopping")
+	//ErrRequestTimeout 用户请求在规定时间内没有处理完毕
+	ErrRequestTimeout = errors.New("request timeout")
+)
+
+//BalanceProofUpdate 节点提交的一个balance proof,用于批量更新
+type BalanceProofUpdate struct {
+	Participant            common.Address
+	Partner                common.Address
+	LockedAmount           *big.Int
+	PartnerBalanceProof    *model.BalanceProof
+	IgnoreMediatedTransfer bool
 }
-func (dbXMPPWrapper) XMPPUnMarkAddr(addr common.Address) {
-	model.XMPPUnMarkAddr(addr)
+
+/*
+userRequestUpdateBalanceProofs 批量的balance proof更新,作为一个state change处理,
+所有更新在一个数据库事务中提交,每一个更新的结果填写在errs中,然后关闭done
+*/
+type userRequestUpdateBalanceProofs struct {
+	updates []*BalanceProofUpdate
+	done    chan struct{}
+	errs    []error
 }
 
-// NewChainEvents create chain events
-func NewChainEvents(key *ecdsa.PrivateKey, client *helper.SafeEthClient, tokenNetworkRegistryAddress common.Address, useMatrix bool) *ChainEvents { //, db *models.ModelDB
-	log.Info(fmt.Sprintf("Token Network registry address=%s", tokenNetworkRegistryAddress.String()))
+// NewChainEvents create chain events, every registry contract has its own ChainEvents and db
+func NewChainEvents(key *ecdsa.PrivateKey, client *helper.SafeEthClient, chainID *big.Int, tokenNetworkRegistryAddress common.Address, presence PresenceFactory, db *model.ModelDB) *ChainEvents {
+	log.Info(fmt.Sprintf("Token Network registry address=%s,chainID=%s", tokenNetworkRegistryAddress.String(), chainID))
 	bcs, err := rpc.NewBlockChainService(key, tokenNetworkRegistryAddress, client, &notify.Handler{}, &mockTxInfoDao{})
 	if err != nil {
 		log.Crit(err.Error())
@@ -67,7 +94,7 @@ func NewChainEvents(key *ecdsa.PrivateKey, client *helper.SafeEthClient, tokenNe
 		log.Crit("Register token network error : cannot get registry")
 	}
 
-	token2TokenNetwork := model.GetAllTokenNetworks()
+	token2TokenNetwork := db.GetAllTokenNetworks()
 	log.Trace(fmt.Sprintf("token2TokenNetwork startup=%s", utils.StringInterface(token2TokenNetwork, 2)))
 	decimals := make(map[common.Address]int)
 	for t := range token2TokenNetwork {
@@ -81,6 +108,10 @@ func NewChainEvents(key *ecdsa.PrivateKey, client *helper.SafeEthClient, tokenNe
 		}
 		decimals[t] = int(decimal)
 	}
+	tn, err := NewTokenNetwork(db, token2TokenNetwork, tokenNetworkRegistryAddress, decimals, WithPresence(presence))
+	if err != nil {
+		log.Crit(fmt.Sprintf("NewTokenNetwork err %s", err))
+	}
 	//logrus.in
 	ce := &ChainEvents{
 		client:            client,
@@ -88,31 +119,98 @@ func NewChainEvents(key *ecdsa.PrivateKey, client *helper.SafeEthClient, tokenNe
 		bcs:               bcs,
 		key:               key,
 		quitChan:          make(chan struct{}),
-		updateBalanceChan: make(chan *userRequestUpdateBalanceProof, 10),
-		TokenNetwork:      NewTokenNetwork(token2TokenNetwork, tokenNetworkRegistryAddress, useMatrix, decimals),
+		updateBalanceChan: make(chan transfer.StateChange, 10),
+		loopDone:          make(chan struct{}),
+		TokenNetwork:      tn,
+		db:                db,
+		chainID:           chainID,
 	}
 
 	return ce
 }
 
+//ChainID returns the chain id this registry contract works on
+func (ce *ChainEvents) ChainID() *big.Int {
+	return ce.chainID
+}
+
+//RegistryAddress returns the registry contract address
+func (ce *ChainEvents) RegistryAddress() common.Address {
+	return ce.bcs.GetRegistryAddress()
+}
+
+//DB returns the storage of this network
+func (ce *ChainEvents) DB() *model.ModelDB {
+	return ce.db
+}
+
 // Start moniter blockchain
 func (ce *ChainEvents) Start() error {
-	ce.be.Start(ce.getLatestBlockNumber())
-	go ce.loop()
+	//路由请求根据已处理块与链上最新块的差距判断是否可以提供服务
+	lastBlock := ce.getLatestBlockNumber()
+	ce.updateProgress(lastBlock, 0)
+	ce.refreshHeadBlock()
+	go ce.pollHeadBlock()
+	if !pparams.EnableBackfill {
+		ce.be.Start(lastBlock)
+		go ce.loop()
+		return nil
+	}
+	//先分批回放历史事件,追上以后再开始正常监听
+	ce.setSyncing(true)
+	go func() {
+		lastBlock, err := ce.backfill(pparams.BackfillBatchSize)
+		if err != nil {
+			log.Error(fmt.Sprintf("backfill err %s", err))
+			//事件处理线程不会启动了
+			close(ce.loopDone)
+			return
+		}
+		ce.be.Start(lastBlock)
+		ce.setSyncing(false)
+		ce.loop()
+	}()
 	return nil
 }
 
-// Stop service
+/*
+Stop service, 不再接受新的用户请求,停止监听链上事件,
+等待已经提交的请求和已经收到的链上事件处理完毕,最多等待ShutdownTimeout.
+上下线服务需要另外调用StopPresence停止,可以多次调用
+*/
 func (ce *ChainEvents) Stop() {
+	ce.stopLock.Lock()
+	if ce.stopped {
+		ce.stopLock.Unlock()
+		return
+	}
 	ce.stopped = true
+	//没有正在发送的请求了,关闭以后事件处理线程处理完队列中的请求就会退出
+	close(ce.updateBalanceChan)
+	ce.stopLock.Unlock()
 	ce.be.Stop()
-	ce.TokenNetwork.Stop()
+	select {
+	case <-ce.loopDone:
+	case <-time.After(pparams.ShutdownTimeout):
+		log.Error(fmt.Sprintf("chain %s wait state changes processed timeout", ce.chainID))
+	}
 	close(ce.quitChan)
-	close(ce.updateBalanceChan)
+}
+
+//StopPresence 停止上下线服务,在Stop之后,关闭数据库之前调用
+func (ce *ChainEvents) StopPresence() {
+	ce.TokenNetwork.Stop()
+}
+
+func (ce *ChainEvents) isStopped() bool {
+	ce.stopLock.RLock()
+	defer ce.stopLock.RUnlock()
+	return ce.stopped
 }
 
 // loop loop
 func (ce *ChainEvents) loop() {
+	defer close(ce.loopDone)
 	for {
 		select {
 		case st, ok := <-ce.be.StateChangeChannel:
@@ -123,139 +221,268 @@ func (ce *ChainEvents) loop() {
 			ce.handleStateChange(st)
 		case st, ok := <-ce.updateBalanceChan:
 			if !ok {
-				log.Trace("receive updatebalance")
+				//Stop关闭了updateBalanceChan,用户请求已经处理完了
+				ce.drainStateChanges()
+				return
 			}
 			ce.handleStateChange(st)
-		case <-ce.quitChan:
+		}
+	}
+}
+
+//drainStateChanges 处理已经收到但是还没有处理的链上事件
+func (ce *ChainEvents) drainStateChanges() {
+	for {
+		select {
+		case st, ok := <-ce.be.StateChangeChannel:
+			if !ok {
+				return
+			}
+			ce.handleStateChange(st)
+		default:
 			return
 		}
 	}
 }
 
-//HandleReceiveUserUpdateBalanceProof process the update balance proof request.
-func (ce *ChainEvents) HandleReceiveUserUpdateBalanceProof(participant, partner common.Address, lockedAmount *big.Int, partnerBalanceProof *model.BalanceProof, ignoreMediatedTransfer bool) {
+/*
+submitUserRequest 把用户请求交给事件处理线程,等待done被关闭.
+事件处理线程繁忙的时候,超过UserRequestTimeout返回ErrRequestTimeout,请求可能仍然会被处理.
+*/
+func (ce *ChainEvents) submitUserRequest(st transfer.StateChange, done chan struct{}) error {
+	timeout := time.After(pparams.UserRequestTimeout)
+	err := ce.sendUserRequest(st, timeout)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ce.quitChan:
+		return ErrStopping
+	case <-timeout:
+		return ErrRequestTimeout
+	}
+}
+
+//sendUserRequest 持有读锁发送,保证Stop不会在发送的过程中关闭updateBalanceChan
+func (ce *ChainEvents) sendUserRequest(st transfer.StateChange, timeout <-chan time.Time) error {
+	ce.stopLock.RLock()
+	defer ce.stopLock.RUnlock()
 	if ce.stopped {
-		return
+		return ErrStopping
 	}
-	ce.updateBalanceChan <- &userRequestUpdateBalanceProof{
+	select {
+	case ce.updateBalanceChan <- st:
+		return nil
+	case <-ce.quitChan:
+		return ErrStopping
+	case <-timeout:
+		return ErrRequestTimeout
+	}
+}
+
+/*
+HandleReceiveUserUpdateBalanceProof process the update balance proof request,
+returns the channel after update.
+*/
+func (ce *ChainEvents) HandleReceiveUserUpdateBalanceProof(participant, partner common.Address, lockedAmount *big.Int, partnerBalanceProof *model.BalanceProof, ignoreMediatedTransfer bool) (c *model.Channel, err error) {
+	st := &userRequestUpdateBalanceProof{
 		participant:            participant,
 		partner:                partner,
 		lockedAmount:           lockedAmount,
 		partnerBalanceProof:    partnerBalanceProof,
 		ignoreMediatedTransfer: ignoreMediatedTransfer,
+		done:                   make(chan struct{}),
+	}
+	err = ce.submitUserRequest(st, st.done)
+	if err != nil {
+		return
 	}
+	return st.channel, st.err
+}
+
+/*
+HandleReceiveUserUpdateBalanceProofs process a batch of balance proofs in one state change,
+returns the result of every update, in the same order as updates.
+*/
+func (ce *ChainEvents) HandleReceiveUserUpdateBalanceProofs(updates []*BalanceProofUpdate) (errs []error, err error) {
+	st := &userRequestUpdateBalanceProofs{
+		updates: updates,
+		done:    make(chan struct{}),
+	}
+	err = ce.submitUserRequest(st, st.done)
+	if err != nil {
+		return
+	}
+	return st.errs, nil
 }
 
 // handleStateChange 通道打开、通道关闭、通道存钱、通道取钱
 func (ce *ChainEvents) handleStateChange(st transfer.StateChange) {
+	err := ce.applyStateChange(ce.db, st)
+	if err != nil {
+		log.Error(fmt.Sprintf("handle state change %s err %s", utils.StringInterface(st, 3), err))
+	}
+}
+
+/*
+applyStateChange 通过db处理一个事件,db可以是Transaction中的ModelDB,
+链上事件处理失败时返回错误,调用者可以回滚整个事务.
+用户请求的结果通过done通知请求者,不返回错误
+*/
+func (ce *ChainEvents) applyStateChange(db *model.ModelDB, st transfer.StateChange) error {
 	switch st2 := st.(type) {
 	case *transfer.BlockStateChange:
-		ce.handleBlockNumber(st2.BlockNumber)
+		ce.handleBlockNumber(db, st2.BlockNumber)
 	case *mediatedtransfer.ContractNewChannelStateChange: //open channel event
-		ce.handleChainChannelOpend(st2)
+		return ce.handleChainChannelOpend(db, st2)
 	case *mediatedtransfer.ContractClosedStateChange: //close channel event
-		ce.handleChainChannelClosed(st2)
+		return ce.handleChainChannelClosed(db, st2)
 	case *mediatedtransfer.ContractBalanceStateChange: //deposit event
-		ce.handleChainChannelDeposit(st2)
+		return ce.handleChainChannelDeposit(db, st2)
 	case *mediatedtransfer.ContractChannelWithdrawStateChange: //withdaw event
-		ce.handleWithdrawStateChange(st2)
+		return ce.handleWithdrawStateChange(db, st2)
 	case *mediatedtransfer.ContractTokenAddedStateChange:
 		//chainevent.be.TokenNetworks[st2.TokenNetworkAddress] = true
-		ce.handleTokenAddedStateChange(st2)
+		return ce.handleTokenAddedStateChange(db, st2)
 	case *mediatedtransfer.ContractSettledStateChange:
-		ce.handleChannelSettled(st2)
+		return ce.handleChannelSettled(db, st2)
 	case *mediatedtransfer.ContractCooperativeSettledStateChange:
-		ce.handleChannelCooperativeSettled(st2)
+		return ce.handleChannelCooperativeSettled(db, st2)
 	case *userRequestUpdateBalanceProof:
 		//合并到一个线程中去处理updateBalance,否则可能存在更新channel数据冲突问题
-		err := ce.TokenNetwork.UpdateBalance(st2.participant, st2.partner, st2.lockedAmount, st2.partnerBalanceProof, st2.ignoreMediatedTransfer)
-		if err != nil {
-			log.Error(fmt.Sprintf("UpdateBalance err %s", err))
+		st2.channel, st2.err = ce.TokenNetwork.UpdateBalance(st2.participant, st2.partner, st2.lockedAmount, st2.partnerBalanceProof, st2.ignoreMediatedTransfer)
+		if st2.err != nil {
+			log.Error(fmt.Sprintf("UpdateBalance err %s", st2.err))
 		}
+		close(st2.done)
+	case *userRequestUpdateBalanceProofs:
+		ce.handleUpdateBalanceProofs(st2)
 	default:
 		log.Trace(fmt.Sprintf("unkown statechange %s", utils.StringInterface(st, 3)))
 	}
+	return nil
+}
+
+/*
+handleUpdateBalanceProofs 批量更新在一个数据库事务中完成,每个更新是一个嵌套事务,
+某一个更新失败只回滚它自己的修改,不影响其他更新.
+所有更新都通过事务的ModelDB,提交失败时内存中的通道图从数据库重新加载
+*/
+func (ce *ChainEvents) handleUpdateBalanceProofs(st *userRequestUpdateBalanceProofs) {
+	errs := make([]error, len(st.updates))
+	err := ce.db.Transaction(func(tx *model.ModelDB) error {
+		for i, u := range st.updates {
+			errs[i] = tx.Transaction(func(tx *model.ModelDB) error {
+				_, err := ce.TokenNetwork.updateBalance(tx, u.Participant, u.Partner, u.LockedAmount, u.PartnerBalanceProof, u.IgnoreMediatedTransfer)
+				return err
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateBalance batch commit err %s", err))
+		if err2 := ce.TokenNetwork.loadChannels(); err2 != nil {
+			log.Error(fmt.Sprintf("reload channels err %s", err2))
+		}
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	st.errs = errs
+	close(st.done)
 }
 
-func (ce *ChainEvents) handleChannelSettled(st2 *mediatedtransfer.ContractSettledStateChange) {
+func (ce *ChainEvents) handleChannelSettled(db *model.ModelDB, st2 *mediatedtransfer.ContractSettledStateChange) error {
 	log.Trace(fmt.Sprintf("receive ContractSettledStateChange %s", utils.StringInterface(st2, 3)))
-	err := ce.TokenNetwork.handleChannelSettled(st2.ChannelIdentifier)
+	err := ce.TokenNetwork.handleChannelSettled(db, st2.ChannelIdentifier)
 	if err != nil {
-		log.Error(fmt.Sprintf("handleChannelSettled err %s", err))
+		return fmt.Errorf("handleChannelSettled err %s", err)
 	}
+	return nil
 }
-func (ce *ChainEvents) handleChannelCooperativeSettled(st2 *mediatedtransfer.ContractCooperativeSettledStateChange) {
+func (ce *ChainEvents) handleChannelCooperativeSettled(db *model.ModelDB, st2 *mediatedtransfer.ContractCooperativeSettledStateChange) error {
 	log.Trace(fmt.Sprintf("receive ContractCooperativeSettledStateChange %s", utils.StringInterface(st2, 3)))
-	err := ce.TokenNetwork.handleChannelCooperativeSettled(st2.ChannelIdentifier)
+	err := ce.TokenNetwork.handleChannelCooperativeSettled(db, st2.ChannelIdentifier)
 	if err != nil {
-		log.Error(fmt.Sprintf("handleChannelCooperativeSettled err %s", err))
+		return fmt.Errorf("handleChannelCooperativeSettled err %s", err)
 	}
+	return nil
 }
 
 // handleTokenAddedStateChange Token added
-func (ce *ChainEvents) handleTokenAddedStateChange(st2 *mediatedtransfer.ContractTokenAddedStateChange) {
+func (ce *ChainEvents) handleTokenAddedStateChange(db *model.ModelDB, st2 *mediatedtransfer.ContractTokenAddedStateChange) error {
 	log.Trace(fmt.Sprintf("Received TokenAddedStateChange event for token %s", st2.TokenAddress.String()))
 	tokenProxy, err := ce.bcs.Token(st2.TokenAddress)
 	if err != nil {
-		log.Error(fmt.Sprintf("Token proxy create error %s", err))
-		return
+		return fmt.Errorf("Token proxy create error %s", err)
 	}
 	decimal, err := tokenProxy.Token.Decimals(nil)
-	err = ce.TokenNetwork.handleTokenNetworkAdded(st2.TokenAddress, st2.BlockNumber, decimal)
 	if err != nil {
-		log.Error(fmt.Sprintf("handleTokenNetworkAdded err %s ", err))
+		return fmt.Errorf("get decimals of token %s err %s", st2.TokenAddress.String(), err)
+	}
+	err = ce.TokenNetwork.handleTokenNetworkAdded(db, st2.TokenAddress, st2.BlockNumber, decimal)
+	if err != nil {
+		return fmt.Errorf("handleTokenNetworkAdded err %s ", err)
 	}
+	return nil
 }
 
 //handleBlockNumber the event of notice newest block number on chain
-func (ce *ChainEvents) handleBlockNumber(n int64) {
-	model.UpdateBlockNumber(n)
+func (ce *ChainEvents) handleBlockNumber(db *model.ModelDB, n int64) {
+	db.UpdateBlockNumber(n)
+	ce.updateProgress(n, n)
 }
 
 // handleNewChannelStateChange Open channel
-func (ce *ChainEvents) handleChainChannelOpend(st2 *mediatedtransfer.ContractNewChannelStateChange) {
+func (ce *ChainEvents) handleChainChannelOpend(db *model.ModelDB, st2 *mediatedtransfer.ContractNewChannelStateChange) error {
 
 	log.Trace(fmt.Sprintf("Received ChannelOpened event for token   %s", st2.TokenAddress.String()))
 
 	channelID := st2.ChannelIdentifier.ChannelIdentifier
 	participant1 := st2.Participant1
 	participant2 := st2.Participant2
-	log.Trace(fmt.Sprintf(fmt.Sprintf("Received ChannelOpened data: %s", utils.StringInterface(st2, 3))))
-	err := ce.TokenNetwork.handleChannelOpenedEvent(st2.TokenAddress, channelID, participant1, participant2, st2.BlockNumber)
+	log.Trace(fmt.Sprintf("Received ChannelOpened data: %s", utils.StringInterface(st2, 3)))
+	err := ce.TokenNetwork.handleChannelOpenedEvent(db, st2.TokenAddress, channelID, participant1, participant2, st2.BlockNumber)
 	if err != nil {
-		log.Error(fmt.Sprintf("Handle channel open event error,err=%s", err))
+		return fmt.Errorf("Handle channel open event error,err=%s", err)
 	}
-
+	return nil
 }
 
 // handleDepositStateChange deposit
-func (ce *ChainEvents) handleChainChannelDeposit(st2 *mediatedtransfer.ContractBalanceStateChange) {
+func (ce *ChainEvents) handleChainChannelDeposit(db *model.ModelDB, st2 *mediatedtransfer.ContractBalanceStateChange) error {
 	log.Trace(fmt.Sprintf("Received ChannelDeposit event for  %s", st2.ChannelIdentifier.String()))
 
 	channelID := st2.ChannelIdentifier
 	participantAddress := st2.ParticipantAddress
 	totalDeposit := st2.Balance
-	log.Trace(fmt.Sprintf(fmt.Sprintf("Received ChannelDeposit data: %s", utils.StringInterface(st2, 2))))
-	err := ce.TokenNetwork.handleChannelDepositEvent(channelID, participantAddress, totalDeposit)
+	log.Trace(fmt.Sprintf("Received ChannelDeposit data: %s", utils.StringInterface(st2, 2)))
+	err := ce.TokenNetwork.handleChannelDepositEvent(db, channelID, participantAddress, totalDeposit)
 	if err != nil {
-		log.Error(fmt.Sprintf("Handle channel deposit event error,err=%s", err))
+		return fmt.Errorf("Handle channel deposit event error,err=%s", err)
 	}
+	return nil
 }
 
 // handleChainChannelClosed Close Channel
-func (ce *ChainEvents) handleChainChannelClosed(st2 *mediatedtransfer.ContractClosedStateChange) {
+func (ce *ChainEvents) handleChainChannelClosed(db *model.ModelDB, st2 *mediatedtransfer.ContractClosedStateChange) error {
 
 	log.Trace(fmt.Sprintf("Received ChannelClosed event for channel  %s", utils.StringInterface(st2, 2)))
 
 	channelID := st2.ChannelIdentifier
-	err := ce.TokenNetwork.handleChannelClosedEvent(channelID)
+	err := ce.TokenNetwork.handleChannelClosedEvent(db, channelID)
 	if err != nil {
-		log.Error(fmt.Sprintf("Handle channel close event error,err=%s", err))
+		return fmt.Errorf("Handle channel close event error,err=%s", err)
 	}
+	return nil
 }
 
 // handleWithdrawStateChange Withdraw
-func (ce *ChainEvents) handleWithdrawStateChange(st2 *mediatedtransfer.ContractChannelWithdrawStateChange) {
+func (ce *ChainEvents) handleWithdrawStateChange(db *model.ModelDB, st2 *mediatedtransfer.ContractChannelWithdrawStateChange) error {
 
 	log.Trace(fmt.Sprintf("Received ChannelWithdraw event for  %s", st2.ChannelIdentifier.String()))
 
@@ -265,15 +492,16 @@ func (ce *ChainEvents) handleWithdrawStateChange(st2 *mediatedtransfer.ContractC
 	participant1Balance := st2.Participant1Balance
 	participant2Balance := st2.Participant2Balance
 
-	err := ce.TokenNetwork.handleChannelWithdrawEvent(channelID, participant1, participant2, participant1Balance, participant2Balance, st2.BlockNumber)
+	err := ce.TokenNetwork.handleChannelWithdrawEvent(db, channelID, participant1, participant2, participant1Balance, participant2Balance, st2.BlockNumber)
 	if err != nil {
-		log.Error(fmt.Sprintf("Handle channel withdaw event error,err=%s", err))
+		return fmt.Errorf("Handle channel withdaw event error,err=%s", err)
 	}
+	return nil
 }
 
 // getLatestBlockNumber
 func (ce *ChainEvents) getLatestBlockNumber() int64 {
-	number := model.GetLatestBlockNumber()
-	fmt.Println(number)
+	number := ce.db.GetLatestBlockNumber()
+	log.Debug(fmt.Sprintf("chain %s latest processed block %d", ce.chainID, number))
 	return number
 }
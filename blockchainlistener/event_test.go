@@ -0,0 +1,111 @@
+package blockchainlistener
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainEvents_UpdateBalanceProofs(t *testing.T) {
+	ast := assert.New(t)
+	db := model.SetupTestDB()
+	token := utils.NewRandomAddress()
+	tokensNetwork := utils.NewRandomAddress()
+	tn, _ := newTestTokenNetwork(db, tokensNetwork)
+	ce := &ChainEvents{db: db, TokenNetwork: tn}
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	channelID := calcChannelID(token, tokensNetwork, p1, p2)
+	err := tn.handleTokenNetworkAdded(tn.db, token, 1, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = tn.handleChannelOpenedEvent(tn.db, token, channelID, p1, p2, 3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tn.handleChannelDepositEvent(tn.db, channelID, p1, big.NewInt(100))
+	tn.handleChannelDepositEvent(tn.db, channelID, p2, big.NewInt(50))
+
+	st := &userRequestUpdateBalanceProofs{
+		updates: []*BalanceProofUpdate{
+			{
+				Participant:            p1,
+				Partner:                p2,
+				IgnoreMediatedTransfer: true,
+				PartnerBalanceProof: &model.BalanceProof{
+					Nonce:           1,
+					TransferAmount:  big.NewInt(10),
+					ChannelID:       channelID,
+					OpenBlockNumber: 3,
+				},
+			},
+			{
+				Participant: p1,
+				Partner:     p2,
+				PartnerBalanceProof: &model.BalanceProof{
+					Nonce:           1,
+					TransferAmount:  big.NewInt(10),
+					ChannelID:       utils.NewRandomHash(),
+					OpenBlockNumber: 3,
+				},
+			},
+		},
+		done: make(chan struct{}),
+	}
+	ce.handleStateChange(st)
+	<-st.done
+	errs := st.errs
+	if !ast.EqualValues(2, len(errs)) {
+		return
+	}
+	ast.Nil(errs[0])
+	ast.NotNil(errs[1], "unknown channel")
+
+	c := tn.channels[channelID]
+	b1, b2 := c.Participant1Balance, c.Participant2Balance
+	if c.Participant1 != p1 {
+		b1, b2 = b2, b1
+	}
+	ast.EqualValues(big.NewInt(110), b1)
+	ast.EqualValues(big.NewInt(40), b2)
+	c2, err := db.GetChannel(channelID.String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(1, c2.Participants[0].Nonce+c2.Participants[1].Nonce)
+	//节点状态也在同一个事务中提交
+	var ignore bool
+	for _, n := range db.GetAllNodes() {
+		if n.Address == p1.String() {
+			ignore = n.IgnoreMediatedTransfer
+		}
+	}
+	ast.True(ignore)
+}
+
+func TestChainEvents_UpdateBalanceProofTimeout(t *testing.T) {
+	old := pparams.UserRequestTimeout
+	pparams.UserRequestTimeout = 10 * time.Millisecond
+	defer func() {
+		pparams.UserRequestTimeout = old
+	}()
+	//没有事件处理线程,请求不会被处理
+	ce := &ChainEvents{
+		quitChan:          make(chan struct{}),
+		updateBalanceChan: make(chan transfer.StateChange),
+	}
+	_, err := ce.HandleReceiveUserUpdateBalanceProof(utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(0), &model.BalanceProof{}, false)
+	assert.Equal(t, ErrRequestTimeout, err)
+	close(ce.quitChan)
+	_, err = ce.HandleReceiveUserUpdateBalanceProofs(nil)
+	assert.Equal(t, ErrStopping, err)
+}
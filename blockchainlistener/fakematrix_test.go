@@ -0,0 +1,232 @@
+package blockchainlistener
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//fakeMatrixSyncWait 没有新事件时sync最长等待时间,真实服务器是客户端指定的timeout
+const fakeMatrixSyncWait = 200 * time.Millisecond
+
+/*
+fakeMatrixServer 测试用的matrix服务器,只实现了MatrixObserver用到的部分:
+登录/注册,设置displayname,加入discovery room,设置presence,filter和sync.
+所有用户都能看到彼此的presence
+*/
+type fakeMatrixServer struct {
+	*httptest.Server
+	host     string
+	lock     sync.Mutex
+	down     bool
+	users    map[string]string             //localpart -> password
+	tokens   map[string]string             //access token -> user id
+	queues   map[string][]*fakeMatrixEvent //access token -> 还没有sync的presence事件
+	presence map[string]*fakeMatrixEvent   //user id -> 最新的presence,第一次sync时全部返回
+	nextID   int
+}
+
+type fakeMatrixEvent struct {
+	Type    string            `json:"type"`
+	Sender  string            `json:"sender"`
+	Content map[string]string `json:"content"`
+}
+
+func newFakeMatrixServer() *fakeMatrixServer {
+	s := &fakeMatrixServer{
+		users:    make(map[string]string),
+		tokens:   make(map[string]string),
+		queues:   make(map[string][]*fakeMatrixEvent),
+		presence: make(map[string]*fakeMatrixEvent),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	u, _ := url.Parse(s.URL)
+	s.host = u.Hostname()
+	return s
+}
+
+//SetDown 模拟服务器故障,所有请求都返回500
+func (s *fakeMatrixServer) SetDown(down bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.down = down
+}
+
+//UserID 本服务器上localpart对应的user id
+func (s *fakeMatrixServer) UserID(localpart string) string {
+	return fmt.Sprintf("@%s:%s", localpart, s.host)
+}
+
+//SetPresence 模拟userID上线或者下线,presence为online或者offline
+func (s *fakeMatrixServer) SetPresence(userID, presence, deviceType string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setPresence(userID, presence, deviceType)
+}
+
+//Presence userID最新的presence
+func (s *fakeMatrixServer) Presence(userID string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e := s.presence[userID]; e != nil {
+		return e.Content["presence"]
+	}
+	return ""
+}
+
+func (s *fakeMatrixServer) setPresence(userID, presence, deviceType string) {
+	e := &fakeMatrixEvent{
+		Type:   "m.presence",
+		Sender: userID,
+		Content: map[string]string{
+			"presence":   presence,
+			"status_msg": deviceType,
+		},
+	}
+	s.presence[userID] = e
+	for token := range s.queues {
+		s.queues[token] = append(s.queues[token], e)
+	}
+}
+
+func writeMatrixJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeMatrixError(w http.ResponseWriter, code int, errcode string) {
+	writeMatrixJSON(w, code, map[string]string{"errcode": errcode, "error": errcode})
+}
+
+func (s *fakeMatrixServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	down := s.down
+	userID, authed := s.tokens[r.URL.Query().Get("access_token")]
+	s.lock.Unlock()
+	if down {
+		writeMatrixError(w, http.StatusInternalServerError, "M_UNKNOWN")
+		return
+	}
+	path := r.URL.Path
+	switch {
+	case path == "/_matrix/client/versions":
+		writeMatrixJSON(w, http.StatusOK, map[string][]string{"versions": {"r0.3.0"}})
+	case path == "/_matrix/client/r0/login":
+		s.login(w, r)
+	case path == "/regapp/1/register":
+		s.register(w, r)
+	case !authed:
+		writeMatrixError(w, http.StatusUnauthorized, "M_UNKNOWN_TOKEN")
+	case strings.HasPrefix(path, "/_matrix/client/r0/profile/"):
+		writeMatrixJSON(w, http.StatusOK, struct{}{})
+	case strings.HasPrefix(path, "/_matrix/client/r0/join/"):
+		writeMatrixJSON(w, http.StatusOK, map[string]string{"room_id": "!discovery:" + s.host})
+	case strings.HasPrefix(path, "/_matrix/client/r0/presence/"):
+		var req struct {
+			Presence  string `json:"presence"`
+			StatusMsg string `json:"status_msg"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMatrixError(w, http.StatusBadRequest, "M_BAD_JSON")
+			return
+		}
+		s.SetPresence(userID, req.Presence, req.StatusMsg)
+		writeMatrixJSON(w, http.StatusOK, struct{}{})
+	case strings.HasSuffix(path, "/filter"):
+		writeMatrixJSON(w, http.StatusOK, map[string]string{"filter_id": "1"})
+	case path == "/_matrix/client/r0/sync":
+		s.sync(w, r)
+	default:
+		writeMatrixError(w, http.StatusNotFound, "M_UNRECOGNIZED")
+	}
+}
+
+func (s *fakeMatrixServer) login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMatrixError(w, http.StatusBadRequest, "M_BAD_JSON")
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	password, ok := s.users[req.User]
+	if !ok || password != req.Password {
+		writeMatrixError(w, http.StatusForbidden, "M_FORBIDDEN")
+		return
+	}
+	s.nextID++
+	token := fmt.Sprintf("token%d", s.nextID)
+	userID := s.UserID(req.User)
+	s.tokens[token] = userID
+	writeMatrixJSON(w, http.StatusOK, map[string]string{
+		"access_token": token,
+		"user_id":      userID,
+		"home_server":  s.host,
+	})
+}
+
+func (s *fakeMatrixServer) register(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LocalPart string `json:"localpart"`
+		Password  string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMatrixError(w, http.StatusBadRequest, "M_BAD_JSON")
+		return
+	}
+	s.lock.Lock()
+	s.users[req.LocalPart] = req.Password
+	s.lock.Unlock()
+	writeMatrixJSON(w, http.StatusOK, map[string]string{
+		"user_id":     s.UserID(req.LocalPart),
+		"home_server": s.host,
+	})
+}
+
+//sync 第一次sync返回所有用户的presence,以后只返回变化,没有变化时等待fakeMatrixSyncWait
+func (s *fakeMatrixServer) sync(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("access_token")
+	since := r.URL.Query().Get("since")
+	s.lock.Lock()
+	if _, ok := s.queues[token]; !ok || len(since) == 0 {
+		s.queues[token] = nil
+		for _, e := range s.presence {
+			s.queues[token] = append(s.queues[token], e)
+		}
+	}
+	s.lock.Unlock()
+	var events []*fakeMatrixEvent
+	deadline := time.Now().Add(fakeMatrixSyncWait)
+	for {
+		s.lock.Lock()
+		events, s.queues[token] = s.queues[token], nil
+		s.nextID++
+		next := s.nextID
+		down := s.down
+		s.lock.Unlock()
+		if down {
+			writeMatrixError(w, http.StatusInternalServerError, "M_UNKNOWN")
+			return
+		}
+		if len(events) > 0 || time.Now().After(deadline) {
+			resp := map[string]interface{}{
+				"next_batch": fmt.Sprintf("s%d", next),
+				"presence": map[string]interface{}{
+					"events": events,
+				},
+			}
+			writeMatrixJSON(w, http.StatusOK, resp)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,273 @@
+package blockchainlistener
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+fakeXMPPServer 测试用的xmpp服务器,只实现了pfs用到的部分:
+PLAIN登录,资源绑定,上下线通知,订阅/取消订阅(自动同意)和roster查询
+*/
+type fakeXMPPServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	sessions map[string]*fakeXMPPSession   //在线的用户,bare jid
+	rosters  map[string]map[string]bool    //bare jid -> 订阅了哪些bare jid
+	requests map[string]int                //bare jid -> 收到了多少次订阅请求
+	conns    map[*fakeXMPPSession]struct{} //所有连接,关闭服务器时断开
+	wg       sync.WaitGroup
+}
+
+type fakeXMPPSession struct {
+	conn     net.Conn
+	jid      string //bare jid
+	resource string
+	lock     sync.Mutex
+}
+
+func (s *fakeXMPPSession) write(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fmt.Fprintf(s.conn, format, args...)
+}
+
+func (s *fakeXMPPSession) fullJID() string {
+	return s.jid + "/" + s.resource
+}
+
+func newFakeXMPPServer(t *testing.T) *fakeXMPPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeXMPPServer{
+		listener: l,
+		sessions: make(map[string]*fakeXMPPSession),
+		rosters:  make(map[string]map[string]bool),
+		requests: make(map[string]int),
+		conns:    make(map[*fakeXMPPSession]struct{}),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			sess := &fakeXMPPSession{conn: conn}
+			s.lock.Lock()
+			s.conns[sess] = struct{}{}
+			s.lock.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(sess)
+			}()
+		}
+	}()
+	return s
+}
+
+//Addr host:port
+func (s *fakeXMPPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+//Close 断开所有连接
+func (s *fakeXMPPServer) Close() {
+	s.listener.Close()
+	s.lock.Lock()
+	for sess := range s.conns {
+		sess.conn.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+//Kick 服务器断开jid的连接
+func (s *fakeXMPPServer) Kick(jid string) {
+	s.lock.Lock()
+	sess := s.sessions[jid]
+	s.lock.Unlock()
+	if sess != nil {
+		sess.conn.Close()
+	}
+}
+
+//Online jid是否在线
+func (s *fakeXMPPServer) Online(jid string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sessions[jid] != nil
+}
+
+//Subscribed jid是否订阅了contact
+func (s *fakeXMPPServer) Subscribed(jid, contact string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rosters[jid][contact]
+}
+
+//SubscribeRequests jid一共发送了多少次订阅请求
+func (s *fakeXMPPServer) SubscribeRequests(jid string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[jid]
+}
+
+//ClearRoster 模拟服务器丢失了jid的roster
+func (s *fakeXMPPServer) ClearRoster(jid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.rosters, jid)
+}
+
+func (s *fakeXMPPServer) serve(sess *fakeXMPPSession) {
+	defer s.disconnect(sess)
+	defer sess.conn.Close()
+	d := xml.NewDecoder(sess.conn)
+	var user, domain string
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "stream":
+			//登录以后客户端会重新开始stream
+			for _, a := range se.Attr {
+				if a.Name.Local == "to" {
+					domain = a.Value
+				}
+			}
+			sess.write("<?xml version='1.0'?><stream:stream from='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", domain)
+			if len(user) == 0 {
+				sess.write("<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>")
+			} else {
+				sess.write("<stream:features><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/></stream:features>")
+			}
+		case "auth":
+			var auth struct {
+				Value string `xml:",chardata"`
+			}
+			if d.DecodeElement(&auth, &se) != nil {
+				return
+			}
+			raw, err := base64.StdEncoding.DecodeString(auth.Value)
+			parts := strings.Split(string(raw), "\x00")
+			if err != nil || len(parts) != 3 {
+				sess.write("<failure xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><not-authorized/></failure>")
+				return
+			}
+			user = parts[1] + "@" + domain
+			sess.write("<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>")
+		case "iq":
+			var iq struct {
+				ID   string `xml:"id,attr"`
+				Type string `xml:"type,attr"`
+				Bind *struct {
+					Resource string `xml:"resource"`
+				} `xml:"bind"`
+				Roster *struct{} `xml:"jabber:iq:roster query"`
+			}
+			if d.DecodeElement(&iq, &se) != nil {
+				return
+			}
+			switch {
+			case iq.Bind != nil:
+				sess.jid = user
+				sess.resource = iq.Bind.Resource
+				sess.write("<iq type='result' id='%s'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><jid>%s</jid></bind></iq>", iq.ID, sess.fullJID())
+			case iq.Roster != nil && iq.Type == "get":
+				sess.write("<iq type='result' id='%s' to='%s'><query xmlns='jabber:iq:roster'>%s</query></iq>", iq.ID, sess.fullJID(), s.rosterItems(sess.jid))
+			default:
+				sess.write("<iq type='result' id='%s'/>", iq.ID)
+			}
+		case "presence":
+			var p struct {
+				ID   string `xml:"id,attr"`
+				To   string `xml:"to,attr"`
+				Type string `xml:"type,attr"`
+			}
+			if d.DecodeElement(&p, &se) != nil {
+				return
+			}
+			s.handlePresence(sess, p.To, p.Type, p.ID)
+		default:
+			if d.Skip() != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeXMPPServer) rosterItems(jid string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var items []string
+	for contact := range s.rosters[jid] {
+		items = append(items, fmt.Sprintf("<item jid='%s' subscription='to'/>", contact))
+	}
+	return strings.Join(items, "")
+}
+
+//handlePresence 订阅请求自动同意,在线状态通知给所有订阅者
+func (s *fakeXMPPServer) handlePresence(sess *fakeXMPPSession, to, typ, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	to = strings.Split(to, "/")[0]
+	switch {
+	case len(to) == 0 && len(typ) == 0:
+		s.sessions[sess.jid] = sess
+		for jid, roster := range s.rosters {
+			if other := s.sessions[jid]; other != nil && roster[sess.jid] {
+				other.write("<presence from='%s'/>", sess.fullJID())
+			}
+		}
+		for contact := range s.rosters[sess.jid] {
+			if other := s.sessions[contact]; other != nil {
+				sess.write("<presence from='%s'/>", other.fullJID())
+			}
+		}
+	case typ == "subscribe":
+		s.requests[sess.jid]++
+		if s.rosters[sess.jid] == nil {
+			s.rosters[sess.jid] = make(map[string]bool)
+		}
+		s.rosters[sess.jid][to] = true
+		sess.write("<presence from='%s' to='%s' type='subscribed' id='%s'/>", to, sess.jid, id)
+		if other := s.sessions[to]; other != nil {
+			sess.write("<presence from='%s'/>", other.fullJID())
+		}
+	case typ == "unsubscribe":
+		delete(s.rosters[sess.jid], to)
+		sess.write("<presence from='%s' to='%s' type='unsubscribed' id='%s'/>", to, sess.jid, id)
+	}
+}
+
+//disconnect 连接断开,通知所有订阅者下线
+func (s *fakeXMPPServer) disconnect(sess *fakeXMPPSession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.conns, sess)
+	if s.sessions[sess.jid] != sess {
+		return
+	}
+	delete(s.sessions, sess.jid)
+	for jid, roster := range s.rosters {
+		if other := s.sessions[jid]; other != nil && roster[sess.jid] {
+			other.write("<presence from='%s' type='unavailable'/>", sess.fullJID())
+		}
+	}
+}
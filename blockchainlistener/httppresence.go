@@ -0,0 +1,173 @@
+package blockchainlistener
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//ErrHeartbeatNotEnabled 没有启用http心跳的上下线发现服务
+var ErrHeartbeatNotEnabled = errors.New("http presence is not enabled")
+
+//Heartbeat 节点通过http定时发送的心跳
+type Heartbeat struct {
+	DeviceType             string
+	IgnoreMediatedTransfer bool
+	Timestamp              int64 //节点发送心跳的时间,unix秒
+}
+
+//HeartbeatReceiver 能够接收节点http心跳的上下线发现服务
+type HeartbeatReceiver interface {
+	Heartbeat(address common.Address, hb *Heartbeat) error
+}
+
+//MediationListener 上下线发现服务还知道节点是否拒绝中转时,listener可以实现这个接口
+type MediationListener interface {
+	SetIgnoreMediatedTransfer(address common.Address, ignore bool)
+}
+
+type heartbeatState struct {
+	lastSeen               time.Time //pfs收到心跳的时间
+	timestamp              int64     //最近一次心跳中的时间,用于拒绝重放
+	deviceType             string
+	ignoreMediatedTransfer bool
+	online                 bool
+}
+
+/*
+HTTPPresence 节点定时通过 PUT /:peer/presence 发送签名的心跳,
+超过PresenceSilenceWindow没有收到心跳就认为节点下线了.
+适用于防火墙后面或者没有连接xmpp/matrix的节点
+*/
+type HTTPPresence struct {
+	lock     sync.Mutex
+	listener NodePresenceListener
+	nodes    map[common.Address]*heartbeatState
+	quit     chan struct{}
+	stopped  bool
+	lastSeen time.Time //最近一次收到心跳的时间
+}
+
+//NewHTTPPresence implements PresenceFactory
+func NewHTTPPresence(db *model.ModelDB, listener NodePresenceListener) (Transporter, error) {
+	h := newHTTPPresence(listener)
+	go h.loop()
+	return h, nil
+}
+
+func newHTTPPresence(listener NodePresenceListener) *HTTPPresence {
+	return &HTTPPresence{
+		listener: listener,
+		nodes:    make(map[common.Address]*heartbeatState),
+		quit:     make(chan struct{}),
+	}
+}
+
+func (h *HTTPPresence) loop() {
+	ticker := time.NewTicker(pparams.PresenceSilenceWindow / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case now := <-ticker.C:
+			h.expire(now)
+		}
+	}
+}
+
+/*
+expire 把超过PresenceSilenceWindow没有心跳的节点设置为下线.
+持有锁通知listener,保证通知的顺序和状态变化的顺序一致
+*/
+func (h *HTTPPresence) expire(now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for addr, s := range h.nodes {
+		if s.online && now.Sub(s.lastSeen) > pparams.PresenceSilenceWindow {
+			s.online = false
+			log.Trace(fmt.Sprintf("%s heartbeat timeout", addr.String()))
+			h.listener.Offline(addr)
+		}
+	}
+}
+
+//Heartbeat implements HeartbeatReceiver, 签名已经由调用者验证过
+func (h *HTTPPresence) Heartbeat(address common.Address, hb *Heartbeat) error {
+	now := time.Now()
+	skew := now.Sub(time.Unix(hb.Timestamp, 0))
+	if skew > pparams.HeartbeatMaxClockSkew || skew < -pparams.HeartbeatMaxClockSkew {
+		return fmt.Errorf("heartbeat timestamp %d too far from now", hb.Timestamp)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.stopped {
+		return errors.New("presence stopped")
+	}
+	s := h.nodes[address]
+	if s == nil {
+		s = &heartbeatState{}
+		h.nodes[address] = s
+	} else if hb.Timestamp <= s.timestamp {
+		return fmt.Errorf("heartbeat timestamp %d is not newer than %d", hb.Timestamp, s.timestamp)
+	}
+	changed := !s.online || s.deviceType != hb.DeviceType || s.ignoreMediatedTransfer != hb.IgnoreMediatedTransfer
+	s.lastSeen = now
+	h.lastSeen = now
+	s.timestamp = hb.Timestamp
+	s.deviceType = hb.DeviceType
+	s.ignoreMediatedTransfer = hb.IgnoreMediatedTransfer
+	s.online = true
+	//只有状态变化时才通知,避免每次心跳都写数据库
+	if changed {
+		h.listener.Online(address, hb.DeviceType)
+		if ml, ok := h.listener.(MediationListener); ok {
+			ml.SetIgnoreMediatedTransfer(address, hb.IgnoreMediatedTransfer)
+		}
+	}
+	return nil
+}
+
+//Stop implements Transporter
+func (h *HTTPPresence) Stop() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.stopped {
+		h.stopped = true
+		close(h.quit)
+	}
+}
+
+//Health implements HealthReporter
+func (h *HTTPPresence) Health() *PresenceHealth {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	r := &PresenceHealth{
+		Backend: "http",
+		Status:  PresenceStatusSyncing,
+		Healthy: !h.stopped,
+	}
+	if h.stopped {
+		r.Status = PresenceStatusStopped
+	}
+	if !h.lastSeen.IsZero() {
+		r.LastEvent = h.lastSeen.Unix()
+	}
+	return r
+}
+
+//SubscribeNeighbors implements Transporter, 节点自己发送心跳,不需要订阅
+func (h *HTTPPresence) SubscribeNeighbors(addrs []common.Address) error {
+	return nil
+}
+
+//Unsubscribe implements Transporter
+func (h *HTTPPresence) Unsubscribe(addr common.Address) error {
+	return nil
+}
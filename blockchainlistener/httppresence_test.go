@@ -0,0 +1,60 @@
+package blockchainlistener
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPPresence_Heartbeat(t *testing.T) {
+	defer noOfflineGrace()()
+	ast := assert.New(t)
+	tn, err := NewTokenNetwork(model.SetupTestDB(), nil, utils.NewRandomAddress(), nil, WithPresence(NewHTTPPresence))
+	ast.Nil(err)
+	h := tn.transport.(*HTTPPresence)
+	defer h.Stop()
+	addr := utils.NewRandomAddress()
+	now := time.Now()
+
+	err = tn.Heartbeat(addr, &Heartbeat{DeviceType: "other", Timestamp: now.Add(-2 * pparams.HeartbeatMaxClockSkew).Unix()})
+	ast.NotNil(err)
+	_, ok := tn.GetNodeState(addr)
+	ast.False(ok)
+
+	err = tn.Heartbeat(addr, &Heartbeat{DeviceType: "other", IgnoreMediatedTransfer: true, Timestamp: now.Unix()})
+	ast.Nil(err)
+	state, ok := tn.GetNodeState(addr)
+	ast.True(ok)
+	ast.True(state.Online)
+	ast.False(state.Mobile)
+	ast.True(state.IgnoreMediatedTransfer)
+	//重放
+	err = tn.Heartbeat(addr, &Heartbeat{DeviceType: "other", Timestamp: now.Unix()})
+	ast.NotNil(err)
+
+	//还没有超时
+	h.expire(time.Now().Add(pparams.PresenceSilenceWindow / 2))
+	state, _ = tn.GetNodeState(addr)
+	ast.True(state.Online)
+	h.expire(time.Now().Add(pparams.PresenceSilenceWindow + time.Second))
+	state, _ = tn.GetNodeState(addr)
+	ast.False(state.Online)
+
+	err = tn.Heartbeat(addr, &Heartbeat{DeviceType: "mobile", Timestamp: now.Unix() + 1})
+	ast.Nil(err)
+	state, _ = tn.GetNodeState(addr)
+	ast.True(state.Online)
+	ast.True(state.Mobile)
+	ast.False(state.IgnoreMediatedTransfer)
+
+	h.Stop()
+	err = tn.Heartbeat(addr, &Heartbeat{DeviceType: "mobile", Timestamp: now.Unix() + 2})
+	ast.NotNil(err)
+
+	tn2, _ := newTestTokenNetwork(model.SetupTestDB(), utils.NewRandomAddress())
+	ast.Equal(ErrHeartbeatNotEnabled, tn2.Heartbeat(addr, &Heartbeat{Timestamp: now.Unix()}))
+}
@@ -0,0 +1,193 @@
+package blockchainlistener
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//InboundChannel 邻居通过一个通道最多能转给target多少钱
+type InboundChannel struct {
+	Neighbor  common.Address `json:"neighbor"`
+	ChannelID common.Hash    `json:"channel_identifier"`
+	Capacity  *big.Int       `json:"capacity"` //邻居在通道中的余额
+	Fee       *big.Int       `json:"fee"`      //邻居转给target时收取的费用
+	//邻居能否作为中间节点,不在线,手机或者拒绝中转的节点不能
+	CanMediate bool `json:"can_mediate"`
+}
+
+//RouteHintHop 路由提示中的一跳,Node通过ChannelID转给下一跳
+type RouteHintHop struct {
+	Node      common.Address `json:"node"`
+	ChannelID common.Hash    `json:"channel_identifier"`
+	Fee       *big.Int       `json:"fee"`
+}
+
+/*
+RouteHint 可以放在收款请求中的最后几跳,付款方只需要找到到第一个节点的路径.
+Hops从入口节点开始,最后一跳的通道连接到target
+*/
+type RouteHint struct {
+	Hops []*RouteHintHop `json:"hops"`
+	Fee  *big.Int        `json:"fee"`
+}
+
+//Inbound target当前的收款能力
+type Inbound struct {
+	//所有能够中转的邻居的容量之和
+	TotalCapacity *big.Int          `json:"total_capacity"`
+	Channels      []*InboundChannel `json:"channels"`
+	RouteHints    []*RouteHint      `json:"route_hints"`
+}
+
+//reverseRoute 反向搜索时从某个节点到target费用最低的路径
+type reverseRoute struct {
+	hops []*RouteHintHop
+	fee  *big.Int
+}
+
+/*
+GetInbound 计算target收款value时,每个邻居能转给它多少钱,以及推荐的路由提示.
+路由提示通过从target开始在反向图上搜索得到,最多MaxRouteHintHops跳,
+每个邻居最多一条,优先选择入口节点可用通道多的(更容易被付款方找到),其次是费用低的
+*/
+func (t *TokenNetwork) GetInbound(target, tokenAddress common.Address, value *big.Int) (in *Inbound, err error) {
+	t.viewlock.RLock()
+	cs, ok := t.channelViews[tokenAddress]
+	t.viewlock.RUnlock()
+	if !ok {
+		err = fmt.Errorf("unkown token %s", tokenAddress.String())
+		return
+	}
+	in = &Inbound{
+		TotalCapacity: new(big.Int),
+		Channels:      []*InboundChannel{},
+		RouteHints:    []*RouteHint{},
+	}
+	t.nodeLock.Lock()
+	now := time.Now()
+	canMediate := func(addr common.Address) bool {
+		ns := t.participantStatus[addr]
+		return ns.routable(now) && !ns.isMobile && !ns.ignoreMediatedTransfer
+	}
+	for _, c := range cs {
+		if c.Participant1 != target && c.Participant2 != target {
+			continue
+		}
+		ic := &InboundChannel{
+			ChannelID: calcChannelID(tokenAddress, t.TokensNetworkAddress, c.Participant1, c.Participant2),
+		}
+		if c.Participant1 == target {
+			ic.Neighbor, ic.Capacity, ic.Fee = c.Participant2, c.Participant2Balance, calcFee(value, c.Participant2Fee)
+		} else {
+			ic.Neighbor, ic.Capacity, ic.Fee = c.Participant1, c.Participant1Balance, calcFee(value, c.Participant1Fee)
+		}
+		ic.CanMediate = canMediate(ic.Neighbor)
+		if ic.CanMediate {
+			in.TotalCapacity.Add(in.TotalCapacity, ic.Capacity)
+		}
+		in.Channels = append(in.Channels, ic)
+	}
+	t.nodeLock.Unlock()
+	sort.Slice(in.Channels, func(i, j int) bool {
+		return in.Channels[i].Capacity.Cmp(in.Channels[j].Capacity) > 0
+	})
+	usable := t.usableChannels(cs, target, target)
+	//degree 入口节点有多少可用的通道
+	degree := make(map[common.Address]int)
+	//predecessors[v] 所有能够转给v的通道
+	predecessors := make(map[common.Address][]*channel)
+	for _, c := range usable {
+		degree[c.Participant1]++
+		degree[c.Participant2]++
+		if c.Participant1Balance.Cmp(value) >= 0 {
+			predecessors[c.Participant2] = append(predecessors[c.Participant2], c)
+		}
+		if c.Participant2Balance.Cmp(value) >= 0 {
+			predecessors[c.Participant1] = append(predecessors[c.Participant1], c)
+		}
+	}
+	//限制跳数的Bellman-Ford,第k轮得到最多k跳到达target费用最低的路径
+	best := map[common.Address]*reverseRoute{
+		target: {fee: new(big.Int)},
+	}
+	for k := 0; k < pparams.MaxRouteHintHops; k++ {
+		next := make(map[common.Address]*reverseRoute)
+		for v, r := range best {
+			for _, c := range predecessors[v] {
+				u, fee := c.Participant1, c.Participant1Fee
+				if u == v {
+					u, fee = c.Participant2, c.Participant2Fee
+				}
+				if u == target || onReverseRoute(r, u) {
+					continue
+				}
+				f := new(big.Int).Add(r.fee, calcFee(value, fee))
+				if old := best[u]; old != nil && old.fee.Cmp(f) <= 0 {
+					continue
+				}
+				if old := next[u]; old != nil && old.fee.Cmp(f) <= 0 {
+					continue
+				}
+				hop := &RouteHintHop{
+					Node:      u,
+					ChannelID: calcChannelID(tokenAddress, t.TokensNetworkAddress, c.Participant1, c.Participant2),
+					Fee:       calcFee(value, fee),
+				}
+				next[u] = &reverseRoute{
+					hops: append([]*RouteHintHop{hop}, r.hops...),
+					fee:  f,
+				}
+			}
+		}
+		for u, r := range next {
+			best[u] = r
+		}
+	}
+	var routes []*reverseRoute
+	for u, r := range best {
+		if u != target {
+			routes = append(routes, r)
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		ei, ej := routes[i].hops[0].Node, routes[j].hops[0].Node
+		if degree[ei] != degree[ej] {
+			return degree[ei] > degree[ej]
+		}
+		if c := routes[i].fee.Cmp(routes[j].fee); c != 0 {
+			return c < 0
+		}
+		return len(routes[i].hops) < len(routes[j].hops)
+	})
+	//每个邻居最多一条,这样路由提示使用不同的通道进入target
+	lastHops := make(map[common.Address]bool)
+	for _, r := range routes {
+		if len(in.RouteHints) >= pparams.MaxRouteHints {
+			break
+		}
+		last := r.hops[len(r.hops)-1].Node
+		if lastHops[last] {
+			continue
+		}
+		lastHops[last] = true
+		in.RouteHints = append(in.RouteHints, &RouteHint{
+			Hops: r.hops,
+			Fee:  r.fee,
+		})
+	}
+	return
+}
+
+func onReverseRoute(r *reverseRoute, addr common.Address) bool {
+	for _, h := range r.hops {
+		if h.Node == addr {
+			return true
+		}
+	}
+	return false
+}
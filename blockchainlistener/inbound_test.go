@@ -0,0 +1,78 @@
+package blockchainlistener
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenNetwork_GetInbound(t *testing.T) {
+	defer noOfflineGrace()()
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	target, hub, g, offline := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	a, b, c := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address, b1, b2 int64, fee1 int64) *channel {
+		return &channel{
+			Participant1: p1,
+			Participant2: p2,
+			Participant1Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee1),
+			},
+			Participant2Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(1),
+			},
+			Participant1Balance: big.NewInt(b1),
+			Participant2Balance: big.NewInt(b2),
+			Token:               token,
+		}
+	}
+	tn := buildTestTN([]*channel{
+		newChannel(hub, target, 100, 0, 1),
+		newChannel(g, target, 80, 10, 2),
+		newChannel(offline, target, 50, 0, 1),
+		newChannel(hub, a, 100, 100, 1),
+		newChannel(hub, b, 100, 100, 1),
+		newChannel(hub, c, 100, 100, 1),
+		newChannel(g, a, 100, 100, 1),
+	})
+	testPresence(tn).Mobile(target)
+	testPresence(tn).Offline(offline)
+
+	in, err := tn.GetInbound(target, token, big.NewInt(10))
+	ast.Nil(err)
+	ast.EqualValues(3, len(in.Channels))
+	ast.EqualValues(hub, in.Channels[0].Neighbor)
+	ast.EqualValues(big.NewInt(100), in.Channels[0].Capacity)
+	ast.EqualValues(big.NewInt(1), in.Channels[0].Fee)
+	ast.True(in.Channels[0].CanMediate)
+	ast.EqualValues(g, in.Channels[1].Neighbor)
+	ast.EqualValues(offline, in.Channels[2].Neighbor)
+	ast.False(in.Channels[2].CanMediate)
+	ast.EqualValues(big.NewInt(180), in.TotalCapacity)
+
+	//每个邻居一条,可用通道多的优先
+	ast.EqualValues(2, len(in.RouteHints))
+	ast.EqualValues(1, len(in.RouteHints[0].Hops))
+	ast.EqualValues(hub, in.RouteHints[0].Hops[0].Node)
+	ast.EqualValues(calcChannelID(token, tn.TokensNetworkAddress, hub, target), in.RouteHints[0].Hops[0].ChannelID)
+	ast.EqualValues(g, in.RouteHints[1].Hops[len(in.RouteHints[1].Hops)-1].Node)
+
+	//金额太大时只有hub能转
+	in, err = tn.GetInbound(target, token, big.NewInt(90))
+	ast.Nil(err)
+	ast.EqualValues(1, len(in.RouteHints))
+	ast.EqualValues(hub, in.RouteHints[0].Hops[0].Node)
+	in, err = tn.GetInbound(target, token, big.NewInt(101))
+	ast.Nil(err)
+	ast.EqualValues(0, len(in.RouteHints))
+
+	_, err = tn.GetInbound(target, utils.NewRandomAddress(), big.NewInt(10))
+	ast.NotNil(err)
+}
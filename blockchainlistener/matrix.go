@@ -2,11 +2,18 @@ package blockchainlistener
 
 import (
 	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/SmartMeshFoundation/Photon/network/gomatrix"
 
@@ -50,9 +57,19 @@ type MatrixObserver struct {
 	UserID         string //the current user's ID(@kitty:thisserver)
 	nodeDeviceType string
 
-	log      log.Logger
-	running  bool
-	listener NodePresenceListener
+	log        log.Logger
+	listener   NodePresenceListener
+	config     *MatrixConfig
+	httpClient *http.Client
+	quit       chan struct{}
+	stopOnce   sync.Once
+	lock       sync.Mutex //保护matrixcli和下面的运行状态
+	server     string
+	status     string
+	lastSync   time.Time
+	lastEvent  time.Time
+	lastError  string
+	failovers  int
 }
 
 //NodePresenceListener for notification from transport
@@ -74,103 +91,261 @@ var (
 	DISCOVERYROOMSERVER = params.DiscoveryServer
 )
 
-// NewMatrixObserver init transport
-func NewMatrixObserver(listener NodePresenceListener) *MatrixObserver {
-	key := pparams.ObserverKey()
+//MatrixConfig matrix服务器的配置
+type MatrixConfig struct {
+	//homeserver的url,比如https://transport01.smartmesh.cn:8448,连接失败时按顺序切换
+	Servers []string
+	//验证https证书的CA文件,为空时使用系统的CA
+	CAFile string
+	//连接失败以后重试的间隔,每次失败加倍,带随机抖动
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+//DefaultMatrixConfig 根据params中的设置生成配置,没有指定服务器时使用http://MatrixServer:8008
+func DefaultMatrixConfig() *MatrixConfig {
+	servers := pparams.MatrixServers
+	if len(servers) == 0 {
+		servers = []string{fmt.Sprintf("http://%s:8008", pparams.MatrixServer)}
+	}
+	return &MatrixConfig{
+		Servers:    servers,
+		CAFile:     pparams.MatrixCAFile,
+		MinBackoff: pparams.PresenceMinBackoff,
+		MaxBackoff: pparams.PresenceMaxBackoff,
+	}
+}
+
+//maxSyncFailures 连续同步失败这么多次以后切换到下一个服务器
+const maxSyncFailures = 3
+
+//matrixSyncStale 超过这么长时间没有成功同步,认为matrix不健康,一次同步最长等待20秒
+const matrixSyncStale = time.Minute
+
+//newMatrixHTTPClient 使用caFile中的证书验证服务器,caFile为空时使用gomatrix默认的client
+func newMatrixHTTPClient(caFile string) (*http.Client, error) {
+	if len(caFile) == 0 {
+		return gomatrix.MatrixHTTPClient, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 30 * time.Second,
+			}).Dial,
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSClientConfig:       &tls.Config{RootCAs: pool},
+			MaxIdleConnsPerHost:   100,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+// NewMatrixObserver init transport, connects to config.Servers in background
+func NewMatrixObserver(key *ecdsa.PrivateKey, listener NodePresenceListener, config *MatrixConfig) (*MatrixObserver, error) {
+	if len(config.Servers) == 0 {
+		return nil, errors.New("no matrix server")
+	}
+	for _, server := range config.Servers {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matrix server %s: %s", server, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Hostname()) == 0 {
+			return nil, fmt.Errorf("invalid matrix server %s, should be like https://host:port", server)
+		}
+	}
+	client, err := newMatrixHTTPClient(config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("load matrix ca err %s", err)
+	}
 	mtr := &MatrixObserver{
 		nodeAddresses:  crypto.PubkeyToAddress(key.PublicKey),
 		key:            key,
 		nodeDeviceType: "other",
 		log:            log.New("transport", "finder"),
-		serverURL:      fmt.Sprintf("http://%s:8008", pparams.MatrixServer),
-		serverName:     pparams.MatrixServer,
-		running:        true,
 		listener:       listener,
+		config:         config,
+		httpClient:     client,
+		quit:           make(chan struct{}),
+		status:         PresenceStatusConnecting,
 	}
 	go mtr.start()
-	return mtr
+	return mtr, nil
+}
+
+func (m *MatrixObserver) isStopped() bool {
+	select {
+	case <-m.quit:
+		return true
+	default:
+		return false
+	}
 }
 
 // Stop Does Stop need to destroy transport resource ?
 func (m *MatrixObserver) Stop() {
-	m.running = false
-	if m.matrixcli != nil {
-		err := m.matrixcli.SetPresenceState(&gomatrix.ReqPresenceUser{
+	m.stopOnce.Do(func() {
+		close(m.quit)
+	})
+	m.lock.Lock()
+	cli := m.matrixcli
+	m.status = PresenceStatusStopped
+	m.lock.Unlock()
+	if cli != nil {
+		err := cli.SetPresenceState(&gomatrix.ReqPresenceUser{
 			Presence: OFFLINE,
 		})
 		if err != nil {
 			m.log.Error(fmt.Sprintf("[Matrix] SetPresenceState failed : %s", err.Error()))
 		}
-		m.matrixcli.StopSync()
-		if _, err := m.matrixcli.Logout(); err != nil {
+		cli.StopSync()
+		if _, err := cli.Logout(); err != nil {
 			m.log.Error("[Matrix] Logout failed")
 		}
 	}
 }
 
-// Start transport
-func (m *MatrixObserver) start() {
+//Health implements HealthReporter
+func (m *MatrixObserver) Health() *PresenceHealth {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h := &PresenceHealth{
+		Backend:   "matrix",
+		Server:    m.server,
+		Status:    m.status,
+		Healthy:   m.status == PresenceStatusSyncing && time.Since(m.lastSync) < matrixSyncStale,
+		LastError: m.lastError,
+		Failovers: m.failovers,
+	}
+	if !m.lastSync.IsZero() {
+		h.LastSync = m.lastSync.Unix()
+	}
+	if !m.lastEvent.IsZero() {
+		h.LastEvent = m.lastEvent.Unix()
+	}
+	return h
+}
 
-	for {
-		var err error
-		var syncer *gomatrix.DefaultSyncer
-		if !m.running {
-			return
+//setStatus 更新连接状态,err不为空时记录错误
+func (m *MatrixObserver) setStatus(status string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.status == PresenceStatusStopped {
+		return
+	}
+	m.status = status
+	if status == PresenceStatusSyncing && err == nil {
+		m.lastSync = time.Now()
+	}
+	if err != nil {
+		m.lastError = err.Error()
+	}
+}
+
+// Start transport, 依次尝试配置中的服务器,失败以后等待一段时间切换到下一个
+func (m *MatrixObserver) start() {
+	b := newBackoff(m.config.MinBackoff, m.config.MaxBackoff)
+	for i := 0; ; i++ {
+		server := m.config.Servers[i%len(m.config.Servers)]
+		if i > 0 {
+			m.lock.Lock()
+			m.failovers++
+			m.lock.Unlock()
 		}
-		m.matrixcli, err = gomatrix.NewClient(fmt.Sprintf("http://%s:8008", pparams.MatrixServer), "", "", PATHPREFIX0, m.log)
-		if err != nil {
-			log.Error(fmt.Sprintf("transport connection error %s", err))
-			time.Sleep(time.Second * 5)
-			continue
+		err := m.connect(server)
+		if err == nil {
+			err = m.syncLoop(b)
 		}
-		_, err = m.matrixcli.Versions()
-		if err != nil {
-			m.log.Error(fmt.Sprintf("Could not connect to requested server %s,and retrying,err %s", pparams.MatrixServer, err))
-			continue
+		if m.isStopped() {
+			return
 		}
-
-		// log in
-		if err = m.loginOrRegister(); err != nil {
-			m.log.Error(fmt.Sprintf("loginOrRegister err %s", err))
-			time.Sleep(time.Second * 5)
-			continue
+		m.setStatus(PresenceStatusFailed, err)
+		d := b.next()
+		m.log.Error(fmt.Sprintf("matrix server %s err %s, will try %s after %s",
+			server, err, m.config.Servers[(i+1)%len(m.config.Servers)], d))
+		if !sleep(d, m.quit) {
+			return
 		}
-		//initialize Filters/NextBatch/Rooms
-		m.matrixcli.Store = gomatrix.NewInMemoryStore()
+	}
+}
 
-		//handle the issue of discoveryroom,FOR TEST,temporarily retain this room
-		if err = m.joinDiscoveryRoom(); err != nil {
-			m.log.Error(fmt.Sprintf("joinDiscoveryRoom err %s", err))
-			goto tryNext
+//connect 登录server,加入discovery room,并注册上下线事件的处理
+func (m *MatrixObserver) connect(server string) (err error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return
+	}
+	cli, err := gomatrix.NewClient(strings.TrimRight(server, "/"), "", "", PATHPREFIX0, m.log)
+	if err != nil {
+		return fmt.Errorf("transport connection error %s", err)
+	}
+	cli.Client = m.httpClient
+	m.lock.Lock()
+	m.matrixcli = cli
+	m.server = server
+	m.lock.Unlock()
+	m.serverURL = strings.TrimRight(server, "/")
+	m.serverName = u.Hostname()
+	m.setStatus(PresenceStatusConnecting, nil)
+	_, err = cli.Versions()
+	if err != nil {
+		return fmt.Errorf("could not connect to requested server %s,err %s", server, err)
+	}
+	// log in
+	if err = m.loginOrRegister(); err != nil {
+		return fmt.Errorf("loginOrRegister err %s", err)
+	}
+	//initialize Filters/NextBatch/Rooms
+	cli.Store = gomatrix.NewInMemoryStore()
+	//handle the issue of discoveryroom,FOR TEST,temporarily retain this room
+	if err = m.joinDiscoveryRoom(); err != nil {
+		return fmt.Errorf("joinDiscoveryRoom err %s", err)
+	}
+	//notify to server i am online（include the other participating servers）
+	if err = cli.SetPresenceState(&gomatrix.ReqPresenceUser{
+		Presence:  ONLINE,
+		StatusMsg: m.nodeDeviceType, //register device type to server
+	}); err != nil {
+		return fmt.Errorf("SetPresenceState err %s", err)
+	}
+	//register receive-datahandle or other message received
+	cli.Syncer = gomatrix.NewDefaultSyncer(m.UserID, cli.Store)
+	syncer := cli.Syncer.(*gomatrix.DefaultSyncer)
+	syncer.OnEventType("m.presence", m.onHandlePresenceChange)
+	return nil
+}
+
+//syncLoop 一直同步,连续失败maxSyncFailures次以后返回错误,由start切换服务器
+func (m *MatrixObserver) syncLoop(b *backoff) error {
+	failures := 0
+	for {
+		err := m.matrixcli.Sync()
+		if m.isStopped() {
+			return nil
 		}
-		//notify to server i am online（include the other participating servers）
-		if err = m.matrixcli.SetPresenceState(&gomatrix.ReqPresenceUser{
-			Presence:  ONLINE,
-			StatusMsg: m.nodeDeviceType, //register device type to server
-		}); err != nil {
-			m.log.Error(fmt.Sprintf("SetPresenceState err %s", err))
-			goto tryNext
+		if err == nil {
+			failures = 0
+			b.reset()
+			m.setStatus(PresenceStatusSyncing, nil)
+			continue
 		}
-		//register receive-datahandle or other message received
-
-		m.matrixcli.Syncer = gomatrix.NewDefaultSyncer(m.UserID, m.matrixcli.Store)
-		syncer = m.matrixcli.Syncer.(*gomatrix.DefaultSyncer)
-
-		syncer.OnEventType("m.presence", m.onHandlePresenceChange)
-
-		for {
-			err2 := m.matrixcli.Sync()
-
-			if !m.running {
-				return
-			}
-			if err2 != nil {
-				m.log.Error(fmt.Sprintf("Matrix Sync return,err=%s ,will try agin..", err))
-				time.Sleep(time.Second * 5)
-			}
+		failures++
+		m.log.Error(fmt.Sprintf("Matrix Sync return,err=%s ,will try agin..", err))
+		if failures >= maxSyncFailures {
+			return fmt.Errorf("sync failed %d times,last err %s", failures, err)
+		}
+		m.setStatus(PresenceStatusSyncing, err)
+		if !sleep(b.next(), m.quit) {
+			return nil
 		}
-	tryNext:
-		time.Sleep(time.Second * 5)
 	}
 }
 
@@ -189,7 +364,7 @@ onHandlePresenceChange handle events in this message, about changes of nodes and
 }
 */
 func (m *MatrixObserver) onHandlePresenceChange(event *gomatrix.Event) {
-	if !m.running {
+	if m.isStopped() {
 		return
 	}
 	if event.Type != "m.presence" {
@@ -207,6 +382,9 @@ func (m *MatrixObserver) onHandlePresenceChange(event *gomatrix.Event) {
 		return
 	}*/
 	address := m.userIDToAddress(event.Sender)
+	m.lock.Lock()
+	m.lastEvent = time.Now()
+	m.lock.Unlock()
 
 	if presence == ONLINE {
 		m.listener.Online(address, deviceType)
@@ -1,15 +1,21 @@
 package blockchainlistener
 
 import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
-
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/gomatrix"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
 )
 
 func init() {
@@ -26,9 +32,154 @@ func (m *mockListener) Online(address common.Address, deviceType string) {
 func (m *mockListener) Offline(address common.Address) {
 	m.t.Logf("offline %s", address.String())
 }
+func newTestMatrixObserver(t *testing.T, servers ...string) (*MatrixObserver, *testlistener) {
+	key, _ := utils.MakePrivateKeyAddress()
+	l := &testlistener{
+		m:    make(map[common.Address]*nodeStatus),
+		name: "matrix",
+	}
+	m, err := NewMatrixObserver(key, l, &MatrixConfig{
+		Servers:    servers,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, l
+}
+
 func TestNewMatrixObserver(t *testing.T) {
-	model.SetupTestDB()
-	m := NewMatrixObserver(&mockListener{t})
-	time.Sleep(time.Second * 15)
+	ast := assert.New(t)
+	server := newFakeMatrixServer()
+	defer server.Close()
+	peer := utils.NewRandomAddress()
+	peerID := server.UserID(strings.ToLower(peer.String()))
+	//连接之前就在线的节点,第一次sync时收到
+	server.SetPresence(peerID, ONLINE, TypeMobile)
+	m, l := newTestMatrixObserver(t, server.URL)
+	ast.True(l.waitFor(peer, func(ns *nodeStatus) bool { return isOnline(ns) && ns.isMobile }))
+	ast.True(waitUntil(func() bool { return m.Health().Healthy }))
+	ast.Equal(ONLINE, server.Presence(m.UserID))
+
+	server.SetPresence(peerID, OFFLINE, "")
+	ast.True(l.waitFor(peer, isOffline))
+	server.SetPresence(peerID, ONLINE, TypeOtherDevice)
+	ast.True(l.waitFor(peer, func(ns *nodeStatus) bool { return isOnline(ns) && !ns.isMobile }))
+
+	m.Stop()
+	h := m.Health()
+	ast.False(h.Healthy)
+	ast.Equal(PresenceStatusStopped, h.Status)
+	ast.Equal(OFFLINE, server.Presence(m.UserID))
+}
+
+//第一个服务器故障时切换到第二个,第二个也故障时切换回第一个
+func TestMatrixObserver_FailoverToHealthyServer(t *testing.T) {
+	ast := assert.New(t)
+	s1 := newFakeMatrixServer()
+	defer s1.Close()
+	s2 := newFakeMatrixServer()
+	defer s2.Close()
+	s1.SetDown(true)
+	peer := utils.NewRandomAddress()
+	localpart := strings.ToLower(peer.String())
+	m, l := newTestMatrixObserver(t, s1.URL, s2.URL)
+	defer m.Stop()
+
+	ast.True(waitUntil(func() bool { return m.Health().Healthy }))
+	h := m.Health()
+	ast.Equal(s2.URL, h.Server)
+	ast.True(h.Failovers >= 1)
+	s2.SetPresence(s2.UserID(localpart), ONLINE, TypeMeshBox)
+	ast.True(l.waitFor(peer, isOnline))
+
+	s1.SetDown(false)
+	s2.SetDown(true)
+	ast.True(waitUntil(func() bool {
+		h := m.Health()
+		return h.Healthy && h.Server == s1.URL
+	}))
+	s1.SetPresence(s1.UserID(localpart), OFFLINE, "")
+	ast.True(l.waitFor(peer, isOffline))
+}
+
+func TestNewMatrixObserverConfig(t *testing.T) {
+	ast := assert.New(t)
+	key, _ := utils.MakePrivateKeyAddress()
+	for _, servers := range [][]string{nil, {"transport01.smartmesh.cn"}, {"ftp://transport01.smartmesh.cn"}} {
+		_, err := NewMatrixObserver(key, &mockListener{t}, &MatrixConfig{Servers: servers})
+		ast.NotNil(err, "%v", servers)
+	}
+	_, err := NewMatrixObserver(key, &mockListener{t}, &MatrixConfig{
+		Servers: []string{"https://transport01.smartmesh.cn:8448"},
+		CAFile:  "notexist.pem",
+	})
+	ast.NotNil(err)
+}
+
+func TestMatrixObserver_Failover(t *testing.T) {
+	ast := assert.New(t)
+	var lock sync.Mutex
+	hits := make(map[string]int)
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			hits[name]++
+			lock.Unlock()
+			http.Error(w, "down", http.StatusInternalServerError)
+		}
+	}
+	s1 := httptest.NewServer(handler("s1"))
+	defer s1.Close()
+	s2 := httptest.NewServer(handler("s2"))
+	defer s2.Close()
+	key, _ := utils.MakePrivateKeyAddress()
+	m, err := NewMatrixObserver(key, &mockListener{t}, &MatrixConfig{
+		Servers:    []string{s1.URL, s2.URL},
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+	if !ast.Nil(err) {
+		return
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && m.Health().Failovers < 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
 	m.Stop()
+	h := m.Health()
+	ast.True(h.Failovers >= 3)
+	ast.False(h.Healthy)
+	ast.Equal(PresenceStatusStopped, h.Status)
+	ast.NotEmpty(h.LastError)
+	lock.Lock()
+	ast.True(hits["s1"] > 0 && hits["s2"] > 0)
+	lock.Unlock()
+}
+
+func TestNewMatrixHTTPClient(t *testing.T) {
+	ast := assert.New(t)
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer s.Close()
+	f, err := ioutil.TempFile("", "matrixca")
+	if !ast.Nil(err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	f.Close()
+	client, err := newMatrixHTTPClient(f.Name())
+	if !ast.Nil(err) {
+		return
+	}
+	resp, err := client.Get(s.URL)
+	if ast.Nil(err) {
+		resp.Body.Close()
+	}
+	//不认识的证书
+	_, err = gomatrix.MatrixHTTPClient.Get(s.URL)
+	ast.NotNil(err)
 }
@@ -0,0 +1,96 @@
+package blockchainlistener
+
+import (
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+MemoryPresence 内存中的节点上下线发现服务,不需要连接任何服务器,主要用于测试.
+通过Online,Mobile,Offline模拟节点上下线,事件同步通知给listener.
+*/
+type MemoryPresence struct {
+	lock       sync.Mutex
+	listener   NodePresenceListener
+	subscribed map[common.Address]bool
+	stopped    bool
+}
+
+//NewMemoryPresence create an in-memory presence backend
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{
+		subscribed: make(map[common.Address]bool),
+	}
+}
+
+//Factory 用于WithPresence,所有通过它创建的TokenNetwork共用m
+func (m *MemoryPresence) Factory() PresenceFactory {
+	return func(db *model.ModelDB, listener NodePresenceListener) (Transporter, error) {
+		m.lock.Lock()
+		m.listener = listener
+		m.lock.Unlock()
+		return m, nil
+	}
+}
+
+func (m *MemoryPresence) getListener() NodePresenceListener {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopped {
+		return nil
+	}
+	return m.listener
+}
+
+//Online 模拟节点上线,deviceType和真实的发现服务一样,比如"mobile","other"
+func (m *MemoryPresence) Online(addr common.Address, deviceType string) {
+	if l := m.getListener(); l != nil {
+		l.Online(addr, deviceType)
+	}
+}
+
+//Mobile 模拟手机节点上线
+func (m *MemoryPresence) Mobile(addr common.Address) {
+	m.Online(addr, "mobile")
+}
+
+//Offline 模拟节点下线
+func (m *MemoryPresence) Offline(addr common.Address) {
+	if l := m.getListener(); l != nil {
+		l.Offline(addr)
+	}
+}
+
+//Subscribed 是否订阅了addr的上下线
+func (m *MemoryPresence) Subscribed(addr common.Address) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.subscribed[addr]
+}
+
+//Stop implements Transporter, no events are delivered after Stop
+func (m *MemoryPresence) Stop() {
+	m.lock.Lock()
+	m.stopped = true
+	m.lock.Unlock()
+}
+
+//SubscribeNeighbors implements Transporter
+func (m *MemoryPresence) SubscribeNeighbors(addrs []common.Address) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, addr := range addrs {
+		m.subscribed[addr] = true
+	}
+	return nil
+}
+
+//Unsubscribe implements Transporter
+func (m *MemoryPresence) Unsubscribe(addr common.Address) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.subscribed, addr)
+	return nil
+}
@@ -0,0 +1,266 @@
+package blockchainlistener
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//SourceState 一个上下线发现服务报告的节点状态
+type SourceState struct {
+	Online     bool
+	DeviceType string
+	Updated    time.Time //最近一次报告的时间
+}
+
+//MergePolicy 根据各个发现服务报告的状态计算节点最终的状态,states不为空
+type MergePolicy func(states map[string]*SourceState) (online bool, deviceType string)
+
+//MergeAny 只要有一个发现服务认为节点在线就在线,设备类型以最近报告在线的为准
+func MergeAny(states map[string]*SourceState) (online bool, deviceType string) {
+	var latest *SourceState
+	for _, s := range states {
+		if s.Online && (latest == nil || s.Updated.After(latest.Updated)) {
+			latest = s
+		}
+	}
+	if latest == nil {
+		return false, ""
+	}
+	return true, latest.DeviceType
+}
+
+//MergeLatest 以最近一次报告为准
+func MergeLatest(states map[string]*SourceState) (online bool, deviceType string) {
+	var latest *SourceState
+	for _, s := range states {
+		if latest == nil || s.Updated.After(latest.Updated) {
+			latest = s
+		}
+	}
+	return latest.Online, latest.DeviceType
+}
+
+//MergePolicyByName any 或者 latest
+func MergePolicyByName(name string) (MergePolicy, error) {
+	switch name {
+	case "any":
+		return MergeAny, nil
+	case "latest":
+		return MergeLatest, nil
+	}
+	return nil, fmt.Errorf("unknown presence merge policy %s", name)
+}
+
+type mergedState struct {
+	online     bool
+	deviceType string
+}
+
+/*
+MultiPresence 同时使用多个上下线发现服务,比如迁移期间一部分节点使用matrix,一部分使用xmpp.
+每个节点的状态由各个发现服务报告的状态按照policy合并,合并后的状态变化时才通知listener
+*/
+type MultiPresence struct {
+	lock     sync.Mutex
+	listener NodePresenceListener
+	policy   MergePolicy
+	names    []string
+	sources  []Transporter
+	states   map[common.Address]map[string]*SourceState
+	merged   map[common.Address]mergedState
+}
+
+//sourceListener 接收一个发现服务的通知
+type sourceListener struct {
+	m    *MultiPresence
+	name string
+}
+
+//Online implements NodePresenceListener
+func (l *sourceListener) Online(address common.Address, deviceType string) {
+	l.m.update(l.name, address, true, deviceType)
+}
+
+//Offline implements NodePresenceListener
+func (l *sourceListener) Offline(address common.Address) {
+	l.m.update(l.name, address, false, "")
+}
+
+//SetIgnoreMediatedTransfer implements MediationListener
+func (l *sourceListener) SetIgnoreMediatedTransfer(address common.Address, ignore bool) {
+	if ml, ok := l.m.listener.(MediationListener); ok {
+		ml.SetIgnoreMediatedTransfer(address, ignore)
+	}
+}
+
+//CombinePresence 同时使用names指定的多个发现服务,names的含义同PresenceByName
+func CombinePresence(policy MergePolicy, names []string) (PresenceFactory, error) {
+	var factories []PresenceFactory
+	for _, name := range names {
+		f, err := PresenceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		factories = append(factories, f)
+	}
+	return combinePresence(policy, names, factories), nil
+}
+
+func combinePresence(policy MergePolicy, names []string, factories []PresenceFactory) PresenceFactory {
+	return func(db *model.ModelDB, listener NodePresenceListener) (Transporter, error) {
+		m := &MultiPresence{
+			listener: listener,
+			policy:   policy,
+			names:    names,
+			states:   make(map[common.Address]map[string]*SourceState),
+			merged:   make(map[common.Address]mergedState),
+		}
+		for i, f := range factories {
+			s, err := f(db, &sourceListener{m, names[i]})
+			if err != nil {
+				m.Stop()
+				return nil, fmt.Errorf("presence %s err %s", names[i], err)
+			}
+			m.lock.Lock()
+			m.sources = append(m.sources, s)
+			m.lock.Unlock()
+		}
+		return m, nil
+	}
+}
+
+/*
+PresenceFromFlag 解析--presence参数,多个发现服务用逗号分隔,比如"matrix,xmpp",
+只有一个的时候直接使用它
+*/
+func PresenceFromFlag(flag string, policy MergePolicy) (PresenceFactory, error) {
+	names := strings.Split(flag, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	if len(names) == 1 {
+		return PresenceByName(names[0])
+	}
+	return CombinePresence(policy, names)
+}
+
+//update 持有锁通知listener,保证通知的顺序和状态变化的顺序一致
+func (m *MultiPresence) update(source string, address common.Address, online bool, deviceType string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	states := m.states[address]
+	if states == nil {
+		states = make(map[string]*SourceState)
+		m.states[address] = states
+	}
+	states[source] = &SourceState{
+		Online:     online,
+		DeviceType: deviceType,
+		Updated:    time.Now(),
+	}
+	var ms mergedState
+	ms.online, ms.deviceType = m.policy(states)
+	old, known := m.merged[address]
+	if known && old == ms {
+		return
+	}
+	m.merged[address] = ms
+	if ms.online {
+		m.listener.Online(address, ms.deviceType)
+	} else {
+		m.listener.Offline(address)
+	}
+}
+
+//Sources 各个发现服务报告的address的状态
+func (m *MultiPresence) Sources(address common.Address) map[string]SourceState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r := make(map[string]SourceState)
+	for name, s := range m.states[address] {
+		r[name] = *s
+	}
+	return r
+}
+
+//Heartbeat implements HeartbeatReceiver, 转给http发现服务
+func (m *MultiPresence) Heartbeat(address common.Address, hb *Heartbeat) error {
+	m.lock.Lock()
+	sources := m.sources
+	m.lock.Unlock()
+	for _, s := range sources {
+		if r, ok := s.(HeartbeatReceiver); ok {
+			return r.Heartbeat(address, hb)
+		}
+	}
+	return ErrHeartbeatNotEnabled
+}
+
+//Health implements HealthReporter, 只要有一个发现服务健康就认为是健康的
+func (m *MultiPresence) Health() *PresenceHealth {
+	m.lock.Lock()
+	sources := m.sources
+	m.lock.Unlock()
+	h := &PresenceHealth{
+		Backend: strings.Join(m.names, ","),
+		Status:  PresenceStatusFailed,
+	}
+	for i, s := range sources {
+		r, ok := s.(HealthReporter)
+		if !ok {
+			continue
+		}
+		sh := r.Health()
+		sh.Backend = m.names[i]
+		if sh.Healthy {
+			h.Healthy = true
+			h.Status = PresenceStatusSyncing
+		}
+		if sh.LastEvent > h.LastEvent {
+			h.LastEvent = sh.LastEvent
+		}
+		h.Sources = append(h.Sources, sh)
+	}
+	return h
+}
+
+//Stop implements Transporter
+func (m *MultiPresence) Stop() {
+	m.lock.Lock()
+	sources := m.sources
+	m.lock.Unlock()
+	for _, s := range sources {
+		s.Stop()
+	}
+}
+
+//SubscribeNeighbors implements Transporter
+func (m *MultiPresence) SubscribeNeighbors(addrs []common.Address) error {
+	m.lock.Lock()
+	sources := m.sources
+	m.lock.Unlock()
+	for i, s := range sources {
+		if err := s.SubscribeNeighbors(addrs); err != nil {
+			return fmt.Errorf("%s SubscribeNeighbors err %s", m.names[i], err)
+		}
+	}
+	return nil
+}
+
+//Unsubscribe implements Transporter
+func (m *MultiPresence) Unsubscribe(addr common.Address) error {
+	m.lock.Lock()
+	sources := m.sources
+	m.lock.Unlock()
+	for i, s := range sources {
+		if err := s.Unsubscribe(addr); err != nil {
+			return fmt.Errorf("%s Unsubscribe err %s", m.names[i], err)
+		}
+	}
+	return nil
+}
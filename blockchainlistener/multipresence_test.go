@@ -0,0 +1,63 @@
+package blockchainlistener
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiPresence(t *testing.T) {
+	defer noOfflineGrace()()
+	ast := assert.New(t)
+	matrix, xmpp := NewMemoryPresence(), NewMemoryPresence()
+	f := combinePresence(MergeAny, []string{"matrix", "xmpp"}, []PresenceFactory{matrix.Factory(), xmpp.Factory()})
+	tn, err := NewTokenNetwork(model.SetupTestDB(), nil, utils.NewRandomAddress(), nil, WithPresence(f))
+	ast.Nil(err)
+	m := tn.transport.(*MultiPresence)
+	addr := utils.NewRandomAddress()
+
+	ast.Nil(tn.transport.SubscribeNeighbors(nil))
+	matrix.Online(addr, "other")
+	state, _ := tn.GetNodeState(addr)
+	ast.True(state.Online)
+	xmpp.Offline(addr)
+	state, _ = tn.GetNodeState(addr)
+	ast.True(state.Online)
+	sources := m.Sources(addr)
+	ast.True(sources["matrix"].Online)
+	ast.False(sources["xmpp"].Online)
+	ast.False(sources["xmpp"].Updated.Before(sources["matrix"].Updated))
+
+	xmpp.Mobile(addr)
+	state, _ = tn.GetNodeState(addr)
+	ast.True(state.Online)
+	ast.True(state.Mobile)
+	matrix.Offline(addr)
+	xmpp.Offline(addr)
+	state, _ = tn.GetNodeState(addr)
+	ast.False(state.Online)
+
+	ast.Equal(ErrHeartbeatNotEnabled, tn.Heartbeat(addr, &Heartbeat{}))
+	m.Stop()
+}
+
+func TestMergeLatest(t *testing.T) {
+	defer noOfflineGrace()()
+	ast := assert.New(t)
+	matrix, xmpp := NewMemoryPresence(), NewMemoryPresence()
+	f := combinePresence(MergeLatest, []string{"matrix", "xmpp"}, []PresenceFactory{matrix.Factory(), xmpp.Factory()})
+	tn, err := NewTokenNetwork(model.SetupTestDB(), nil, utils.NewRandomAddress(), nil, WithPresence(f))
+	ast.Nil(err)
+	addr := utils.NewRandomAddress()
+	matrix.Online(addr, "other")
+	xmpp.Offline(addr)
+	state, _ := tn.GetNodeState(addr)
+	ast.False(state.Online)
+
+	_, err = PresenceFromFlag("matrix,foo", MergeAny)
+	ast.NotNil(err)
+	_, err = MergePolicyByName("foo")
+	ast.NotNil(err)
+}
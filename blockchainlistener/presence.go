@@ -0,0 +1,75 @@
+package blockchainlistener
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+)
+
+//PresenceFactory 创建节点上下线发现服务,节点上下线时通知listener
+type PresenceFactory func(db *model.ModelDB, listener NodePresenceListener) (Transporter, error)
+
+//上下线发现服务的连接状态
+const (
+	PresenceStatusConnecting = "connecting"
+	PresenceStatusSyncing    = "syncing"
+	PresenceStatusFailed     = "failed"
+	PresenceStatusStopped    = "stopped"
+)
+
+//PresenceHealth 上下线发现服务的运行状态
+type PresenceHealth struct {
+	Backend   string `json:"backend"`
+	Server    string `json:"server,omitempty"` //当前连接的服务器
+	Status    string `json:"status"`
+	Healthy   bool   `json:"healthy"`
+	LastSync  int64  `json:"last_sync,omitempty"`  //最近一次成功同步的时间,unix秒
+	LastEvent int64  `json:"last_event,omitempty"` //最近一次收到节点上下线的时间,unix秒
+	LastError string `json:"last_error,omitempty"`
+	Failovers int    `json:"failovers"` //切换服务器或者重连的次数
+	//同时使用多个发现服务时,每个发现服务的状态
+	Sources []*PresenceHealth `json:"sources,omitempty"`
+}
+
+//HealthReporter 可以报告运行状态的上下线发现服务
+type HealthReporter interface {
+	Health() *PresenceHealth
+}
+
+//MatrixPresence 通过matrix服务器发现节点上下线,服务器由params.MatrixServers指定
+func MatrixPresence(db *model.ModelDB, listener NodePresenceListener) (Transporter, error) {
+	return NewMatrixObserver(db.GetObserverKey(), listener, DefaultMatrixConfig())
+}
+
+//XMPPPresence 通过params.XMPPServer发现节点上下线
+func XMPPPresence(db *model.ModelDB, listener NodePresenceListener) (Transporter, error) {
+	return NewXMPPConnectionWithConfig(params.XMPPServer, db.GetObserverKey(), db, listener, DefaultXMPPConfig())
+}
+
+//PresenceByName 根据名字选择上下线发现服务,可以是matrix,xmpp或者http
+func PresenceByName(name string) (PresenceFactory, error) {
+	switch name {
+	case "matrix":
+		return MatrixPresence, nil
+	case "xmpp":
+		return XMPPPresence, nil
+	case "http":
+		return NewHTTPPresence, nil
+	}
+	return nil, fmt.Errorf("unknown presence %s", name)
+}
+
+//TokenNetworkOption NewTokenNetwork的可选参数
+type TokenNetworkOption func(t *tokenNetworkOptions)
+
+type tokenNetworkOptions struct {
+	presence PresenceFactory
+}
+
+//WithPresence 指定节点上下线发现服务,默认使用MatrixPresence
+func WithPresence(f PresenceFactory) TokenNetworkOption {
+	return func(o *tokenNetworkOptions) {
+		o.presence = f
+	}
+}
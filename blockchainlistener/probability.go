@@ -0,0 +1,138 @@
+package blockchainlistener
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+hopStats 通道一个方向上的统计信息,用于估计通过这一跳转账成功的概率.
+pfs知道的余额经常是过时的,因为节点不在线或者没有及时提交balance proof.
+*/
+type hopStats struct {
+	//最近一次得知这个方向可用余额的时间,为空表示没有信息,不做惩罚
+	balanceUpdated time.Time
+	//节点报告转账失败以后的惩罚,为空表示没有受到过惩罚
+	penalty *model.ChannelPenalty
+}
+
+/*
+probability 估计这一跳转账成功的概率.
+余额每过BalanceAgeHalfLife可信度减半,最小为MinHopProbability,再除以1+当前的惩罚.
+转账失败的反馈只通过惩罚影响成功概率,惩罚随时间衰减
+*/
+func (s *hopStats) probability(now time.Time) float64 {
+	p := 1.0
+	if !s.balanceUpdated.IsZero() && now.After(s.balanceUpdated) {
+		p = math.Pow(0.5, float64(now.Sub(s.balanceUpdated))/float64(pparams.BalanceAgeHalfLife))
+	}
+	if p < pparams.MinHopProbability {
+		p = pparams.MinHopProbability
+	}
+	if s.penalty != nil {
+		p /= 1 + s.penalty.Value(now)
+	}
+	return p
+}
+
+//stats 从from出发的方向的统计信息
+func (c *channel) stats(from common.Address) *hopStats {
+	if from == c.Participant1 {
+		return &c.Participant1Stats
+	}
+	return &c.Participant2Stats
+}
+
+//touchBalance 刚刚得知了from方向的余额
+func (t *TokenNetwork) touchBalance(c *channel, from common.Address) {
+	t.statsLock.Lock()
+	c.stats(from).balanceUpdated = time.Now()
+	t.statsLock.Unlock()
+}
+
+//touchBothBalance 刚刚得知了通道双方的余额,比如通道打开或者取钱
+func (t *TokenNetwork) touchBothBalance(c *channel) {
+	now := time.Now()
+	t.statsLock.Lock()
+	c.Participant1Stats.balanceUpdated = now
+	c.Participant2Stats.balanceUpdated = now
+	t.statsLock.Unlock()
+}
+
+/*
+scaleWeight 成功概率越低,权重越大,概率为1时权重不变.
+权重增加ProbabilityWeight*(-log2(p)),用加法而不是乘法,
+否则不收手续费的一跳(比如source自己的通道)几乎不受成功概率影响
+*/
+func scaleWeight(weight int, p float64) int {
+	w := math.Round(float64(weight) - pparams.ProbabilityWeight*math.Log2(p))
+	if w >= math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(w)
+}
+
+//lessFeeOverProbability fee1/p1 < fee2/p2, 相等时概率大的优先
+func lessFeeOverProbability(fee1 *big.Int, p1 float64, fee2 *big.Int, p2 float64) bool {
+	x := new(big.Float).Mul(new(big.Float).SetInt(fee1), big.NewFloat(p2))
+	y := new(big.Float).Mul(new(big.Float).SetInt(fee2), big.NewFloat(p1))
+	if c := x.Cmp(y); c != 0 {
+		return c < 0
+	}
+	return p1 > p2
+}
+
+/*
+HandlePathFeedback 节点reporter报告沿着path(从reporter开始,到target结束)转账的结果.
+failedHop<0表示转账成功,否则表示path[failedHop]->path[failedHop+1]这一跳失败了.
+reporter只是第一跳的参与方,后面的跳可能是编造的,所以只有failedHop为0,
+也就是reporter->path[1]这一跳失败时才会受到惩罚并保存,其他的报告只检查路径是否存在
+*/
+func (t *TokenNetwork) HandlePathFeedback(token, reporter common.Address, path []common.Address, failedHop int, reason string) error {
+	if len(path) < 2 || path[0] != reporter {
+		return errors.New("path must start with reporter")
+	}
+	if failedHop >= len(path)-1 {
+		return fmt.Errorf("failed hop %d out of range", failedHop)
+	}
+	var channelIDs []common.Hash
+	t.viewlock.RLock()
+	for i := 0; i < len(path)-1; i++ {
+		channelID := calcChannelID(token, t.TokensNetworkAddress, path[i], path[i+1])
+		if t.channels[channelID] == nil {
+			t.viewlock.RUnlock()
+			return fmt.Errorf("no channel between %s and %s", path[i].String(), path[i+1].String())
+		}
+		channelIDs = append(channelIDs, channelID)
+	}
+	t.viewlock.RUnlock()
+	if failedHop != 0 {
+		return nil
+	}
+	p, err := t.db.AddChannelPenalty(channelIDs[0], reporter, token, reporter, reason, pparams.FeedbackPenalty, time.Now())
+	if err != nil {
+		return err
+	}
+	t.setPenalty(channelIDs[0], p)
+	return nil
+}
+
+//setPenalty 更新内存中通道受到的惩罚
+func (t *TokenNetwork) setPenalty(channelID common.Hash, p *model.ChannelPenalty) {
+	t.viewlock.RLock()
+	c := t.channels[channelID]
+	t.viewlock.RUnlock()
+	if c == nil {
+		return
+	}
+	t.statsLock.Lock()
+	c.stats(common.HexToAddress(p.Participant)).penalty = p
+	t.statsLock.Unlock()
+}
@@ -0,0 +1,201 @@
+package blockchainlistener
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHopStats_probability(t *testing.T) {
+	ast := assert.New(t)
+	now := time.Now()
+	s := &hopStats{}
+	ast.EqualValues(1, s.probability(now))
+	s.balanceUpdated = now.Add(-pparams.BalanceAgeHalfLife)
+	ast.InDelta(0.5, s.probability(now), 1e-9)
+	s.penalty = &model.ChannelPenalty{Penalty: 1, PenalizedAt: now}
+	ast.InDelta(0.25, s.probability(now), 1e-9)
+	s.penalty = nil
+	s.balanceUpdated = now.Add(-100 * pparams.BalanceAgeHalfLife)
+	ast.EqualValues(pparams.MinHopProbability, s.probability(now))
+}
+
+func TestScaleWeight(t *testing.T) {
+	ast := assert.New(t)
+	ast.EqualValues(0, scaleWeight(0, 1))
+	ast.EqualValues(10, scaleWeight(10, 1))
+	k := int(pparams.ProbabilityWeight)
+	ast.EqualValues(10+k, scaleWeight(10, 0.5))
+	//不收手续费的一跳同样受成功概率影响
+	ast.EqualValues(k, scaleWeight(0, 0.5))
+	ast.EqualValues(2*k, scaleWeight(0, 0.25))
+	ast.EqualValues(math.MaxInt32, scaleWeight(math.MaxInt32, 0.5))
+	ast.True(lessFeeOverProbability(big.NewInt(10), 1, big.NewInt(6), 0.5))
+	ast.True(lessFeeOverProbability(big.NewInt(10), 1, big.NewInt(5), 0.5))
+	ast.False(lessFeeOverProbability(big.NewInt(10), 0.5, big.NewInt(5), 1))
+}
+
+func TestTokenNetwork_GetPathsWithFeedback(t *testing.T) {
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	addr1, addr2, addr3, addr4 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address, fee int64) *channel {
+		return &channel{
+			Participant1: p1,
+			Participant2: p2,
+			Participant1Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee),
+			},
+			Participant2Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee),
+			},
+			Participant1Balance: big.NewInt(100),
+			Participant2Balance: big.NewInt(100),
+			Token:               token,
+		}
+	}
+	c24 := newChannel(addr2, addr4, 1)
+	tn := buildTestTN([]*channel{
+		newChannel(addr1, addr2, 1),
+		c24,
+		newChannel(addr1, addr3, 2),
+		newChannel(addr3, addr4, 2),
+	})
+	paths, err := tn.GetPaths(addr1, addr4, token, big.NewInt(10), 5, "", false)
+	ast.Nil(err)
+	ast.EqualValues(1, len(paths))
+	ast.EqualValues([]common.Address{addr2, addr4}, paths[0].Result)
+	ast.EqualValues(1, paths[0].Probability)
+
+	//addr2报告自己转给addr4失败
+	ast.Nil(tn.HandlePathFeedback(token, addr2, []common.Address{addr2, addr4}, 0, "refused"))
+	//另一个方向没有影响
+	ast.Nil(c24.Participant2Stats.penalty)
+	paths, err = tn.GetPaths(addr1, addr4, token, big.NewInt(10), 5, "", false)
+	ast.Nil(err)
+	ast.EqualValues(1, len(paths))
+	ast.EqualValues([]common.Address{addr3, addr4}, paths[0].Result)
+	ast.EqualValues(2, paths[0].Fee.Int64())
+	ast.EqualValues(1, paths[0].Probability)
+
+	//余额过时同样降低成功概率
+	tn.touchBalance(c24, addr2)
+	c24.Participant1Stats.balanceUpdated = time.Now().Add(-10 * pparams.BalanceAgeHalfLife)
+	c24.Participant1Stats.penalty = nil
+	paths, err = tn.GetPaths(addr4, addr1, token, big.NewInt(10), 5, "", false)
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr2, addr1}, paths[0].Result)
+	paths, err = tn.GetPaths(addr1, addr4, token, big.NewInt(10), 5, "", false)
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr3, addr4}, paths[0].Result)
+}
+
+func TestTokenNetwork_HandlePathFeedback(t *testing.T) {
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	addr1, addr2, addr3, addr4 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address) *channel {
+		return &channel{
+			Participant1: p1,
+			Participant2: p2,
+			Participant1Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(1),
+			},
+			Participant2Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(1),
+			},
+			Participant1Balance: big.NewInt(100),
+			Participant2Balance: big.NewInt(100),
+			Token:               token,
+		}
+	}
+	c12, c24 := newChannel(addr1, addr2), newChannel(addr2, addr4)
+	tn := buildTestTN([]*channel{c12, c24, newChannel(addr1, addr3), newChannel(addr3, addr4)})
+
+	ast.NotNil(tn.HandlePathFeedback(token, addr1, []common.Address{addr2, addr4}, -1, ""))
+	ast.NotNil(tn.HandlePathFeedback(token, addr1, []common.Address{addr1, addr4}, -1, ""))
+	ast.NotNil(tn.HandlePathFeedback(token, addr1, []common.Address{addr1, addr2, addr4}, 2, "timeout"))
+
+	//reporter不是第二跳的参与方,第二跳失败时不能惩罚
+	ast.Nil(tn.HandlePathFeedback(token, addr1, []common.Address{addr1, addr2, addr4}, 1, "timeout"))
+	ast.Nil(c12.Participant1Stats.penalty)
+	ast.Nil(c24.Participant1Stats.penalty)
+	ps, err := tn.db.GetChannelPenalties()
+	ast.Nil(err)
+	ast.EqualValues(0, len(ps))
+
+	ast.Nil(tn.HandlePathFeedback(token, addr1, []common.Address{addr1, addr2, addr4}, 0, "refused"))
+	ast.NotNil(c12.Participant1Stats.penalty)
+	//一次失败只计算一次
+	ast.InDelta(1/(1+pparams.FeedbackPenalty), c12.Participant1Stats.probability(time.Now()), 1e-6)
+	ast.Nil(c12.Participant2Stats.penalty)
+	ast.Nil(c24.Participant1Stats.penalty)
+	ps, err = tn.db.GetChannelPenalties()
+	ast.Nil(err)
+	ast.EqualValues(1, len(ps))
+	ast.EqualValues(addr1.String(), ps[0].Participant)
+	ast.EqualValues(addr1.String(), ps[0].Reporter)
+
+	//两条路径费用相同,受到惩罚的路径排在后面
+	paths, err := tn.GetPaths(addr1, addr4, token, big.NewInt(10), 5, "", true)
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr3, addr4}, paths[0].Result)
+
+	//惩罚衰减以后恢复
+	c12.Participant1Stats.penalty.PenalizedAt = time.Now().Add(-100 * pparams.PenaltyHalfLife)
+	paths, err = tn.GetPaths(addr1, addr4, token, big.NewInt(10), 5, "", true)
+	ast.Nil(err)
+	ast.EqualValues(2, len(paths))
+	ast.InDelta(1, paths[0].Probability, 1e-6)
+	ast.InDelta(1, paths[1].Probability, 1e-6)
+}
+
+//source自己的通道不收手续费,成功概率低时同样应该避开
+func TestTokenNetwork_GetPathsAvoidFailingSourceHop(t *testing.T) {
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	addr1, addr2, addr3, addr4 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address, fee int64) *channel {
+		return &channel{
+			Participant1: p1,
+			Participant2: p2,
+			Participant1Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee),
+			},
+			Participant2Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(fee),
+			},
+			Participant1Balance: big.NewInt(100),
+			Participant2Balance: big.NewInt(100),
+			Token:               token,
+		}
+	}
+	c12 := newChannel(addr1, addr2, 1)
+	tn := buildTestTN([]*channel{
+		c12,
+		newChannel(addr2, addr4, 1),
+		newChannel(addr1, addr3, 1),
+		newChannel(addr3, addr4, 5),
+	})
+	paths, err := tn.GetPaths(addr1, addr4, token, big.NewInt(10), 5, "", false)
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr2, addr4}, paths[0].Result)
+
+	ast.Nil(tn.HandlePathFeedback(token, addr1, []common.Address{addr1, addr2}, 0, "refused"))
+	paths, err = tn.GetPaths(addr1, addr4, token, big.NewInt(10), 5, "", false)
+	ast.Nil(err)
+	ast.EqualValues([]common.Address{addr3, addr4}, paths[0].Result)
+}
@@ -0,0 +1,72 @@
+package blockchainlistener
+
+import (
+	"fmt"
+
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+)
+
+//Readiness 是否可以提供路由服务,用于负载均衡检查pfs是否可用
+type Readiness struct {
+	ChainID         int64    `json:"chain_id"`
+	Ready           bool     `json:"ready"`
+	EthConnected    bool     `json:"eth_connected"`
+	HeadBlock       int64    `json:"head_block"`      //链上最新块
+	ProcessedBlock  int64    `json:"processed_block"` //数据库中记录的已经处理完毕的块
+	BlockLag        int64    `json:"block_lag"`
+	DBConnected     bool     `json:"db_connected"`
+	PresenceHealthy bool     `json:"presence_healthy"`
+	Problems        []string `json:"problems,omitempty"` //不能提供服务的原因
+}
+
+func (r *Readiness) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+/*
+Readiness 检查以太坊连接,已处理块落后链上最新块多少(不能超过SyncedThreshold),
+数据库以及上下线服务的连接,正在回放历史事件或者正在退出时也不能提供服务
+*/
+func (ce *ChainEvents) Readiness() *Readiness {
+	r := &Readiness{
+		ChainID:         ce.chainID.Int64(),
+		PresenceHealthy: true,
+	}
+	if ce.isStopped() {
+		r.addProblem("stopping")
+	}
+	if ce.IsSyncing() {
+		r.addProblem("backfill history events")
+	}
+	if ce.client.IsConnected() {
+		head, err := ce.getHeadBlockNumber()
+		if err != nil {
+			r.addProblem("get head block err %s", err)
+		} else {
+			r.EthConnected = true
+			r.HeadBlock = head
+			ce.updateHeadBlock(head)
+		}
+	} else {
+		r.addProblem("ethereum client disconnected")
+	}
+	processed, err := ce.db.LatestBlockNumber()
+	if err != nil {
+		r.addProblem("db err %s", err)
+	} else {
+		r.DBConnected = true
+		r.ProcessedBlock = processed
+	}
+	if r.EthConnected && r.DBConnected {
+		r.BlockLag = r.HeadBlock - r.ProcessedBlock
+		if r.BlockLag > pparams.SyncedThreshold {
+			r.addProblem("processed block %d is %d blocks behind head %d", r.ProcessedBlock, r.BlockLag, r.HeadBlock)
+		}
+	}
+	if h := ce.TokenNetwork.PresenceHealth(); h != nil && !h.Healthy {
+		r.PresenceHealthy = false
+		r.addProblem("presence %s %s %s", h.Backend, h.Status, h.LastError)
+	}
+	r.Ready = len(r.Problems) == 0
+	return r
+}
@@ -0,0 +1,153 @@
+package blockchainlistener
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	pparams "github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestEthClient 只支持eth_getBlockByNumber的以太坊节点,最新块是head
+func newTestEthClient(t *testing.T, head int64) (*helper.SafeEthClient, func()) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_getBlockByNumber" {
+			http.Error(w, "unsupported", http.StatusBadRequest)
+			return
+		}
+		header := &types.Header{
+			Number:     big.NewInt(head),
+			Difficulty: big.NewInt(0),
+			Time:       big.NewInt(0),
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result":  header,
+		})
+	}))
+	c, err := ethclient.Dial(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &helper.SafeEthClient{Client: c, Status: netshare.Connected}, s.Close
+}
+
+func TestChainEvents_Readiness(t *testing.T) {
+	ast := assert.New(t)
+	old := pparams.SyncedThreshold
+	pparams.SyncedThreshold = 10
+	defer func() {
+		pparams.SyncedThreshold = old
+	}()
+	db := model.SetupTestDB()
+	tn, _ := newTestTokenNetwork(db, utils.NewRandomAddress())
+	client, closeClient := newTestEthClient(t, 120)
+	defer closeClient()
+	ce := &ChainEvents{
+		client:       client,
+		TokenNetwork: tn,
+		db:           db,
+		chainID:      big.NewInt(8888),
+	}
+
+	db.UpdateBlockNumber(100)
+	r := ce.Readiness()
+	ast.False(r.Ready)
+	ast.True(r.EthConnected)
+	ast.True(r.DBConnected)
+	ast.EqualValues(8888, r.ChainID)
+	ast.EqualValues(120, r.HeadBlock)
+	ast.EqualValues(100, r.ProcessedBlock)
+	ast.EqualValues(20, r.BlockLag)
+	ast.Equal(1, len(r.Problems), "%v", r.Problems)
+
+	db.UpdateBlockNumber(115)
+	r = ce.Readiness()
+	ast.True(r.Ready, "%v", r.Problems)
+	ast.True(r.PresenceHealthy)
+
+	//上下线服务断开
+	ce.TokenNetwork, _ = NewTokenNetwork(db, nil, utils.NewRandomAddress(), nil, WithPresence(NewHTTPPresence))
+	ce.TokenNetwork.Stop()
+	r = ce.Readiness()
+	ast.False(r.Ready)
+	ast.False(r.PresenceHealthy)
+	ce.TokenNetwork = tn
+
+	//以太坊节点断开
+	client.Status = netshare.Disconnected
+	r = ce.Readiness()
+	ast.False(r.Ready)
+	ast.False(r.EthConnected)
+	client.Status = netshare.Connected
+
+	db.CloseDB()
+	r = ce.Readiness()
+	ast.False(r.Ready)
+	ast.False(r.DBConnected)
+	ast.EqualValues(0, r.BlockLag)
+}
+
+//路由请求使用缓存的链上最新块判断是否同步完毕,没有开启回放历史事件时同样有效
+func TestChainEvents_IsSynced(t *testing.T) {
+	ast := assert.New(t)
+	old := pparams.SyncedThreshold
+	pparams.SyncedThreshold = 10
+	defer func() {
+		pparams.SyncedThreshold = old
+	}()
+	db := model.SetupTestDB()
+	client, closeClient := newTestEthClient(t, 120)
+	defer closeClient()
+	ce := &ChainEvents{
+		client:   client,
+		db:       db,
+		chainID:  big.NewInt(8888),
+		quitChan: make(chan struct{}),
+	}
+	ce.handleBlockNumber(db, 100)
+	ast.True(ce.IsSynced())
+	ce.refreshHeadBlock()
+	ast.False(ce.IsSynced())
+	ast.EqualValues(120, ce.SyncProgress().HeadBlock)
+	ce.handleBlockNumber(db, 109)
+	ast.False(ce.IsSynced())
+	ce.handleBlockNumber(db, 110)
+	ast.True(ce.IsSynced())
+	//回放历史事件时不能提供服务
+	ce.setSyncing(true)
+	ast.False(ce.IsSynced())
+	ce.setSyncing(false)
+
+	//定时更新链上最新块
+	oldInterval := pparams.HeadBlockPollInterval
+	pparams.HeadBlockPollInterval = time.Millisecond
+	defer func() {
+		pparams.HeadBlockPollInterval = oldInterval
+	}()
+	client2, closeClient2 := newTestEthClient(t, 200)
+	defer closeClient2()
+	ce.client = client2
+	go ce.pollHeadBlock()
+	defer close(ce.quitChan)
+	for i := 0; i < 100 && ce.IsSynced(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ast.False(ce.IsSynced())
+	ast.EqualValues(200, ce.SyncProgress().HeadBlock)
+}
@@ -0,0 +1,78 @@
+// +build !windows
+
+package blockchainlistener
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/blockchain"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+//不停提交请求的时候收到SIGTERM,已经提交的请求都要处理完,后来的请求返回ErrStopping,不能panic
+func TestChainEvents_StopOnSIGTERM(t *testing.T) {
+	ast := assert.New(t)
+	db := model.SetupTestDB()
+	tn, presence := newTestTokenNetwork(db, utils.NewRandomAddress())
+	ce := &ChainEvents{
+		be:                blockchain.NewBlockChainEvents(nil, nil, nil),
+		quitChan:          make(chan struct{}),
+		updateBalanceChan: make(chan transfer.StateChange, 10),
+		loopDone:          make(chan struct{}),
+		TokenNetwork:      tn,
+		db:                db,
+	}
+	go ce.loop()
+
+	quitSignal := make(chan os.Signal, 1)
+	signal.Notify(quitSignal, syscall.SIGTERM)
+	defer signal.Stop(quitSignal)
+
+	var lock sync.Mutex
+	results := make(map[error]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				st := &userRequestUpdateBalanceProofs{done: make(chan struct{})}
+				err := ce.submitUserRequest(st, st.done)
+				lock.Lock()
+				results[err]++
+				lock.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	ast.Nil(syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case <-quitSignal:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no SIGTERM received")
+	}
+	ce.Stop()
+	wg.Wait()
+	ast.Equal(0, len(ce.updateBalanceChan), "queued requests should be processed")
+	ast.True(results[nil] > 0)
+	ast.Equal(50, results[ErrStopping])
+	ast.Equal(2, len(results), "%v", results)
+	_, err := ce.HandleReceiveUserUpdateBalanceProofs(nil)
+	ast.Equal(ErrStopping, err)
+	//可以多次调用
+	ce.Stop()
+
+	ce.StopPresence()
+	ast.True(presence.stopped)
+}
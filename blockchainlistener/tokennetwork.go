@@ -12,7 +12,6 @@ import (
 	"errors"
 
 	"github.com/SmartMeshFoundation/Photon/log"
-	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/utils"
 
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
@@ -30,11 +29,24 @@ type channel struct {
 	Participant1Fee     *model.Fee
 	Participant2Fee     *model.Fee
 	Token               common.Address
+	Participant1Stats   hopStats //Participant1->Participant2方向
+	Participant2Stats   hopStats //Participant2->Participant1方向
 }
+//fieldSource 节点状态中一个字段是谁在什么时候更新的
+type fieldSource struct {
+	source  string
+	updated time.Time
+}
+
+//nodeStatus 节点状态,每个字段独立更新,互不影响
 type nodeStatus struct {
 	isMobile               bool
-	isOnline               bool
+	isOnline               bool //发现服务报告的状态
 	ignoreMediatedTransfer bool
+	mobileSource           fieldSource
+	onlineSource           fieldSource
+	ignoreSource           fieldSource
+	damping
 }
 
 // TokenNetwork token network view
@@ -47,11 +59,23 @@ type TokenNetwork struct {
 	viewlock             sync.RWMutex
 	participantStatus    map[common.Address]nodeStatus
 	nodeLock             sync.Mutex
+	persistLock          sync.Mutex //保证节点状态按照更新的顺序保存到数据库
+	statsLock            sync.Mutex //保护channel中的Participant1Stats和Participant2Stats
 	transport            Transporter
+	db                   *model.ModelDB
 }
 
-// NewTokenNetwork token network initialization
-func NewTokenNetwork(token2TokenNetwork map[common.Address]common.Address, tokensNetworkAddress common.Address, useMatrix bool, decimals map[common.Address]int) (twork *TokenNetwork) {
+/*
+NewTokenNetwork token network initialization,
+节点上下线发现服务默认使用matrix,可以通过WithPresence指定
+*/
+func NewTokenNetwork(db *model.ModelDB, token2TokenNetwork map[common.Address]common.Address, tokensNetworkAddress common.Address, decimals map[common.Address]int, opts ...TokenNetworkOption) (twork *TokenNetwork, err error) {
+	o := &tokenNetworkOptions{
+		presence: MatrixPresence,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
 	//read channel view from db
 	twork = &TokenNetwork{
 		TokensNetworkAddress: tokensNetworkAddress,
@@ -60,6 +84,7 @@ func NewTokenNetwork(token2TokenNetwork map[common.Address]common.Address, token
 		token2TokenNetwork:   make(map[common.Address]common.Address),
 		decimals:             make(map[common.Address]int),
 		participantStatus:    make(map[common.Address]nodeStatus),
+		db:                   db,
 	}
 	if decimals != nil {
 		twork.decimals = decimals
@@ -67,58 +92,100 @@ func NewTokenNetwork(token2TokenNetwork map[common.Address]common.Address, token
 	for t, tn := range token2TokenNetwork {
 		twork.token2TokenNetwork[t] = tn
 	}
-	if useMatrix {
-		twork.transport = NewMatrixObserver(twork)
-	} else {
-		var err error
-		twork.transport, err = NewXMPPConnection(params.DefaultXMPPServer, dbXMPPWrapper{}, twork)
-		if err != nil {
-			log.Crit(fmt.Sprintf("NewXMPPConnection err %s", err))
+	//上下线发现服务启动以后就会通知节点状态,必须先加载数据库中保存的状态,否则会被旧的状态覆盖
+	twork.nodeLock.Lock()
+	for _, n := range twork.db.GetAllNodes() {
+		twork.participantStatus[common.HexToAddress(n.Address)] = nodeStatus{
+			isOnline:               n.IsOnline,
+			isMobile:               n.DeviceType == "mobile",
+			ignoreMediatedTransfer: n.IgnoreMediatedTransfer,
+			mobileSource:           fieldSource{n.DeviceTypeSource, n.DeviceTypeUpdatedAt},
+			onlineSource:           fieldSource{n.OnlineSource, n.OnlineUpdatedAt},
+			ignoreSource:           fieldSource{n.IgnoreMediatedTransferSource, n.IgnoreMediatedTransferUpdatedAt},
 		}
 	}
-	for token := range twork.token2TokenNetwork {
-		cs, err := model.GetAllTokenChannels(token)
+	twork.nodeLock.Unlock()
+	twork.transport, err = o.presence(db, twork)
+	if err != nil {
+		return nil, fmt.Errorf("create presence backend err %s", err)
+	}
+	err = twork.loadChannels()
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+/*
+loadChannels 从数据库加载通道图以及通道受到的惩罚,替换内存中的通道图.
+批量回放历史事件失败回滚以后,内存中的通道图可能已经部分更新,需要重新加载.
+已经存在的通道保留内存中的统计信息
+*/
+func (t *TokenNetwork) loadChannels() error {
+	channelViews := make(map[common.Address][]*channel)
+	channels := make(map[common.Hash]*channel)
+	t.viewlock.RLock()
+	var tokens []common.Address
+	for token := range t.token2TokenNetwork {
+		tokens = append(tokens, token)
+	}
+	old := t.channels
+	t.viewlock.RUnlock()
+	for _, token := range tokens {
+		cs, err := t.db.GetAllTokenChannels(token)
 		if err != nil {
-			panic(err)
+			return err
 		}
 		var cs2 []*channel
 		for _, c := range cs {
-			//token := common.HexToAddress(c.Token)
+			channelID := common.HexToHash(c.ChannelID)
 			c2 := &channel{
 				Participant1:        common.HexToAddress(c.Participants[0].Participant),
 				Participant2:        common.HexToAddress(c.Participants[1].Participant),
 				Participant1Balance: c.Participants[0].BalanceValue(),
 				Participant2Balance: c.Participants[1].BalanceValue(),
-				Participant1Fee:     c.Participants[0].Fee(token),
-				Participant2Fee:     c.Participants[1].Fee(token),
+				Participant1Fee:     t.participantFee(c.Participants[0], token),
+				Participant2Fee:     t.participantFee(c.Participants[1], token),
 				Token:               token,
 			}
+			if c1 := old[channelID]; c1 != nil && c1.Participant1 == c2.Participant1 {
+				t.statsLock.Lock()
+				c2.Participant1Stats = c1.Participant1Stats
+				c2.Participant2Stats = c1.Participant2Stats
+				t.statsLock.Unlock()
+			} else {
+				//重启以后不知道余额是什么时候更新的,从现在开始计算
+				t.touchBothBalance(c2)
+			}
 			cs2 = append(cs2, c2)
-			twork.channels[common.HexToHash(c.ChannelID)] = c2
-			err = twork.transport.SubscribeNeighbors([]common.Address{c2.Participant1, c2.Participant2})
+			channels[channelID] = c2
+			err = t.transport.SubscribeNeighbors([]common.Address{c2.Participant1, c2.Participant2})
 			if err != nil {
 				log.Error(fmt.Sprintf("SubscribeNeighbors err %s", err))
 			}
 		}
-		twork.channelViews[token] = cs2
+		channelViews[token] = cs2
 	}
-	nodes := model.GetAllNodes()
-	for _, n := range nodes {
-		twork.participantStatus[common.HexToAddress(n.Address)] = nodeStatus{
-			isOnline:               n.IsOnline,
-			isMobile:               n.DeviceType == "mobile",
-			ignoreMediatedTransfer: false, // 默认false,节点提交balance的时候来更新
-		}
+	t.viewlock.Lock()
+	t.channelViews = channelViews
+	t.channels = channels
+	t.viewlock.Unlock()
+	penalties, err := t.db.GetChannelPenalties()
+	if err != nil {
+		return err
 	}
-	return
+	for _, p := range penalties {
+		t.setPenalty(common.HexToHash(p.ChannelID), p)
+	}
+	return nil
 }
 
 // handleChannelOpenedEvent Handle ChannelOpened Event
-func (t *TokenNetwork) handleChannelOpenedEvent(tokenAddress common.Address, channelID common.Hash, participant1, participant2 common.Address, blockNumber int64) (err error) {
+func (t *TokenNetwork) handleChannelOpenedEvent(db *model.ModelDB, tokenAddress common.Address, channelID common.Hash, participant1, participant2 common.Address, blockNumber int64) (err error) {
 	if t.channels[channelID] != nil {
 		return fmt.Errorf("channel open duplicate for %s", channelID.String())
 	}
-	c, err := model.AddChannel(tokenAddress, participant1, participant2, channelID, blockNumber)
+	c, err := db.AddChannel(tokenAddress, participant1, participant2, channelID, blockNumber)
 	if err != nil {
 		return
 	}
@@ -127,10 +194,11 @@ func (t *TokenNetwork) handleChannelOpenedEvent(tokenAddress common.Address, cha
 		Participant2:        common.HexToAddress(c.Participants[1].Participant),
 		Participant1Balance: big.NewInt(0),
 		Participant2Balance: big.NewInt(0),
-		Participant1Fee:     c.Participants[0].Fee(tokenAddress),
-		Participant2Fee:     c.Participants[1].Fee(tokenAddress),
+		Participant1Fee:     t.participantFee(c.Participants[0], tokenAddress),
+		Participant2Fee:     t.participantFee(c.Participants[1], tokenAddress),
 		Token:               tokenAddress,
 	}
+	t.touchBothBalance(c2)
 	err = t.transport.SubscribeNeighbors([]common.Address{c2.Participant1, c2.Participant2})
 	if err != nil {
 		log.Error(fmt.Sprintf("SubscribeNeighbors err %s", err))
@@ -148,16 +216,16 @@ func (t *TokenNetwork) handleChannelOpenedEvent(tokenAddress common.Address, cha
 	//log.Trace(fmt.Sprintf("handleChannelOpenedEvent token=%s, channelViews=%s", utils.APex2(tokenAddress), utils.StringInterface(cs, 5)))
 	return
 }
-func (t *TokenNetwork) handleTokenNetworkAdded(token common.Address, blockNumber int64, decimal uint8) (err error) {
+func (t *TokenNetwork) handleTokenNetworkAdded(db *model.ModelDB, token common.Address, blockNumber int64, decimal uint8) (err error) {
 
 	t.token2TokenNetwork[token] = utils.EmptyAddress
 	t.decimals[token] = int(decimal)
-	err = model.AddTokeNetwork(token, utils.EmptyAddress, blockNumber)
+	err = db.AddTokeNetwork(token, utils.EmptyAddress, blockNumber)
 	return
 }
 
-func (t *TokenNetwork) handleChannelSettled(channelID common.Hash) (err error) {
-	_, err = model.SettleChannel(channelID)
+func (t *TokenNetwork) handleChannelSettled(db *model.ModelDB, channelID common.Hash) (err error) {
+	_, err = db.SettleChannel(channelID)
 	return //do nothing ,already removed when closed
 }
 func (t *TokenNetwork) doRemoveChannel(token common.Address, channelID common.Hash) (err error) {
@@ -193,8 +261,8 @@ func (t *TokenNetwork) doRemoveChannel(token common.Address, channelID common.Ha
 	t.channelViews[token] = cs
 	return
 }
-func (t *TokenNetwork) handleChannelCooperativeSettled(channelID common.Hash) (err error) {
-	c, err := model.SettleChannel(channelID)
+func (t *TokenNetwork) handleChannelCooperativeSettled(db *model.ModelDB, channelID common.Hash) (err error) {
+	c, err := db.SettleChannel(channelID)
 	if err != nil {
 		return
 	}
@@ -202,8 +270,8 @@ func (t *TokenNetwork) handleChannelCooperativeSettled(channelID common.Hash) (e
 }
 
 // handleChannelDepositEvent Handle Channel Deposit Event
-func (t *TokenNetwork) handleChannelDepositEvent(channelID common.Hash, participant common.Address, totalDeposit *big.Int) (err error) {
-	c, err := model.UpdateChannelDeposit(channelID, participant, totalDeposit)
+func (t *TokenNetwork) handleChannelDepositEvent(db *model.ModelDB, channelID common.Hash, participant common.Address, totalDeposit *big.Int) (err error) {
+	c, err := db.UpdateChannelDeposit(channelID, participant, totalDeposit)
 	if err != nil {
 		return
 	}
@@ -219,8 +287,8 @@ func (t *TokenNetwork) handleChannelDepositEvent(channelID common.Hash, particip
 }
 
 // handleChannelClosedEvent Handle Channel Closed Event
-func (t *TokenNetwork) handleChannelClosedEvent(channelID common.Hash) (err error) {
-	c, err := model.CloseChannel(channelID)
+func (t *TokenNetwork) handleChannelClosedEvent(db *model.ModelDB, channelID common.Hash) (err error) {
+	c, err := db.CloseChannel(channelID)
 	if err != nil {
 		return
 	}
@@ -228,9 +296,9 @@ func (t *TokenNetwork) handleChannelClosedEvent(channelID common.Hash) (err erro
 }
 
 // handleChannelWithdrawEvent Handle Channel Withdaw Event
-func (t *TokenNetwork) handleChannelWithdrawEvent(channelID common.Hash,
+func (t *TokenNetwork) handleChannelWithdrawEvent(db *model.ModelDB, channelID common.Hash,
 	participant1, participant2 common.Address, participant1Balance, participant2Balance *big.Int, blockNumber int64) (err error) {
-	c, err := model.WithDrawChannel(channelID, participant1, participant2, participant1Balance, participant2Balance, blockNumber)
+	c, err := db.WithDrawChannel(channelID, participant1, participant2, participant1Balance, participant2Balance, blockNumber)
 	if err != nil {
 		return
 	}
@@ -242,36 +310,41 @@ func (t *TokenNetwork) handleChannelWithdrawEvent(channelID common.Hash,
 	}
 	c2.Participant1Balance = c.Participants[0].BalanceValue()
 	c2.Participant2Balance = c.Participants[1].BalanceValue()
+	t.touchBothBalance(c2)
 	return
 }
 
 // UpdateBalance Update Balance
-func (t *TokenNetwork) UpdateBalance(participant, partner common.Address, lockedAmount *big.Int, partnerBalanceProof *model.BalanceProof, ignoreMediatedTransfer bool) (err error) {
-	c, err := model.UpdateChannelBalanceProof(participant, partner, lockedAmount, partnerBalanceProof, ignoreMediatedTransfer)
+func (t *TokenNetwork) UpdateBalance(participant, partner common.Address, lockedAmount *big.Int, partnerBalanceProof *model.BalanceProof, ignoreMediatedTransfer bool) (c *model.Channel, err error) {
+	return t.updateBalance(t.db, participant, partner, lockedAmount, partnerBalanceProof, ignoreMediatedTransfer)
+}
+
+//updateBalance 通过db更新,db可以是Transaction中的ModelDB
+func (t *TokenNetwork) updateBalance(db *model.ModelDB, participant, partner common.Address, lockedAmount *big.Int, partnerBalanceProof *model.BalanceProof, ignoreMediatedTransfer bool) (c *model.Channel, err error) {
+	c, err = db.UpdateChannelBalanceProof(participant, partner, lockedAmount, partnerBalanceProof, ignoreMediatedTransfer)
 	if err != nil {
 		return
 	}
 	c2 := t.channels[partnerBalanceProof.ChannelID]
 	if c2 == nil {
-		return fmt.Errorf("update balance proof,but channel %s unkown", partnerBalanceProof.ChannelID.String())
+		err = fmt.Errorf("update balance proof,but channel %s unkown", partnerBalanceProof.ChannelID.String())
+		return
 	}
 	c2.Participant1Balance = c.Participants[0].BalanceValue()
 	c2.Participant2Balance = c.Participants[1].BalanceValue()
-	ns, ok := t.participantStatus[participant]
-	if !ok {
-		log.Error(fmt.Sprintf("can not find node status of %s", participant.String()))
-		return
-	}
-	ns.ignoreMediatedTransfer = ignoreMediatedTransfer
+	//partner签名的balance proof,说明partner转出了多少钱,partner->participant方向的余额是最新的
+	t.touchBalance(c2, partner)
+	t.setIgnoreMediatedTransfer(db, participant, ignoreMediatedTransfer, model.NodeSourceBalanceProof)
 	return
 }
 
 // PathResult is the json response for GetPaths
 type PathResult struct {
-	PathID  int              `json:"path_id"`  //从0开始
-	PathHop int              `json:"path_hop"` //中间有多少跳,不计入源,目的节点
-	Fee     *big.Int         `json:"fee"`
-	Result  []common.Address `json:"result"`
+	PathID      int              `json:"path_id"`  //从0开始
+	PathHop     int              `json:"path_hop"` //中间有多少跳,不计入源,目的节点
+	Fee         *big.Int         `json:"fee"`
+	Result      []common.Address `json:"result"`
+	Probability float64          `json:"probability"` //估计的转账成功概率
 }
 
 func (t *TokenNetwork) checkorder(cs []*channel) {
@@ -284,9 +357,51 @@ func (t *TokenNetwork) checkorder(cs []*channel) {
 	}
 }
 
+//通道不能用于路由的原因
+type filterReason int
+
+const (
+	filterNone filterReason = iota
+	filterOffline
+	filterMobile
+	filterIgnoreMediatedTransfer
+)
+
+//filterChannel 检查通道是否可以用于source到target的路由,与金额无关,调用者必须持有nodeLock
+func (t *TokenNetwork) filterChannel(c *channel, source, target common.Address) filterReason {
+	//忽略所有不在线的节点
+	now := time.Now()
+	ns1, ns2 := t.participantStatus[c.Participant1], t.participantStatus[c.Participant2]
+	if !ns1.routable(now) || !ns2.routable(now) {
+		return filterOffline
+	}
+	//手机节点不能作为路由中间结点
+	if t.participantStatus[c.Participant1].isMobile && c.Participant1 != source && c.Participant1 != target {
+		return filterMobile
+	}
+	//通道双方只要有一个是手机并且既不是发起方也不是接收方,都应该 跳过
+	if t.participantStatus[c.Participant2].isMobile && c.Participant2 != source && c.Participant2 != target {
+		return filterMobile
+	}
+	//通道双方只要有一个启用了ignoreMediatedTransfer参数,且既不是发送方也不是接收方,都应该跳过
+	if t.participantStatus[c.Participant1].ignoreMediatedTransfer && c.Participant1 != source && c.Participant1 != target {
+		return filterIgnoreMediatedTransfer
+	}
+	if t.participantStatus[c.Participant2].ignoreMediatedTransfer && c.Participant2 != source && c.Participant2 != target {
+		return filterIgnoreMediatedTransfer
+	}
+	return filterNone
+}
+
 // GetPaths get the lowest fee  path
 func (t *TokenNetwork) GetPaths(source common.Address, target common.Address, tokenAddress common.Address,
 	value *big.Int, limitPaths int, sortDemand string, sourceChargeFee bool) (pathinfos []*PathResult, err error) {
+	return t.getPaths(source, target, tokenAddress, value, limitPaths, sortDemand, sourceChargeFee, nil)
+}
+
+//getPaths 作图时跳过ex中的节点和通道,并且在搜索时满足ex中边数和费用的限制,ex为空表示没有限制
+func (t *TokenNetwork) getPaths(source common.Address, target common.Address, tokenAddress common.Address,
+	value *big.Int, limitPaths int, sortDemand string, sourceChargeFee bool, ex *searchLimits) (pathinfos []*PathResult, err error) {
 	//todo 1\移除余额不够的边,2\移除节点不在线所处的通道,3\移除节点类型是手机的节点所处的通道matrix,4\移除节点不在线所处的所有通道matrix,5\移除节点网络状态为不在线的matrix
 	t.viewlock.RLock()
 	cs, ok := t.channelViews[tokenAddress]
@@ -306,32 +421,38 @@ func (t *TokenNetwork) GetPaths(source common.Address, target common.Address, to
 	djGraph := *dijkstra.NewEmptyGraph()
 	gPeerToIndex := make(map[common.Address]int)
 	gIndex := -1
+	//hopProbability[from][to] 每一跳成功的概率
+	hopProbability := make(map[common.Address]map[common.Address]float64)
+	setHopProbability := func(from, to common.Address, p float64) {
+		if hopProbability[from] == nil {
+			hopProbability[from] = make(map[common.Address]float64)
+		}
+		hopProbability[from][to] = p
+	}
+	//edgeFees[[2]int{from,to}] 每条边收取的费用,只在有费用或者跳数限制时使用
+	edgeFees := make(map[[2]int]*big.Int)
+	setEdgeFee := func(from, to common.Address, fee *model.Fee) {
+		if !ex.constrained() {
+			return
+		}
+		f := new(big.Int)
+		if from != source || sourceChargeFee {
+			f = calcFee(value, fee)
+		}
+		edgeFees[[2]int{gPeerToIndex[from], gPeerToIndex[to]}] = f
+	}
+	now := time.Now()
 	//作图，作图是把本次计算不符合上述条件的移除掉
 	t.nodeLock.Lock()
+	t.statsLock.Lock()
 	for _, c := range cs {
 		p1Balance := c.Participant1Balance
 		p2Balance := c.Participant2Balance
 
-		//忽略所有不在线的节点
-		if !t.participantStatus[c.Participant1].isOnline {
-			continue
-		}
-		if !t.participantStatus[c.Participant2].isOnline {
-			continue
-		}
-		//手机节点不能作为路由中间结点
-		if t.participantStatus[c.Participant1].isMobile && c.Participant1 != source && c.Participant1 != target {
-			continue
-		}
-		//通道双方只要有一个是手机并且既不是发起方也不是接收方,都应该 跳过
-		if t.participantStatus[c.Participant2].isMobile && c.Participant2 != source && c.Participant2 != target {
-			continue
-		}
-		//通道双方只要有一个启用了ignoreMediatedTransfer参数,且既不是发送方也不是接收方,都应该跳过
-		if t.participantStatus[c.Participant1].ignoreMediatedTransfer && c.Participant1 != source && c.Participant1 != target {
+		if t.filterChannel(c, source, target) != filterNone {
 			continue
 		}
-		if t.participantStatus[c.Participant2].ignoreMediatedTransfer && c.Participant2 != source && c.Participant2 != target {
+		if ex.excluded(c) {
 			continue
 		}
 		//只要有一个节点余额够,那么至少应该加入一条边
@@ -355,6 +476,11 @@ func (t *TokenNetwork) GetPaths(source common.Address, target common.Address, to
 			if c.Participant1 == source && !sourceChargeFee {
 				weight = 0
 			}
+			//按照 费用/成功概率 选择路径
+			p := c.Participant1Stats.probability(now)
+			setHopProbability(c.Participant1, c.Participant2, p)
+			weight = scaleWeight(weight, p)
+			setEdgeFee(c.Participant1, c.Participant2, c.Participant1Fee)
 			djGraph.AddEdge(gPeerToIndex[c.Participant1], gPeerToIndex[c.Participant2], weight) //int(peerBalance0)
 		}
 		if p2Balance.Cmp(value) >= 0 {
@@ -362,9 +488,14 @@ func (t *TokenNetwork) GetPaths(source common.Address, target common.Address, to
 			if c.Participant2 == source && !sourceChargeFee {
 				weight = 0
 			}
+			p := c.Participant2Stats.probability(now)
+			setHopProbability(c.Participant2, c.Participant1, p)
+			weight = scaleWeight(weight, p)
+			setEdgeFee(c.Participant2, c.Participant1, c.Participant2Fee)
 			djGraph.AddEdge(gPeerToIndex[c.Participant2], gPeerToIndex[c.Participant1], weight)
 		}
 	}
+	t.statsLock.Unlock()
 	t.nodeLock.Unlock()
 	if _, exist := gPeerToIndex[source]; !exist {
 		return nil, errors.New("There is no suitable path")
@@ -379,12 +510,24 @@ func (t *TokenNetwork) GetPaths(source common.Address, target common.Address, to
 	xsource := gPeerToIndex[source]
 	xtarget := gPeerToIndex[target]
 	buildtime := time.Now()
-	djResult := djGraph.AllShortestPath(xsource, xtarget, dijkstra.DefaultCostGetter)
+	var djResult [][]int
+	if ex.constrained() {
+		//不能先找最短路径再过滤,否则满足限制的更长的路径会被漏掉
+		djResult = djGraph.ConstrainedShortestPath(xsource, xtarget, dijkstra.DefaultCostGetter, func(from, to int) *big.Int {
+			return edgeFees[[2]int{from, to}]
+		}, ex.maxEdges, ex.maxFee, limitPaths)
+	} else {
+		djResult = djGraph.AllShortestPath(xsource, xtarget, dijkstra.DefaultCostGetter)
+	}
 	if djResult == nil {
 		return nil, errors.New("There is no suitable path")
 	}
 	//log.Trace(fmt.Sprintf("result=%v", djResult))
 	calcpathtime := time.Now()
+	indexToPeer := make(map[int]common.Address)
+	for addr, index := range gPeerToIndex {
+		indexToPeer[index] = addr
+	}
 	//log.Trace(fmt.Sprintf("result=%s,index=%s", utils.StringInterface(djResult, 5), utils.StringInterface(gPeerToIndex, 3)))
 	//将所有可能的最短路径转换为Address结果,同时计算费用
 	for k, pathSlice := range djResult {
@@ -445,13 +588,18 @@ func (t *TokenNetwork) GetPaths(source common.Address, target common.Address, to
 		}
 		sinPathInfo.Fee = totalfeerates
 		sinPathInfo.Result = xaddr
+		//整条路径成功的概率是每一跳成功概率的乘积
+		sinPathInfo.Probability = 1
+		for i := 0; i < len(pathSlice)-1; i++ {
+			sinPathInfo.Probability *= hopProbability[indexToPeer[pathSlice[i]]][indexToPeer[pathSlice[i+1]]]
+		}
 		pathinfos = append(pathinfos, sinPathInfo)
 	}
 	/*
-		由于计算精度问题,有可能导致计算出来的fee并不一样,最好按照费用大小排序
+		由于计算精度问题,有可能导致计算出来的fee并不一样,最好按照 费用/成功概率 排序
 	*/
 	sort.Slice(pathinfos, func(i, j int) bool {
-		return pathinfos[i].Fee.Cmp(pathinfos[j].Fee) < 0
+		return lessFeeOverProbability(pathinfos[i].Fee, pathinfos[i].Probability, pathinfos[j].Fee, pathinfos[j].Probability)
 	})
 	log.Info(fmt.Sprintf("buildgraph=%s,path=%s", buildtime.Sub(start), calcpathtime.Sub(buildtime)))
 	return
@@ -534,25 +682,163 @@ func (t *TokenNetwork) calcFeeByParticipantPartner(token, p1, p2 common.Address,
 
 //Online implements NodePresenceListener
 func (t *TokenNetwork) Online(address common.Address, deviceType string) {
+	now := time.Now()
 	t.nodeLock.Lock()
-	defer t.nodeLock.Unlock()
-	t.participantStatus[address] = nodeStatus{
-		isMobile: deviceType == "mobile",
-		isOnline: true,
-	}
+	ns := t.participantStatus[address]
+	ns.cameOnline(now)
+	ns.isOnline = true
+	ns.isMobile = deviceType == "mobile"
+	ns.onlineSource = fieldSource{model.NodeSourcePresence, now}
+	ns.mobileSource = ns.onlineSource
+	t.participantStatus[address] = ns
+	t.nodeLock.Unlock()
 	log.Trace(fmt.Sprintf("%s online ,type=%s", address.String(), deviceType))
-	model.NewOrUpdateNodeStatus(address, true, deviceType)
+	err := t.persistNode(t.db, address, onlineField, now, func(db *model.ModelDB) error {
+		return db.UpdateNodeOnline(address, true, deviceType, model.NodeSourcePresence, now)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("update node %s online err %s", address.String(), err))
+	}
 }
 
 //Offline implements NodePresenceListener
 func (t *TokenNetwork) Offline(address common.Address) {
+	now := time.Now()
+	t.nodeLock.Lock()
+	ns := t.participantStatus[address]
+	ns.wentOffline(now)
+	ns.isOnline = false
+	ns.onlineSource = fieldSource{model.NodeSourcePresence, now}
+	t.participantStatus[address] = ns
+	t.nodeLock.Unlock()
+	log.Trace(fmt.Sprintf("%s offliine", address.String()))
+	err := t.persistNode(t.db, address, onlineField, now, func(db *model.ModelDB) error {
+		return db.UpdateNodeOnline(address, false, "", model.NodeSourcePresence, now)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("update node %s offline err %s", address.String(), err))
+	}
+}
+
+//SetIgnoreMediatedTransfer implements MediationListener
+func (t *TokenNetwork) SetIgnoreMediatedTransfer(address common.Address, ignore bool) {
+	t.setIgnoreMediatedTransfer(t.db, address, ignore, model.NodeSourceHeartbeat)
+}
+
+//setIgnoreMediatedTransfer 只更新ignoreMediatedTransfer,不影响节点的其他状态,通过db保存
+func (t *TokenNetwork) setIgnoreMediatedTransfer(db *model.ModelDB, address common.Address, ignore bool, source string) {
+	now := time.Now()
+	t.nodeLock.Lock()
+	ns := t.participantStatus[address]
+	if ns.ignoreMediatedTransfer == ignore && ns.ignoreSource.source == source {
+		t.nodeLock.Unlock()
+		return
+	}
+	ns.ignoreMediatedTransfer = ignore
+	ns.ignoreSource = fieldSource{source, now}
+	t.participantStatus[address] = ns
+	t.nodeLock.Unlock()
+	err := t.persistNode(db, address, ignoreField, now, func(db *model.ModelDB) error {
+		return db.UpdateNodeIgnoreMediatedTransfer(address, ignore, source, now)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("update node %s ignore mediated transfer err %s", address.String(), err))
+	}
+}
+
+func onlineField(ns *nodeStatus) fieldSource { return ns.onlineSource }
+func ignoreField(ns *nodeStatus) fieldSource { return ns.ignoreSource }
+
+/*
+persistNode 通过db保存在updated时刻对节点状态的一次更新,不持有nodeLock,路由查询不用等待数据库.
+persistLock保证保存的顺序,如果内存中这个字段已经有更新的值,跳过,由更新的那次调用保存.
+db是Transaction中的ModelDB时不等待persistLock,否则持有persistLock的调用会等待事务提交,而事务在等待persistLock
+*/
+func (t *TokenNetwork) persistNode(db *model.ModelDB, address common.Address, field func(ns *nodeStatus) fieldSource, updated time.Time, save func(db *model.ModelDB) error) error {
+	if db == t.db {
+		t.persistLock.Lock()
+		defer t.persistLock.Unlock()
+	}
+	t.nodeLock.Lock()
+	ns := t.participantStatus[address]
+	latest := field(&ns).updated
+	t.nodeLock.Unlock()
+	if latest.After(updated) {
+		return nil
+	}
+	return save(db)
+}
+
+//Heartbeat 节点通过http发送的心跳,需要使用NewHTTPPresence作为上下线发现服务
+func (t *TokenNetwork) Heartbeat(address common.Address, hb *Heartbeat) error {
+	r, ok := t.transport.(HeartbeatReceiver)
+	if !ok {
+		return ErrHeartbeatNotEnabled
+	}
+	return r.Heartbeat(address, hb)
+}
+
+//PresenceHealth 上下线发现服务的运行状态,发现服务不支持时返回nil
+func (t *TokenNetwork) PresenceHealth() *PresenceHealth {
+	if r, ok := t.transport.(HealthReporter); ok {
+		return r.Health()
+	}
+	return nil
+}
+
+//NodeState 路由计算时使用的节点状态
+type NodeState struct {
+	Online                 bool `json:"online"` //考虑了下线宽限期和抖动抑制以后是否可以用于路由
+	Mobile                 bool `json:"mobile"`
+	IgnoreMediatedTransfer bool `json:"ignore_mediated_transfer"`
+	ReportedOnline         bool `json:"reported_online"` //发现服务报告的状态
+	OfflinePending         bool `json:"offline_pending"` //已经报告下线,但是还在宽限期内
+	//抖动惩罚,超过FlapSuppressThreshold时暂时不用于路由
+	FlapPenalty     float64 `json:"flap_penalty"`
+	Suppressed      bool    `json:"suppressed"`
+	SuppressedUntil int64   `json:"suppressed_until,omitempty"` //unix秒
+	//各个字段是谁在什么时候更新的,key为online,device_type,ignore_mediated_transfer
+	Sources map[string]*FieldSource `json:"sources,omitempty"`
+}
+
+//FieldSource 节点状态中一个字段的来源和更新时间
+type FieldSource struct {
+	Source    string `json:"source"`
+	UpdatedAt int64  `json:"updated_at"` //unix秒
+}
+
+//GetNodeState returns node state used by path finding, ok is false if pfs never knows this node
+func (t *TokenNetwork) GetNodeState(address common.Address) (state NodeState, ok bool) {
 	t.nodeLock.Lock()
 	defer t.nodeLock.Unlock()
-	t.participantStatus[address] = nodeStatus{
-		isOnline: false,
+	ns, ok := t.participantStatus[address]
+	if !ok {
+		return
 	}
-	log.Trace(fmt.Sprintf("%s offliine", address.String()))
-	model.NewOrUpdateNodeOnline(address, false)
+	now := time.Now()
+	state = NodeState{
+		Online:                 ns.routable(now),
+		Mobile:                 ns.isMobile,
+		IgnoreMediatedTransfer: ns.ignoreMediatedTransfer,
+		ReportedOnline:         ns.isOnline,
+		OfflinePending:         !ns.isOnline && ns.offlinePending(now),
+		FlapPenalty:            ns.penalty(now),
+		Suppressed:             ns.suppressed(now),
+	}
+	if state.Suppressed {
+		state.SuppressedUntil = ns.suppressedUntil.Unix()
+	}
+	state.Sources = make(map[string]*FieldSource)
+	for name, fs := range map[string]fieldSource{
+		"online":                   ns.onlineSource,
+		"device_type":              ns.mobileSource,
+		"ignore_mediated_transfer": ns.ignoreSource,
+	} {
+		if len(fs.source) > 0 {
+			state.Sources[name] = &FieldSource{fs.source, fs.updated.Unix()}
+		}
+	}
+	return
 }
 
 //UpdateChannelFeeRate set channel fee rate
@@ -570,7 +856,7 @@ func (t *TokenNetwork) UpdateChannelFeeRate(channelID common.Hash, peerAddress c
 	} else {
 		return fmt.Errorf("peer %s not match channel %s", peerAddress.String(), channelID.String())
 	}
-	return model.UpdateChannelFeeRate(channelID, peerAddress, c.Token, fee)
+	return t.db.UpdateChannelFeeRate(channelID, peerAddress, c.Token, fee)
 }
 
 //UpdateAccountFee  update acount's all channel feerate,保持内存与数据库中收费信息的一致
@@ -601,6 +887,11 @@ func (t *TokenNetwork) UpdateAccountFee(peerAddress common.Address, req *model.S
 	return
 }
 
+//participantFee 通道中某一方的收费
+func (t *TokenNetwork) participantFee(p *model.ChannelParticipantInfo, token common.Address) *model.Fee {
+	return t.db.GetChannelFeeRate(common.HexToHash(p.ChannelID), common.HexToAddress(p.Participant), token)
+}
+
 //Stop stop TokenNetwork service
 func (t *TokenNetwork) Stop() {
 	t.transport.Stop()
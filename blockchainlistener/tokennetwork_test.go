@@ -5,6 +5,7 @@ import (
 	"math"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -54,10 +55,10 @@ func TestCalcChannelID(t *testing.T) {
 }
 
 func TestTokenNetwork_GetPaths(t *testing.T) {
-	model.SetupTestDB()
+	db := model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	tokensNetwork := utils.NewRandomAddress()
-	tn := NewTokenNetwork(nil, tokensNetwork, true, nil)
+	tn, presence := newTestTokenNetwork(db, tokensNetwork)
 	tn.decimals = map[common.Address]int{
 		token: 0,
 	}
@@ -65,11 +66,11 @@ func TestTokenNetwork_GetPaths(t *testing.T) {
 		token: tokensNetwork,
 	}
 	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
-	tn.participantStatus[addr1] = nodeStatus{false, true}
-	tn.participantStatus[addr2] = nodeStatus{false, true}
-	tn.participantStatus[addr3] = nodeStatus{false, true}
+	presence.Online(addr1, "other")
+	presence.Online(addr2, "other")
+	presence.Online(addr3, "other")
 	c1Id := calcChannelID(token, tokensNetwork, addr1, addr2)
-	tn.handleChannelOpenedEvent(token, c1Id, addr1, addr2, 3)
+	tn.handleChannelOpenedEvent(tn.db, token, c1Id, addr1, addr2, 3)
 	tn.channels[c1Id].Participant1Balance = big.NewInt(20)
 	tn.channels[c1Id].Participant2Balance = big.NewInt(20)
 	tn.channels[c1Id].Participant1Fee = &model.Fee{
@@ -97,7 +98,7 @@ func TestTokenNetwork_GetPaths(t *testing.T) {
 	}
 
 	c2Id := calcChannelID(token, tokensNetwork, addr2, addr3)
-	tn.handleChannelOpenedEvent(token, c2Id, addr2, addr3, 3)
+	tn.handleChannelOpenedEvent(tn.db, token, c2Id, addr2, addr3, 3)
 	tn.channels[c2Id].Participant1Balance = big.NewInt(20)
 	tn.channels[c2Id].Participant2Balance = big.NewInt(20)
 	tn.channels[c2Id].Participant1Fee = &model.Fee{
@@ -124,10 +125,10 @@ func TestTokenNetwork_GetPaths(t *testing.T) {
 	}
 }
 func TestTokenNetwork_getWeight(t *testing.T) {
-	model.SetupTestDB()
+	db := model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	tokenNetwork := utils.NewRandomAddress()
-	tn := NewTokenNetwork(nil, tokenNetwork, true, nil)
+	tn, _ := newTestTokenNetwork(db, tokenNetwork)
 	tn.decimals = map[common.Address]int{
 		token: 18,
 	}
@@ -178,10 +179,10 @@ func TestTokenNetwork_getWeight(t *testing.T) {
 	assert.EqualValues(t, w, 100000)
 }
 func TestTokenNetwork_GetPathsBigInt(t *testing.T) {
-	model.SetupTestDB()
+	db := model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	tokenNetwork := utils.NewRandomAddress()
-	tn := NewTokenNetwork(nil, tokenNetwork, true, nil)
+	tn, presence := newTestTokenNetwork(db, tokenNetwork)
 	tn.decimals = map[common.Address]int{
 		token: 18,
 	}
@@ -192,14 +193,14 @@ func TestTokenNetwork_GetPathsBigInt(t *testing.T) {
 		token: tokenNetwork,
 	}
 	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
-	tn.participantStatus[addr1] = nodeStatus{false, true}
-	tn.participantStatus[addr2] = nodeStatus{false, true}
-	tn.participantStatus[addr3] = nodeStatus{false, true}
+	presence.Online(addr1, "other")
+	presence.Online(addr2, "other")
+	presence.Online(addr3, "other")
 	fee := big.NewInt(1)
 	fee.Mul(fee, base)
 
 	c1Id := calcChannelID(token, tokenNetwork, addr1, addr2)
-	tn.handleChannelOpenedEvent(token, c1Id, addr1, addr2, 3)
+	tn.handleChannelOpenedEvent(tn.db, token, c1Id, addr1, addr2, 3)
 	tn.channels[c1Id].Participant1Fee = &model.Fee{
 		FeePolicy:   model.FeePolicyConstant,
 		FeeConstant: fee,
@@ -228,7 +229,7 @@ func TestTokenNetwork_GetPathsBigInt(t *testing.T) {
 		return
 	}
 	c2Id := calcChannelID(token, tokenNetwork, addr2, addr3)
-	tn.handleChannelOpenedEvent(token, c2Id, addr2, addr3, 3)
+	tn.handleChannelOpenedEvent(tn.db, token, c2Id, addr2, addr3, 3)
 	tn.channels[c2Id].Participant1Fee = &model.Fee{
 		FeePolicy:   model.FeePolicyCombined,
 		FeeConstant: fee,
@@ -261,7 +262,6 @@ func TestTokenNetwork_GetPathsBigInt(t *testing.T) {
 }
 
 func TestTokenNetwork_GetPathsMultiHop(t *testing.T) {
-	model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
 	addr4 := utils.NewRandomAddress()
@@ -402,10 +402,10 @@ func orderParticipants(p1, p2 common.Address) (rp1, rp2 common.Address) {
 }
 func TestTokenNetwork_handleNewChannel(t *testing.T) {
 
-	model.SetupTestDB()
+	db := model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	tokenNetwork := utils.NewRandomAddress()
-	tn := NewTokenNetwork(nil, tokenNetwork, true, nil)
+	tn, presence := newTestTokenNetwork(db, tokenNetwork)
 	tn.decimals = map[common.Address]int{
 		token: 0,
 	}
@@ -416,7 +416,7 @@ func TestTokenNetwork_handleNewChannel(t *testing.T) {
 	p1 := utils.NewRandomAddress()
 	p2 := utils.NewRandomAddress()
 	p1, p2 = orderParticipants(p1, p2)
-	err := tn.handleChannelOpenedEvent(token, channid, p1, p2, 3)
+	err := tn.handleChannelOpenedEvent(tn.db, token, channid, p1, p2, 3)
 	if err != nil {
 		t.Error(err)
 		return
@@ -424,13 +424,17 @@ func TestTokenNetwork_handleNewChannel(t *testing.T) {
 	c := tn.channels[channid]
 	assert.EqualValues(t, c.Participant1, p1)
 	assert.EqualValues(t, c.Participant2, p2)
+	assert.True(t, presence.Subscribed(p1))
+	assert.True(t, presence.Subscribed(p2))
 
-	err = tn.handleChannelClosedEvent(channid)
+	err = tn.handleChannelClosedEvent(tn.db, channid)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	err = tn.handleChannelClosedEvent(channid)
+	assert.False(t, presence.Subscribed(p1))
+	assert.False(t, presence.Subscribed(p2))
+	err = tn.handleChannelClosedEvent(tn.db, channid)
 	if err == nil {
 		t.Error("should error")
 		return
@@ -438,12 +442,12 @@ func TestTokenNetwork_handleNewChannel(t *testing.T) {
 }
 
 func BenchmarkTokenNetwork_GetPaths(b *testing.B) {
-	model.SetupTestDB()
+	db := model.SetupTestDB()
 	nodesNumber := 10000
 	nodes := make(map[int]common.Address)
 	token := utils.NewRandomAddress()
 	tokenNetwork := utils.NewRandomAddress()
-	tn := NewTokenNetwork(nil, tokenNetwork, true, nil)
+	tn, _ := newTestTokenNetwork(db, tokenNetwork)
 	tn.decimals = map[common.Address]int{
 		token: 18,
 	}
@@ -454,11 +458,11 @@ func BenchmarkTokenNetwork_GetPaths(b *testing.B) {
 		token: tokenNetwork,
 	}
 	lastAddr := utils.NewRandomAddress()
-	tn.participantStatus[lastAddr] = nodeStatus{false, true}
+	tn.participantStatus[lastAddr] = nodeStatus{isOnline: true}
 	for i := 0; i < nodesNumber; i++ {
 		nodes[i] = lastAddr
 		addr := utils.NewRandomAddress()
-		tn.participantStatus[addr] = nodeStatus{false, true}
+		tn.participantStatus[addr] = nodeStatus{isOnline: true}
 		c := &channel{
 			Participant1: lastAddr,
 			Participant2: addr,
@@ -522,7 +526,6 @@ func TestDeleteSlice(t *testing.T) {
 }
 
 func TestTokenNetwork_GetPaths2(t *testing.T) {
-	model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
 	addr4 := utils.NewRandomAddress()
@@ -651,7 +654,6 @@ func TestTokenNetwork_GetPaths2(t *testing.T) {
 }
 
 func TestTokenNetwork_GetPaths3(t *testing.T) {
-	model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
 	addr4 := utils.NewRandomAddress()
@@ -801,7 +803,6 @@ B 200 5000 C
 查找从C到A,金额为300的路径,失败
 */
 func TestTokenNetwork_GetPaths4(t *testing.T) {
-	model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
 	addr4 := utils.NewRandomAddress()
@@ -866,7 +867,6 @@ func TestTokenNetwork_GetPaths4(t *testing.T) {
 */
 func TestTokenNetwork_GetPaths5(t *testing.T) {
 	req := require.New(t)
-	model.SetupTestDB()
 	token := utils.NewRandomAddress()
 	addr1, addr2, addr3, addr4, addr5 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(),
 		utils.NewRandomAddress(), utils.NewRandomAddress()
@@ -962,19 +962,172 @@ func TestTokenNetwork_GetPaths5(t *testing.T) {
 
 }
 
+//newTestTokenNetwork 使用内存中的上下线发现服务,不需要连接matrix或者xmpp服务器
+func newTestTokenNetwork(db *model.ModelDB, tokensNetwork common.Address) (*TokenNetwork, *MemoryPresence) {
+	presence := NewMemoryPresence()
+	tn, err := NewTokenNetwork(db, nil, tokensNetwork, nil, WithPresence(presence.Factory()))
+	if err != nil {
+		panic(err)
+	}
+	return tn, presence
+}
+
+//testPresence buildTestTN创建的TokenNetwork使用的上下线发现服务
+func testPresence(tn *TokenNetwork) *MemoryPresence {
+	return tn.transport.(*MemoryPresence)
+}
+
 func buildTestTN(chs []*channel) *TokenNetwork {
 	tokenNetwork := utils.NewRandomAddress()
-	tn := NewTokenNetwork(nil, tokenNetwork, true, nil)
+	tn, presence := newTestTokenNetwork(model.SetupTestDB(), tokenNetwork)
 	tn.decimals = map[common.Address]int{}
 	tn.token2TokenNetwork = map[common.Address]common.Address{}
 	for _, c := range chs {
 		cid := calcChannelID(c.Token, tokenNetwork, c.Participant1, c.Participant2)
 		tn.channelViews[c.Token] = append(tn.channelViews[c.Token], c)
 		tn.channels[cid] = c
-		tn.participantStatus[c.Participant1] = nodeStatus{false, true}
-		tn.participantStatus[c.Participant2] = nodeStatus{false, true}
+		presence.Online(c.Participant1, "other")
+		presence.Online(c.Participant2, "other")
 		tn.decimals[c.Token] = 0
 		tn.token2TokenNetwork[c.Token] = tokenNetwork
 	}
 	return tn
 }
+
+func TestTokenNetwork_PresenceEvents(t *testing.T) {
+	defer noOfflineGrace()()
+	ast := assert.New(t)
+	token := utils.NewRandomAddress()
+	addr1, addr2, addr3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newChannel := func(p1, p2 common.Address) *channel {
+		return &channel{
+			Participant1: p1,
+			Participant2: p2,
+			Participant1Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(1),
+			},
+			Participant2Fee: &model.Fee{
+				FeePolicy:   model.FeePolicyConstant,
+				FeeConstant: big.NewInt(1),
+			},
+			Participant1Balance: big.NewInt(20),
+			Participant2Balance: big.NewInt(20),
+			Token:               token,
+		}
+	}
+	tn := buildTestTN([]*channel{newChannel(addr1, addr2), newChannel(addr2, addr3)})
+	presence := testPresence(tn)
+	_, err := tn.GetPaths(addr1, addr3, token, big.NewInt(10), 3, "", false)
+	ast.Nil(err)
+
+	presence.Offline(addr2)
+	state, ok := tn.GetNodeState(addr2)
+	ast.True(ok)
+	ast.False(state.Online)
+	_, err = tn.GetPaths(addr1, addr3, token, big.NewInt(10), 3, "", false)
+	ast.NotNil(err)
+
+	//手机节点不能作为中间节点,但是可以作为接收方
+	presence.Mobile(addr2)
+	_, err = tn.GetPaths(addr1, addr3, token, big.NewInt(10), 3, "", false)
+	ast.NotNil(err)
+	_, err = tn.GetPaths(addr1, addr2, token, big.NewInt(10), 3, "", false)
+	ast.Nil(err)
+
+	presence.Online(addr2, "other")
+	_, err = tn.GetPaths(addr1, addr3, token, big.NewInt(10), 3, "", false)
+	ast.Nil(err)
+
+	//停止以后不再通知
+	presence.Stop()
+	presence.Offline(addr2)
+	state, _ = tn.GetNodeState(addr2)
+	ast.True(state.Online)
+}
+
+func TestTokenNetwork_NodeStatusFields(t *testing.T) {
+	ast := assert.New(t)
+	db := model.SetupTestDB()
+	token := utils.NewRandomAddress()
+	tokensNetwork := utils.NewRandomAddress()
+	tn, presence := newTestTokenNetwork(db, tokensNetwork)
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	channelID := calcChannelID(token, tokensNetwork, p1, p2)
+	ast.Nil(tn.handleTokenNetworkAdded(tn.db, token, 1, 0))
+	ast.Nil(tn.handleChannelOpenedEvent(tn.db, token, channelID, p1, p2, 3))
+	tn.handleChannelDepositEvent(tn.db, channelID, p1, big.NewInt(100))
+	presence.Mobile(p1)
+	_, err := tn.UpdateBalance(p1, p2, nil, &model.BalanceProof{
+		Nonce:           1,
+		TransferAmount:  big.NewInt(0),
+		ChannelID:       channelID,
+		OpenBlockNumber: 3,
+	}, true)
+	ast.Nil(err)
+	state, _ := tn.GetNodeState(p1)
+	ast.True(state.IgnoreMediatedTransfer)
+	ast.Equal(model.NodeSourceBalanceProof, state.Sources["ignore_mediated_transfer"].Source)
+	ast.Equal(model.NodeSourcePresence, state.Sources["online"].Source)
+
+	//上下线不影响balance proof中的参数,下线也不影响设备类型
+	presence.Offline(p1)
+	state, _ = tn.GetNodeState(p1)
+	ast.False(state.ReportedOnline)
+	ast.True(state.Mobile)
+	ast.True(state.IgnoreMediatedTransfer)
+	presence.Online(p1, "mobile")
+	state, _ = tn.GetNodeState(p1)
+	ast.True(state.ReportedOnline)
+	ast.True(state.IgnoreMediatedTransfer)
+
+	//重启以后恢复
+	tn2, _ := newTestTokenNetwork(db, tokensNetwork)
+	state, ok := tn2.GetNodeState(p1)
+	ast.True(ok)
+	ast.True(state.ReportedOnline)
+	ast.True(state.Mobile)
+	ast.True(state.IgnoreMediatedTransfer)
+	ast.Equal(model.NodeSourceBalanceProof, state.Sources["ignore_mediated_transfer"].Source)
+}
+
+//上下线发现服务一启动就通知的状态不能被数据库中旧的状态覆盖
+func TestTokenNetwork_LoadNodesBeforePresence(t *testing.T) {
+	ast := assert.New(t)
+	db := model.SetupTestDB()
+	addr := utils.NewRandomAddress()
+	ast.Nil(db.UpdateNodeOnline(addr, false, "", model.NodeSourcePresence, time.Now().Add(-time.Hour)))
+	presence := NewMemoryPresence()
+	factory := func(db *model.ModelDB, listener NodePresenceListener) (Transporter, error) {
+		tr, err := presence.Factory()(db, listener)
+		presence.Online(addr, "other")
+		return tr, err
+	}
+	tn, err := NewTokenNetwork(db, nil, utils.NewRandomAddress(), nil, WithPresence(factory))
+	if !ast.Nil(err) {
+		return
+	}
+	state, _ := tn.GetNodeState(addr)
+	ast.True(state.ReportedOnline)
+	n, err := db.GetNode(addr)
+	ast.Nil(err)
+	ast.True(n.IsOnline)
+}
+
+//节点状态在nodeLock之外保存,旧的更新不能覆盖数据库中新的状态
+func TestTokenNetwork_PersistNodeOrder(t *testing.T) {
+	ast := assert.New(t)
+	db := model.SetupTestDB()
+	tn, presence := newTestTokenNetwork(db, utils.NewRandomAddress())
+	addr := utils.NewRandomAddress()
+	old := time.Now()
+	presence.Online(addr, "other")
+	//在Online之前发生的下线,晚于Online保存
+	err := tn.persistNode(tn.db, addr, onlineField, old, func(db *model.ModelDB) error {
+		return db.UpdateNodeOnline(addr, false, "", model.NodeSourcePresence, old)
+	})
+	ast.Nil(err)
+	n, err := db.GetNode(addr)
+	ast.Nil(err)
+	ast.True(n.IsOnline)
+}
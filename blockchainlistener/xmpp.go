@@ -2,7 +2,12 @@ package blockchainlistener
 
 import (
 	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
 	"errors"
+	"io/ioutil"
+	"net"
 	"time"
 
 	"sync"
@@ -47,6 +52,15 @@ const (
 	TypeMeshBox = "meshbox"
 	//TypeOtherDevice photon run on a other device
 	TypeOtherDevice = "other"
+	//rosterID go-xmpp请求roster时使用的id
+	rosterID = "roster1"
+)
+
+//xmpp连接使用TLS的方式
+const (
+	XMPPTLSNone     = "none"     //不加密,默认
+	XMPPTLSStartTLS = "starttls" //明文连接以后通过STARTTLS升级,服务器不支持时拒绝登录
+	XMPPTLSDirect   = "tls"      //直接使用TLS连接,一般是5223端口
 )
 
 type testPasswordGeter struct {
@@ -64,6 +78,13 @@ func (t *testPasswordGeter) GetPassWord() string {
 // Config contains various client options.
 type Config struct {
 	Timeout time.Duration
+	//TLS XMPPTLSNone, XMPPTLSStartTLS or XMPPTLSDirect, empty means XMPPTLSNone
+	TLS string
+	//CAFile 验证服务器证书的CA文件,为空时使用系统的CA
+	CAFile string
+	//断线以后重连的间隔,每次失败加倍,带随机抖动
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
 }
 
 // DefaultConfig with standard private channel prefix and 1 second timeout.
@@ -71,6 +92,52 @@ var DefaultConfig = &Config{
 	Timeout: defaultTimeout,
 }
 
+//DefaultXMPPConfig 根据params中的设置生成配置
+func DefaultXMPPConfig() *Config {
+	return &Config{
+		Timeout:    defaultTimeout,
+		TLS:        pparams.XMPPTLS,
+		CAFile:     pparams.XMPPCAFile,
+		MinBackoff: pparams.PresenceMinBackoff,
+		MaxBackoff: pparams.PresenceMaxBackoff,
+	}
+}
+
+//xmppOptions 根据配置设置是否使用TLS,使用TLS时一定验证服务器的证书
+func xmppOptions(serverURL string, config *Config) (o xmpp.Options, err error) {
+	o.Host = serverURL
+	switch config.TLS {
+	case "", XMPPTLSNone:
+		o.NoTLS = true
+		o.InsecureAllowUnencryptedAuth = true
+		return
+	case XMPPTLSStartTLS:
+		o.NoTLS = true
+		o.StartTLS = true
+	case XMPPTLSDirect:
+	default:
+		err = fmt.Errorf("unknown xmpp tls %s", config.TLS)
+		return
+	}
+	host, _, err := net.SplitHostPort(serverURL)
+	if err != nil {
+		host, err = serverURL, nil
+	}
+	o.TLSConfig = &tls.Config{ServerName: host}
+	if len(config.CAFile) > 0 {
+		var pem []byte
+		pem, err = ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return
+		}
+		o.TLSConfig.RootCAs = x509.NewCertPool()
+		if !o.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("no certificate found in %s", config.CAFile)
+		}
+	}
+	return
+}
+
 /*
 PasswordGetter generate login password
 */
@@ -102,32 +169,43 @@ type XMPPConnection struct {
 	addrMap        map[common.Address]int //addr neighbor count
 	listener       NodePresenceListener
 	log            log.Logger
+	backoff        *backoff
+	statusLock     sync.Mutex //保护下面的运行状态
+	lastResync     time.Time
+	lastEvent      time.Time
+	lastError      string
+	reconnects     int
 }
 
 /*
 NewXMPPConnection create Xmpp connection to signal sever
 */
-func NewXMPPConnection(ServerURL string, db XMPPDb, listener NodePresenceListener) (x2 *XMPPConnection, err error) {
-	key := pparams.ObserverKey()
+func NewXMPPConnection(ServerURL string, key *ecdsa.PrivateKey, db XMPPDb, listener NodePresenceListener) (x2 *XMPPConnection, err error) {
+	return NewXMPPConnectionWithConfig(ServerURL, key, db, listener, DefaultConfig)
+}
+
+/*
+NewXMPPConnectionWithConfig create Xmpp connection to signal sever,
+断线以后按照config中的间隔重连,重连以后重新订阅服务器roster中没有的邻居
+*/
+func NewXMPPConnectionWithConfig(ServerURL string, key *ecdsa.PrivateKey, db XMPPDb, listener NodePresenceListener, config *Config) (x2 *XMPPConnection, err error) {
 	User := crypto.PubkeyToAddress(key.PublicKey)
 	name := utils.APex2(User)
 	deviceType := "other"
 	passwordFn := &testPasswordGeter{key}
+	options, err := xmppOptions(ServerURL, config)
+	if err != nil {
+		return
+	}
+	options.User = fmt.Sprintf("%s%s", strings.ToLower(User.String()), nameSuffix)
+	options.Password = passwordFn.GetPassWord()
+	options.Status = "xa"
+	options.StatusMessage = name
+	options.Resource = deviceType
 	x := &XMPPConnection{
-		lock:   sync.RWMutex{},
-		config: DefaultConfig,
-		options: xmpp.Options{
-			Host:                         ServerURL,
-			User:                         fmt.Sprintf("%s%s", strings.ToLower(User.String()), nameSuffix),
-			Password:                     passwordFn.GetPassWord(),
-			NoTLS:                        true,
-			InsecureAllowUnencryptedAuth: true,
-			Debug:                        false,
-			Session:                      false,
-			Status:                       "xa",
-			StatusMessage:                name,
-			Resource:                     deviceType,
-		},
+		lock:           sync.RWMutex{},
+		config:         config,
+		options:        options,
 		client:         nil,
 		waitersMutex:   sync.RWMutex{},
 		waiters:        make(map[string]chan interface{}),
@@ -140,6 +218,7 @@ func NewXMPPConnection(ServerURL string, db XMPPDb, listener NodePresenceListene
 		listener:       listener,
 		db:             db,
 		log:            log.New("xmpp", name),
+		backoff:        newBackoff(config.MinBackoff, config.MaxBackoff),
 	}
 	x.log.Trace(fmt.Sprintf("%s new xmpp user %s password %s", name, User.String(), x.options.Password))
 	x.client, err = x.options.NewClient()
@@ -155,20 +234,31 @@ func NewXMPPConnection(ServerURL string, db XMPPDb, listener NodePresenceListene
 func (x *XMPPConnection) loop() {
 	for {
 		chat, err := x.client.Recv()
-		if x.status == netshare.Closed {
+		if x.getStatus() == netshare.Closed {
 			return
 		}
 		if err != nil {
 			//todo how to detect network error ,disconnect
 			x.log.Error(fmt.Sprintf("%s receive error %s ,try to reconnect ", x.name, err))
+			x.setError(err)
 			err = x.client.Close()
 			if err != nil {
 				x.log.Error(fmt.Sprintf("xmpp close err %s", err))
 			}
-			x.reConnect()
+			if !x.reConnect() {
+				return
+			}
+			go x.resyncRoster()
 			continue
 		}
 		switch v := chat.(type) {
+		case xmpp.IQ:
+			x.waitersMutex.Lock()
+			ch, ok := x.waiters[v.ID]
+			x.waitersMutex.Unlock()
+			if ok {
+				ch <- &v
+			}
 
 		case xmpp.Presence:
 			if len(v.ID) > 0 {
@@ -199,6 +289,9 @@ func (x *XMPPConnection) loop() {
 				}
 				ids := strings.Split(id, "@")
 				addr := common.HexToAddress(ids[0])
+				x.statusLock.Lock()
+				x.lastEvent = time.Now()
+				x.statusLock.Unlock()
 				if bs.IsOnline {
 
 					x.listener.Online(addr, bs.DeviceType)
@@ -214,10 +307,18 @@ func (x *XMPPConnection) loop() {
 	}
 }
 func (x *XMPPConnection) changeStatus(newStatus netshare.Status) {
+	x.statusLock.Lock()
+	defer x.statusLock.Unlock()
 	x.log.Info(fmt.Sprintf("changeStatus from %d to %d", x.status, newStatus))
 	x.status = newStatus
 }
 
+func (x *XMPPConnection) getStatus() netshare.Status {
+	x.statusLock.Lock()
+	defer x.statusLock.Unlock()
+	return x.status
+}
+
 //Reconnect :
 func (x *XMPPConnection) Reconnect() {
 	err := x.client.Close()
@@ -227,24 +328,163 @@ func (x *XMPPConnection) Reconnect() {
 	return
 }
 
-func (x *XMPPConnection) reConnect() {
+//reConnect 一直重连直到成功,连接关闭时返回false
+func (x *XMPPConnection) reConnect() bool {
 	x.changeStatus(netshare.Reconnecting)
 	o := x.options
 	for {
-		if x.status == netshare.Closed {
-			return
+		d := x.backoff.next()
+		x.log.Info(fmt.Sprintf("%s xmpp reconnect after %s", x.name, d))
+		if !sleep(d, x.closed) {
+			return false
 		}
 		o.Password = x.NextPasswordFn.GetPassWord()
 		client, err := o.NewClient()
 		if err != nil {
 			x.log.Error(fmt.Sprintf("%s xmpp reconnect error %s", x.name, err))
-			time.Sleep(time.Second)
+			x.setError(err)
 			continue
 		}
+		x.lock.Lock()
 		x.client = client
+		x.lock.Unlock()
 		break
 	}
+	x.backoff.reset()
+	x.statusLock.Lock()
+	x.reconnects++
+	x.statusLock.Unlock()
 	x.changeStatus(netshare.Connected)
+	return true
+}
+
+func (x *XMPPConnection) setError(err error) {
+	x.statusLock.Lock()
+	x.lastError = err.Error()
+	x.statusLock.Unlock()
+}
+
+//rosterItem roster中的一个联系人
+type rosterItem struct {
+	Jid          string `xml:"jid,attr"`
+	Subscription string `xml:"subscription,attr"`
+	Ask          string `xml:"ask,attr"`
+}
+
+type rosterQuery struct {
+	XMLName xml.Name     `xml:"jabber:iq:roster query"`
+	Items   []rosterItem `xml:"item"`
+}
+
+//parseRoster 解析roster,返回已经订阅或者正在订阅的地址
+func parseRoster(query []byte) (subscribed map[common.Address]bool, err error) {
+	var q rosterQuery
+	err = xml.Unmarshal(query, &q)
+	if err != nil {
+		return
+	}
+	subscribed = make(map[common.Address]bool)
+	for _, item := range q.Items {
+		if item.Subscription != "to" && item.Subscription != "both" && item.Ask != "subscribe" {
+			continue
+		}
+		local := strings.Split(item.Jid, "@")[0]
+		if !common.IsHexAddress(local) {
+			continue
+		}
+		subscribed[common.HexToAddress(local)] = true
+	}
+	return
+}
+
+//fetchRoster 从服务器获取roster
+func (x *XMPPConnection) fetchRoster() (subscribed map[common.Address]bool, err error) {
+	wait := make(chan interface{}, 1)
+	err = x.addWaiter(rosterID, wait)
+	if err != nil {
+		return
+	}
+	defer x.removeWaiter(rosterID)
+	x.lock.RLock()
+	cli := x.client
+	x.lock.RUnlock()
+	err = cli.Roster()
+	if err != nil {
+		return
+	}
+	r, err := x.wait(wait)
+	if err != nil {
+		return
+	}
+	iq, ok := r.(*xmpp.IQ)
+	if !ok || iq.Type != "result" {
+		err = fmt.Errorf("unexpected roster response %s", utils.StringInterface(r, 3))
+		return
+	}
+	return parseRoster(iq.Query)
+}
+
+/*
+resyncRoster 重连以后数据库中记录的订阅状态可能和服务器的roster不一致,
+以服务器的roster为准,重新订阅服务器上没有的邻居
+*/
+func (x *XMPPConnection) resyncRoster() {
+	subscribed, err := x.fetchRoster()
+	if err != nil {
+		x.log.Error(fmt.Sprintf("%s fetch roster err %s", x.name, err))
+		x.setError(err)
+		return
+	}
+	var missing []common.Address
+	x.lock.RLock()
+	for addr, cnt := range x.addrMap {
+		if cnt > 0 && !subscribed[addr] {
+			missing = append(missing, addr)
+		}
+	}
+	x.lock.RUnlock()
+	for _, addr := range missing {
+		x.log.Info(fmt.Sprintf("%s resubscribe %s", x.name, addr.String()))
+		x.lock.Lock()
+		err = x.subscribe(addr)
+		x.lock.Unlock()
+		if err != nil {
+			x.log.Error(fmt.Sprintf("%s resubscribe %s err %s", x.name, addr.String(), err))
+			x.setError(err)
+			return
+		}
+	}
+	x.statusLock.Lock()
+	x.lastResync = time.Now()
+	x.statusLock.Unlock()
+}
+
+//Health implements HealthReporter
+func (x *XMPPConnection) Health() *PresenceHealth {
+	x.statusLock.Lock()
+	defer x.statusLock.Unlock()
+	h := &PresenceHealth{
+		Backend:   "xmpp",
+		Server:    x.options.Host,
+		LastError: x.lastError,
+		Failovers: x.reconnects,
+	}
+	switch x.status {
+	case netshare.Connected:
+		h.Status = PresenceStatusSyncing
+		h.Healthy = true
+	case netshare.Closed:
+		h.Status = PresenceStatusStopped
+	default:
+		h.Status = PresenceStatusConnecting
+	}
+	if !x.lastResync.IsZero() {
+		h.LastSync = x.lastResync.Unix()
+	}
+	if !x.lastEvent.IsZero() {
+		h.LastEvent = x.lastEvent.Unix()
+	}
+	return h
 }
 
 func (x *XMPPConnection) send(msg *xmpp.Chat) error {
@@ -266,7 +506,9 @@ func (x *XMPPConnection) send(msg *xmpp.Chat) error {
 func (x *XMPPConnection) Stop() {
 	x.changeStatus(netshare.Closed)
 	close(x.closed)
+	x.lock.RLock()
 	err := x.client.Close()
+	x.lock.RUnlock()
 	if err != nil {
 		x.log.Error(fmt.Sprintf("close err %s", err))
 	}
@@ -274,7 +516,7 @@ func (x *XMPPConnection) Stop() {
 
 //Connected returns true when this connection is ready for sent
 func (x *XMPPConnection) Connected() bool {
-	return x.status == netshare.Connected
+	return x.getStatus() == netshare.Connected
 }
 
 func (x *XMPPConnection) sendPresence(msg *xmpp.Presence) error {
@@ -351,10 +593,15 @@ func (x *XMPPConnection) SubscribeNeighbour(addr common.Address) error {
 	defer x.lock.Unlock()
 	cnt := x.addrMap[addr]
 	x.addrMap[addr] = cnt + 1
-	//数据库中记录已经查询过了
+	//数据库中记录已经查询过了,重连以后由resyncRoster检查服务器上是否真的订阅了
 	if x.db.XMPPIsAddrSubed(addr) {
 		return nil
 	}
+	return x.subscribe(addr)
+}
+
+//subscribe 向服务器发送订阅请求,并记录到数据库中
+func (x *XMPPConnection) subscribe(addr common.Address) error {
 	addrName := fmt.Sprintf("%s%s", strings.ToLower(addr.String()), nameSuffix)
 	p := xmpp.Presence{
 		From: x.options.User,
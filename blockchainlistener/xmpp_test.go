@@ -4,6 +4,8 @@ import (
 	"crypto/ecdsa"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -14,6 +16,7 @@ import (
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
 )
 
 func init() {
@@ -35,12 +38,15 @@ func (t *testdb) XMPPUnMarkAddr(addr common.Address) {
 }
 
 type testlistener struct {
+	lock sync.Mutex
 	m    map[common.Address]*nodeStatus
 	name string
 }
 
 func (t *testlistener) Online(address common.Address, deviceType string) {
 	log.Trace(fmt.Sprintf("%s online,deviceType=%s observer=%s", address.String(), deviceType, t.name))
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	t.m[address] = &nodeStatus{
 		isOnline: true,
 		isMobile: deviceType == "mobile",
@@ -50,12 +56,58 @@ func (t *testlistener) Online(address common.Address, deviceType string) {
 //an account is offline
 func (t *testlistener) Offline(address common.Address) {
 	log.Trace(fmt.Sprintf("%s offline,observer=%s", address.String(), t.name))
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	delete(t.m, address)
 }
-func TestSubscribe(t *testing.T) {
-	if testing.Short() {
-		return
+
+//waitFor 等待address的状态满足cond,超时返回false
+func (t *testlistener) waitFor(address common.Address, cond func(ns *nodeStatus) bool) bool {
+	return waitUntil(func() bool {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		return cond(t.m[address])
+	})
+}
+
+//waitUntil 等待cond成立,最多等5秒
+func waitUntil(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	return true
+}
+
+func isOnline(ns *nodeStatus) bool {
+	return ns != nil && ns.isOnline
+}
+
+func isOffline(ns *nodeStatus) bool {
+	return ns == nil
+}
+
+func newTestXMPPConnection(t *testing.T, server string, key *ecdsa.PrivateKey, listener *testlistener) *XMPPConnection {
+	x, err := NewXMPPConnectionWithConfig(server, key, &testdb{
+		m: make(map[common.Address]bool),
+	}, listener, &Config{
+		Timeout:    time.Second,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestSubscribe(t *testing.T) {
+	ast := assert.New(t)
+	server := newFakeXMPPServer(t)
+	defer server.Close()
 
 	key1, _ := crypto.GenerateKey()
 	addr1 := crypto.PubkeyToAddress(key1.PublicKey)
@@ -63,76 +115,174 @@ func TestSubscribe(t *testing.T) {
 	addr2 := crypto.PubkeyToAddress(key2.PublicKey)
 	log.Trace(fmt.Sprintf("addr1=%s,addr2=%s\n", addr1.String(), addr2.String()))
 
-	params.ObserverKey = func() *ecdsa.PrivateKey { return key1 }
 	t1listener := &testlistener{
 		m:    make(map[common.Address]*nodeStatus),
 		name: "a1",
 	}
-	x1, err := NewXMPPConnection(params.DefaultXMPPServer, &testdb{
-		m: make(map[common.Address]bool),
-	}, t1listener)
-
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	err = x1.SubscribeNeighbour(addr2)
-	if err != nil {
-		t.Error(err)
+	x1 := newTestXMPPConnection(t, server.Addr(), key1, t1listener)
+	defer x1.Stop()
+	err := x1.SubscribeNeighbour(addr2)
+	if !ast.Nil(err) {
 		return
 	}
 	log.Trace(fmt.Sprintf("subscribe %s", addr2.String()))
+	jid1 := strings.ToLower(addr1.String()) + nameSuffix
+	jid2 := strings.ToLower(addr2.String()) + nameSuffix
+	ast.True(waitUntil(func() bool { return server.Subscribed(jid1, jid2) }))
+	ast.True(isOffline(t1listener.m[addr2]), "should not online")
 
-	defer x1.Stop()
-	if t1listener.m[addr2] != nil {
-		t.Error("should not online")
-		return
-	}
 	log.Trace("client2 will login")
-	params.ObserverKey = func() *ecdsa.PrivateKey { return key2 }
-	x2, err := NewXMPPConnection(params.DefaultXMPPServer, &testdb{
-		m: make(map[common.Address]bool),
-	}, &testlistener{
+	x2 := newTestXMPPConnection(t, server.Addr(), key2, &testlistener{
 		m:    make(map[common.Address]*nodeStatus),
 		name: "a2",
 	})
-	if err != nil {
-		t.Error(err)
-		return
-	}
 	//wait notification from server
-	time.Sleep(time.Millisecond * 1000)
-	if !t1listener.m[addr2].isOnline || t1listener.m[addr2].isMobile {
-		t.Errorf("should  online,err=%v,isonline=%v,ismobile=%v", err, t1listener.m[addr2].isOnline, t1listener.m[addr2].isMobile)
-		return
-	}
+	ast.True(t1listener.waitFor(addr2, isOnline), "should online")
+	ast.False(t1listener.m[addr2].isMobile)
+
 	log.Trace("client2 will logout")
 	x2.Stop()
-	time.Sleep(time.Millisecond * 1000)
-
-	if t1listener.m[addr2] != nil {
-		t.Error("should  offline")
-		return
-	}
+	ast.True(t1listener.waitFor(addr2, isOffline), "should offline")
 
 	err = x1.Unsubscribe(addr2)
-	if err != nil {
-		t.Error(err)
+	if !ast.Nil(err) {
 		return
 	}
-	time.Sleep(time.Millisecond * 100)
+	ast.False(server.Subscribed(jid1, jid2))
 	log.Trace("client2 will relogin")
-	params.ObserverKey = func() *ecdsa.PrivateKey { return key2 }
-	x2, err = NewXMPPConnection(params.DefaultXMPPServer, &testdb{
-		m: make(map[common.Address]bool),
-	}, &testlistener{
+	x2 = newTestXMPPConnection(t, server.Addr(), key2, &testlistener{
 		m:    make(map[common.Address]*nodeStatus),
 		name: "a2",
 	})
-	if err != nil {
-		t.Error(err)
-		return
-	}
+	ast.True(waitUntil(func() bool { return server.Online(jid2) }))
+	//已经取消订阅,不会再收到通知
+	time.Sleep(100 * time.Millisecond)
+	ast.True(isOffline(t1listener.m[addr2]), "should not receive presence after unsubscribe")
 	log.Trace("client2 will logout")
 	x2.Stop()
 }
+
+//服务器断开连接并且丢失了roster,重连以后应该重新订阅
+func TestXMPPConnection_ReconnectResync(t *testing.T) {
+	ast := assert.New(t)
+	server := newFakeXMPPServer(t)
+	defer server.Close()
+	key1, _ := crypto.GenerateKey()
+	addr1 := crypto.PubkeyToAddress(key1.PublicKey)
+	key2, _ := crypto.GenerateKey()
+	addr2 := crypto.PubkeyToAddress(key2.PublicKey)
+	jid1 := strings.ToLower(addr1.String()) + nameSuffix
+	l1 := &testlistener{
+		m:    make(map[common.Address]*nodeStatus),
+		name: "a1",
+	}
+	x1 := newTestXMPPConnection(t, server.Addr(), key1, l1)
+	defer x1.Stop()
+	x2 := newTestXMPPConnection(t, server.Addr(), key2, &testlistener{
+		m:    make(map[common.Address]*nodeStatus),
+		name: "a2",
+	})
+	ast.Nil(x1.SubscribeNeighbour(addr2))
+	ast.True(l1.waitFor(addr2, isOnline))
+
+	server.ClearRoster(jid1)
+	server.Kick(jid1)
+	waitUntil(func() bool {
+		h := x1.Health()
+		return h.Failovers >= 1 && h.LastSync > 0 && h.Healthy
+	})
+	h := x1.Health()
+	ast.True(h.Failovers >= 1)
+	ast.True(h.Healthy)
+	ast.True(h.LastSync > 0)
+	ast.True(server.Subscribed(jid1, strings.ToLower(addr2.String())+nameSuffix))
+
+	//重新订阅以后能继续收到通知
+	x2.Stop()
+	ast.True(l1.waitFor(addr2, isOffline), "should offline")
+}
+
+//服务器上的roster没有丢失,重连以后根据roster判断已经订阅过,不会重新订阅
+func TestXMPPConnection_ReconnectKeepsRoster(t *testing.T) {
+	ast := assert.New(t)
+	server := newFakeXMPPServer(t)
+	defer server.Close()
+	key1, _ := crypto.GenerateKey()
+	addr1 := crypto.PubkeyToAddress(key1.PublicKey)
+	key2, _ := crypto.GenerateKey()
+	addr2 := crypto.PubkeyToAddress(key2.PublicKey)
+	jid1 := strings.ToLower(addr1.String()) + nameSuffix
+	l1 := &testlistener{
+		m:    make(map[common.Address]*nodeStatus),
+		name: "a1",
+	}
+	x1 := newTestXMPPConnection(t, server.Addr(), key1, l1)
+	defer x1.Stop()
+	x2 := newTestXMPPConnection(t, server.Addr(), key2, &testlistener{
+		m:    make(map[common.Address]*nodeStatus),
+		name: "a2",
+	})
+	defer x2.Stop()
+	ast.Nil(x1.SubscribeNeighbour(addr2))
+	ast.True(l1.waitFor(addr2, isOnline))
+	ast.Equal(1, server.SubscribeRequests(jid1))
+
+	server.Kick(jid1)
+	waitUntil(func() bool {
+		h := x1.Health()
+		return h.Failovers >= 1 && h.LastSync > 0 && h.Healthy
+	})
+	h := x1.Health()
+	ast.True(h.LastSync > 0, "roster should be fetched and parsed")
+	ast.True(h.Healthy, h.LastError)
+	ast.Equal(1, server.SubscribeRequests(jid1), "should not resubscribe contacts already in roster")
+}
+
+func TestParseRoster(t *testing.T) {
+	ast := assert.New(t)
+	a1, a2, a3, a4 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	jid := func(addr common.Address) string {
+		return strings.ToLower(addr.String()) + nameSuffix
+	}
+	query := fmt.Sprintf(`<query xmlns='jabber:iq:roster'>
+<item jid='%s' subscription='both'/>
+<item jid='%s' subscription='to'/>
+<item jid='%s' subscription='none' ask='subscribe'/>
+<item jid='%s' subscription='from'/>
+<item jid='bob@mobileraiden' subscription='both'/>
+</query>`, jid(a1), jid(a2), jid(a3), jid(a4))
+	subscribed, err := parseRoster([]byte(query))
+	ast.Nil(err)
+	ast.Equal(3, len(subscribed))
+	ast.True(subscribed[a1] && subscribed[a2] && subscribed[a3])
+	ast.False(subscribed[a4])
+	_, err = parseRoster([]byte("<ping xmlns='urn:xmpp:ping'/>"))
+	ast.NotNil(err)
+}
+
+func TestXMPPOptions(t *testing.T) {
+	ast := assert.New(t)
+	o, err := xmppOptions(params.DefaultXMPPServer, &Config{})
+	ast.Nil(err)
+	ast.True(o.NoTLS)
+	ast.True(o.InsecureAllowUnencryptedAuth)
+	ast.Nil(o.TLSConfig)
+
+	o, err = xmppOptions("xmpp.example.com:5222", &Config{TLS: XMPPTLSStartTLS})
+	ast.Nil(err)
+	ast.True(o.NoTLS)
+	ast.True(o.StartTLS)
+	ast.False(o.InsecureAllowUnencryptedAuth)
+	ast.Equal("xmpp.example.com", o.TLSConfig.ServerName)
+	ast.False(o.TLSConfig.InsecureSkipVerify)
+
+	o, err = xmppOptions("xmpp.example.com:5223", &Config{TLS: XMPPTLSDirect})
+	ast.Nil(err)
+	ast.False(o.NoTLS)
+	ast.False(o.InsecureAllowUnencryptedAuth)
+
+	_, err = xmppOptions("xmpp.example.com:5223", &Config{TLS: XMPPTLSDirect, CAFile: "notexist.pem"})
+	ast.NotNil(err)
+	_, err = xmppOptions("xmpp.example.com:5223", &Config{TLS: "ssl"})
+	ast.NotNil(err)
+}
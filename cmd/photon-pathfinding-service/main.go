@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os/signal"
+	"syscall"
 
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/rest"
 
@@ -18,7 +19,6 @@ import (
 	debug2 "runtime/debug"
 
 	"github.com/SmartMeshFoundation/Photon/log"
-	"github.com/SmartMeshFoundation/Photon/network/helper"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/node"
@@ -53,6 +53,12 @@ func StartMain() {
 			Usage: `hex encoded address of the registry contract.`,
 			Value: params.RegistryAddress.String(),
 		},
+		cli.StringSliceFlag{
+			Name: "network",
+			Usage: `"eth-rpc-endpoint,registry-contract-address" of a photon network, repeat it to serve several networks in one process.
+	the first one is the default network, others can be accessed by /pfs/1/chain/<chain id>/...
+	tables of every network are prefixed with chain<chain id>_ . --eth-rpc-endpoint and --registry-contract-address are ignored when it is set`,
+		},
 		cli.IntFlag{
 			Name:  "port",
 			Usage: ` port  for the RPC server to listen on.`,
@@ -76,11 +82,94 @@ func StartMain() {
 			Name:  "xmpp",
 			Usage: "use xmpp as node online offline discover,default is xmpp",
 		},
+		cli.StringFlag{
+			Name:  "presence",
+			Usage: "node online offline discover: matrix, xmpp or http(nodes PUT /pfs/1/<address>/presence heartbeats), several can be used at once like matrix,xmpp, overrides --matrix and --xmpp",
+		},
+		cli.StringSliceFlag{
+			Name:  "matrix-server",
+			Usage: "matrix homeserver url like https://transport01.smartmesh.cn:8448, can be repeated, the next one is used when the current one fails",
+		},
+		cli.StringFlag{
+			Name:  "matrix-ca",
+			Usage: "CA certificate file to verify https matrix homeservers, default is system CAs",
+		},
+		cli.StringFlag{
+			Name:  "xmpp-server",
+			Usage: "xmpp server host:port",
+			Value: params.XMPPServer,
+		},
+		cli.StringFlag{
+			Name:  "xmpp-tls",
+			Usage: "none, starttls or tls(usually port 5223), server certificate is verified with starttls and tls",
+			Value: params.XMPPTLS,
+		},
+		cli.StringFlag{
+			Name:  "xmpp-ca",
+			Usage: "CA certificate file to verify xmpp server, default is system CAs",
+		},
+		cli.DurationFlag{
+			Name:  "offline-grace",
+			Usage: "a node reported offline is still used for routing for this long",
+			Value: params.OfflineGracePeriod,
+		},
+		cli.DurationFlag{
+			Name:  "flap-half-life",
+			Usage: "a node coming back online is counted as a flap, flap penalty halves every this long",
+			Value: params.FlapPenaltyHalfLife,
+		},
+		cli.Float64Flag{
+			Name:  "flap-suppress",
+			Usage: "nodes whose flap penalty reaches this are not used for routing until the penalty decays below 1",
+			Value: params.FlapSuppressThreshold,
+		},
+		cli.StringFlag{
+			Name:  "presence-merge",
+			Usage: "how to merge node status when several presence are used: any(online if any presence says online) or latest(the latest report wins)",
+			Value: "any",
+		},
+		cli.DurationFlag{
+			Name:  "presence-timeout",
+			Usage: "with --presence=http, a node is offline when no heartbeat is received for this long",
+			Value: params.PresenceSilenceWindow,
+		},
+		cli.StringFlag{
+			Name:  "snapshot",
+			Usage: "start from this snapshot file when database is empty, instead of replaying all events from block 0",
+		},
+		cli.StringFlag{
+			Name:  "snapshot-signer",
+			Usage: "only accept snapshot signed by this address, required by --snapshot unless --snapshot-insecure",
+		},
+		cli.BoolFlag{
+			Name:  "snapshot-insecure",
+			Usage: "accept snapshot signed by anyone, the signature only proves the file is not corrupted",
+		},
+		cli.BoolFlag{
+			Name:  "backfill",
+			Usage: "catch up history events in batches before serving path queries",
+		},
+		cli.Int64Flag{
+			Name:  "backfill-batch",
+			Usage: "how many blocks of events to fetch and apply in one batch when backfill",
+			Value: params.BackfillBatchSize,
+		},
+		cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Usage: "when exit, how long to wait for active http requests and queued state changes to be processed",
+			Value: params.ShutdownTimeout,
+		},
+		cli.Int64Flag{
+			Name:  "sync-threshold",
+			Usage: "path queries return 503 until processed block is within this number of blocks of chain head",
+			Value: params.SyncedThreshold,
+		},
 	}
+	app.Commands = []cli.Command{snapshotCommand}
 	app.Flags = append(app.Flags, debug.Flags...)
 	app.Action = mainCtx
 	app.Name = "PhotonPathFinder"
-	app.Version = "0.1"
+	app.Version = params.Version
 	app.Before = func(ctx *cli.Context) error {
 		if err := debug.Setup(ctx); err != nil {
 			return err
@@ -104,51 +193,125 @@ func mainCtx(ctx *cli.Context) error {
 	fmt.Printf("Welcom to Photon Path Finder,version %s\n", ctx.App.Version)
 	config(ctx)
 	//log.Debug(fmt.Sprintf("Config:%s", utils.StringInterface(cfg, 2)))
-	ethEndpoint := ctx.String("eth-rpc-endpoint")
-	client, err := helper.NewSafeClient(ethEndpoint)
+	params.DebugMode = ctx.Bool("debug")
+	log.Info(fmt.Sprintf("debug=%v", params.DebugMode))
+	snapshot := ctx.String("snapshot")
+	if len(snapshot) > 0 {
+		err = checkSnapshotSigner(ctx.String("snapshot-signer"), ctx.Bool("snapshot-insecure"))
+		if err != nil {
+			log.Error(err.Error())
+			utils.SystemExit(1)
+		}
+	}
+	db, networks, err := setupNetworks(ctx)
 	if err != nil {
-		log.Error(fmt.Sprintf("cannot connect to geth :%s err=%s", ethEndpoint, err))
+		log.Error(err.Error())
 		utils.SystemExit(1)
 	}
-	params.ChainID, err = client.NetworkID(context.Background())
+	if len(snapshot) > 0 {
+		err = loadSnapshot(networks, snapshot, ctx.String("snapshot-signer"), ctx.Bool("snapshot-insecure"))
+		if err == model.ErrSnapshotDBNotEmpty {
+			log.Info(fmt.Sprintf("database already initialized, ignore snapshot %s", snapshot))
+		} else if err != nil {
+			log.Error(fmt.Sprintf("load snapshot %s err %s", snapshot, err))
+			utils.SystemExit(1)
+		}
+	}
+	key, _ := utils.MakePrivateKeyAddress()
+	presenceName := ctx.String("presence")
+	if len(presenceName) == 0 {
+		presenceName = "xmpp"
+		if ctx.Bool("matrix") {
+			presenceName = "matrix"
+		}
+	}
+	policy, err := blockchainlistener.MergePolicyByName(ctx.String("presence-merge"))
 	if err != nil {
-		log.Error(fmt.Sprintf("get network id err %s", err))
+		log.Error(err.Error())
 		utils.SystemExit(1)
 	}
-	params.DBType = ctx.String("dbtype")
-	params.DBPath = ctx.String("dbconnection")
-	params.DebugMode = ctx.Bool("debug")
-	log.Info(fmt.Sprintf("debug=%v", params.DebugMode))
-	model.SetUpDB(params.DBType, params.DBPath)
-	key, _ := utils.MakePrivateKeyAddress()
-	useMatrx := ctx.Bool("matrix")
-	ce := blockchainlistener.NewChainEvents(key, client, params.RegistryAddress, useMatrx)
-	err = ce.Start()
+	presence, err := blockchainlistener.PresenceFromFlag(presenceName, policy)
 	if err != nil {
-		log.Error(fmt.Sprintf("ce start err =%s ", err))
-		utils.SystemExit(3)
+		log.Error(err.Error())
+		utils.SystemExit(1)
+	}
+	var ces []*blockchainlistener.ChainEvents
+	for _, n := range networks {
+		ce := blockchainlistener.NewChainEvents(key, n.client, n.chainID, n.registryAddress, presence, n.db)
+		err = ce.Start()
+		if err != nil {
+			log.Error(fmt.Sprintf("ce start err =%s ", err))
+			utils.SystemExit(3)
+		}
+		ces = append(ces, ce)
 	}
 	/*
 		quit handler
 	*/
-	go func() {
-		quitSignal := make(chan os.Signal, 1)
-		signal.Notify(quitSignal, os.Interrupt, os.Kill)
-		<-quitSignal
-		signal.Stop(quitSignal)
-		ce.Stop()
-		model.CloseDB()
-		utils.SystemExit(0)
-	}()
-	rest.Start(ce, ce.TokenNetwork)
+	quitSignal := make(chan os.Signal, 1)
+	signal.Notify(quitSignal, os.Interrupt, syscall.SIGTERM)
+	go rest.Start(ces)
+	sig := <-quitSignal
+	signal.Stop(quitSignal)
+	log.Info(fmt.Sprintf("receive signal %s, shutting down", sig))
+	shutdown(ces, db)
 	return nil
 }
 
-// config listening service port and registry address(contract works on)
+/*
+shutdown 依次停止接受http请求,处理完已经提交的请求和收到的链上事件,
+再停止上下线服务,最后关闭数据库,保证上下线回调不会写入已经关闭的数据库
+*/
+func shutdown(ces []*blockchainlistener.ChainEvents, db *model.ModelDB) {
+	ctx, cancel := context.WithTimeout(context.Background(), params.ShutdownTimeout)
+	defer cancel()
+	err := rest.Shutdown(ctx)
+	if err != nil {
+		log.Error(fmt.Sprintf("http server shutdown err %s", err))
+	}
+	for _, ce := range ces {
+		ce.Stop()
+	}
+	for _, ce := range ces {
+		ce.StopPresence()
+	}
+	db.CloseDB()
+	log.Info("shutdown complete")
+}
+
+// config listening service port and registry address(contract works on),
+// use global flags so that it works for sub commands too
 func config(ctx *cli.Context) {
 
-	params.Port = ctx.Int("port")
-	registAddrStr := ctx.String("registry-contract-address")
+	params.Port = ctx.GlobalInt("port")
+	params.EnableBackfill = ctx.GlobalBool("backfill")
+	if n := ctx.GlobalInt64("backfill-batch"); n > 0 {
+		params.BackfillBatchSize = n
+	}
+	if n := ctx.GlobalInt64("sync-threshold"); n >= 0 {
+		params.SyncedThreshold = n
+	}
+	if d := ctx.GlobalDuration("shutdown-timeout"); d > 0 {
+		params.ShutdownTimeout = d
+	}
+	if d := ctx.GlobalDuration("presence-timeout"); d > 0 {
+		params.PresenceSilenceWindow = d
+	}
+	params.MatrixServers = ctx.GlobalStringSlice("matrix-server")
+	params.MatrixCAFile = ctx.GlobalString("matrix-ca")
+	params.XMPPServer = ctx.GlobalString("xmpp-server")
+	params.XMPPTLS = ctx.GlobalString("xmpp-tls")
+	params.XMPPCAFile = ctx.GlobalString("xmpp-ca")
+	if d := ctx.GlobalDuration("offline-grace"); d >= 0 {
+		params.OfflineGracePeriod = d
+	}
+	if d := ctx.GlobalDuration("flap-half-life"); d > 0 {
+		params.FlapPenaltyHalfLife = d
+	}
+	if f := ctx.GlobalFloat64("flap-suppress"); f > params.FlapReuseThreshold {
+		params.FlapSuppressThreshold = f
+	}
+	registAddrStr := ctx.GlobalString("registry-contract-address")
 	if len(registAddrStr) > 0 {
 		params.RegistryAddress = common.HexToAddress(registAddrStr)
 	}
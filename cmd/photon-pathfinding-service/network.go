@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/urfave/cli.v1"
+)
+
+//network 一个photon网络,也就是某条链上的一个registry合约
+type network struct {
+	ethEndpoint     string
+	registryAddress common.Address
+	chainID         *big.Int
+	client          *helper.SafeEthClient
+	db              *model.ModelDB
+}
+
+/*
+parseNetworks 解析--network参数,格式为"eth-rpc-endpoint,registry-address",可以重复多次.
+没有指定--network时使用--eth-rpc-endpoint和--registry-contract-address,与只支持一个网络时完全一样.
+*/
+func parseNetworks(ctx *cli.Context) (ns []*network, err error) {
+	specs := ctx.GlobalStringSlice("network")
+	if len(specs) == 0 {
+		ns = append(ns, &network{
+			ethEndpoint:     ctx.GlobalString("eth-rpc-endpoint"),
+			registryAddress: params.RegistryAddress,
+		})
+		return
+	}
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, ",")
+		if i <= 0 || !common.IsHexAddress(spec[i+1:]) {
+			err = fmt.Errorf("network %q format error, expect \"eth-rpc-endpoint,registry-address\"", spec)
+			return
+		}
+		ns = append(ns, &network{
+			ethEndpoint:     spec[:i],
+			registryAddress: common.HexToAddress(spec[i+1:]),
+		})
+	}
+	return
+}
+
+/*
+setupNetworks 连接每个网络的geth获取chain id,然后为每个网络准备数据库.
+所有网络共用一个数据库连接,使用--network时每个网络的表名都以chain<ChainID>_开头,
+否则不加前缀,以兼容之前的数据库.
+返回的root是共用的数据库连接,退出时关闭.
+*/
+func setupNetworks(ctx *cli.Context) (root *model.ModelDB, ns []*network, err error) {
+	ns, err = parseNetworks(ctx)
+	if err != nil {
+		return
+	}
+	usePrefix := len(ctx.GlobalStringSlice("network")) > 0
+	chainIDs := make(map[int64]bool)
+	for _, n := range ns {
+		n.client, err = helper.NewSafeClient(n.ethEndpoint)
+		if err != nil {
+			err = fmt.Errorf("cannot connect to geth :%s err=%s", n.ethEndpoint, err)
+			return
+		}
+		n.chainID, err = n.client.NetworkID(context.Background())
+		if err != nil {
+			err = fmt.Errorf("get network id of %s err %s", n.ethEndpoint, err)
+			return
+		}
+		//rest接口以chain id区分网络
+		if chainIDs[n.chainID.Int64()] {
+			err = fmt.Errorf("duplicate network for chain id %s", n.chainID)
+			return
+		}
+		chainIDs[n.chainID.Int64()] = true
+		log.Info(fmt.Sprintf("network chainID=%s,registry=%s,endpoint=%s", n.chainID, n.registryAddress.String(), n.ethEndpoint))
+	}
+	params.DBType = ctx.GlobalString("dbtype")
+	params.DBPath = ctx.GlobalString("dbconnection")
+	root = model.SetUpDB(params.DBType, params.DBPath)
+	for _, n := range ns {
+		n.db = root
+		if usePrefix {
+			n.db = root.WithTablePrefix(fmt.Sprintf("chain%s_", n.chainID))
+		}
+	}
+	return
+}
+
+//findNetwork 按chain id查找网络
+func findNetwork(ns []*network, chainID int64) (*network, error) {
+	for _, n := range ns {
+		if n.chainID.Int64() == chainID {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("no network for chain id %d", chainID)
+}
+
+//selectNetwork chainID为0时只能有一个网络,否则按chain id查找网络
+func selectNetwork(ns []*network, chainID int64) (*network, error) {
+	if chainID > 0 {
+		return findNetwork(ns, chainID)
+	}
+	if len(ns) > 1 {
+		return nil, fmt.Errorf("there are %d networks, please specify --chain-id", len(ns))
+	}
+	return ns[0], nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectNetwork(t *testing.T) {
+	ast := assert.New(t)
+	n1 := &network{chainID: big.NewInt(1), registryAddress: utils.NewRandomAddress()}
+	n2 := &network{chainID: big.NewInt(8888), registryAddress: utils.NewRandomAddress()}
+	ns := []*network{n1, n2}
+
+	n, err := findNetwork(ns, 8888)
+	ast.Nil(err)
+	ast.Equal(n2, n)
+	n, err = findNetwork(ns, 1)
+	ast.Nil(err)
+	ast.Equal(n1, n)
+	_, err = findNetwork(ns, 3)
+	ast.NotNil(err)
+
+	//多个网络时必须指定chain id
+	_, err = selectNetwork(ns, 0)
+	ast.NotNil(err)
+	n, err = selectNetwork(ns, 8888)
+	ast.Nil(err)
+	ast.Equal(n2, n)
+	_, err = selectNetwork(ns, 3)
+	ast.NotNil(err)
+	//只有一个网络时可以不指定
+	n, err = selectNetwork(ns[1:], 0)
+	ast.Nil(err)
+	ast.Equal(n2, n)
+}
+
+//export子命令必须能够指定--chain-id
+func TestSnapshotExportChainIDFlag(t *testing.T) {
+	ast := assert.New(t)
+	for _, c := range snapshotCommand.Subcommands {
+		if c.Name != "export" {
+			continue
+		}
+		found := false
+		for _, f := range c.Flags {
+			if f.GetName() == "chain-id" {
+				found = true
+			}
+		}
+		ast.True(found)
+		return
+	}
+	t.Error("no export subcommand")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var snapshotCommand = cli.Command{
+	Name:  "snapshot",
+	Usage: "export or import a signed channel graph snapshot",
+	Subcommands: []cli.Command{
+		{
+			Name:   "export",
+			Usage:  "export channel graph in database to a snapshot file",
+			Action: exportSnapshot,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "snapshot file to write",
+					Value: "./pfs-snapshot.json",
+				},
+				cli.Int64Flag{
+					Name:  "chain-id",
+					Usage: "chain id of the network to export, required when there are more than one --network",
+				},
+			},
+		},
+		{
+			Name:   "import",
+			Usage:  "import a snapshot file into a new database of the network with the same chain id, event processing will resume from the snapshot's block",
+			Action: importSnapshot,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "snapshot file to read",
+					Value: "./pfs-snapshot.json",
+				},
+				cli.StringFlag{
+					Name:  "signer",
+					Usage: "only accept snapshot signed by this address, required unless --insecure",
+				},
+				cli.BoolFlag{
+					Name:  "insecure",
+					Usage: "accept snapshot signed by anyone, the signature only proves the file is not corrupted",
+				},
+			},
+		},
+	},
+}
+
+// setupSnapshotEnv connect to geth for chain id and open database, same as the main service
+func setupSnapshotEnv(ctx *cli.Context) (db *model.ModelDB, networks []*network, err error) {
+	config(ctx)
+	return setupNetworks(ctx)
+}
+
+func exportSnapshot(ctx *cli.Context) error {
+	db, networks, err := setupSnapshotEnv(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.CloseDB()
+	n, err := selectNetwork(networks, ctx.Int64("chain-id"))
+	if err != nil {
+		return err
+	}
+	s, err := n.db.ExportSnapshot(n.chainID.Int64(), n.registryAddress)
+	if err != nil {
+		return err
+	}
+	err = s.Sign(n.db.GetObserverKey())
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	file := ctx.String("file")
+	err = ioutil.WriteFile(file, data, 0644)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("export snapshot at block %d to %s, signer=%s", s.BlockNumber, file, s.Signer.String()))
+	return nil
+}
+
+func importSnapshot(ctx *cli.Context) error {
+	db, networks, err := setupSnapshotEnv(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.CloseDB()
+	return loadSnapshot(networks, ctx.String("file"), ctx.String("signer"), ctx.Bool("insecure"))
+}
+
+// checkSnapshotSigner snapshot must come from a trusted signer, unless insecure is explicitly set
+func checkSnapshotSigner(signer string, insecure bool) error {
+	if len(signer) == 0 {
+		if insecure {
+			return nil
+		}
+		return errors.New("snapshot signer not specified, trusted signer is required to import a snapshot unless insecure mode is on")
+	}
+	if !common.IsHexAddress(signer) {
+		return fmt.Errorf("invalid snapshot signer %s", signer)
+	}
+	return nil
+}
+
+// loadSnapshot verify and import snapshot `file` into the database of the network with the same chain id,
+// database must be set up already
+func loadSnapshot(networks []*network, file string, signer string, insecure bool) error {
+	err := checkSnapshotSigner(signer, insecure)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	s := &model.Snapshot{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return err
+	}
+	err = s.VerifySignature()
+	if err != nil {
+		return err
+	}
+	if len(signer) == 0 {
+		log.Warn(fmt.Sprintf("import snapshot %s signed by %s without checking signer", file, s.Signer.String()))
+	} else if s.Signer != common.HexToAddress(signer) {
+		return fmt.Errorf("snapshot signed by %s, but expect %s", s.Signer.String(), signer)
+	}
+	n, err := findNetwork(networks, s.ChainID)
+	if err != nil {
+		return fmt.Errorf("snapshot chain id %d not match: %s", s.ChainID, err)
+	}
+	if s.RegistryAddress != n.registryAddress {
+		return fmt.Errorf("snapshot registry %s not match, expect %s", s.RegistryAddress.String(), n.registryAddress.String())
+	}
+	err = n.db.ImportSnapshot(s)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("import snapshot %s ok, will resume from block %d", file, s.BlockNumber))
+	return nil
+}
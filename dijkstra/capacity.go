@@ -0,0 +1,173 @@
+package dijkstra
+
+import (
+	"container/heap"
+	"math/big"
+)
+
+/*
+CapacityGraph directed graph whose arcs are capacities(the amount can pass through),
+vertices should be from 0 to n-1 when there are n vertices
+*/
+type CapacityGraph struct {
+	arcs []map[int]*big.Int
+}
+
+//NewCapacityGraph create empty capacity graph
+func NewCapacityGraph() *CapacityGraph {
+	return &CapacityGraph{}
+}
+
+//Len vertices number
+func (g *CapacityGraph) Len() int { return len(g.arcs) }
+
+//AddVertex new vertex
+func (g *CapacityGraph) AddVertex() int {
+	g.arcs = append(g.arcs, make(map[int]*big.Int))
+	return len(g.arcs) - 1
+}
+
+//AddEdge add capacity from src to dst, capacity of parallel edges are added up, zero or negative capacity is ignored
+func (g *CapacityGraph) AddEdge(src, dst int, capacity *big.Int) bool {
+	if src >= len(g.arcs) || dst >= len(g.arcs) {
+		return false
+	}
+	if capacity.Sign() <= 0 {
+		return true
+	}
+	if c, ok := g.arcs[src][dst]; ok {
+		g.arcs[src][dst] = new(big.Int).Add(c, capacity)
+	} else {
+		g.arcs[src][dst] = new(big.Int).Set(capacity)
+	}
+	return true
+}
+
+type widthItem struct {
+	vertex int
+	width  *big.Int //nil表示无穷大
+}
+
+//widthHeap 按照width从大到小出堆
+type widthHeap []*widthItem
+
+func (h widthHeap) Len() int { return len(h) }
+func (h widthHeap) Less(i, j int) bool {
+	if h[i].width == nil {
+		return h[j].width != nil
+	}
+	return h[j].width != nil && h[i].width.Cmp(h[j].width) > 0
+}
+func (h widthHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *widthHeap) Push(x interface{}) { *h = append(*h, x.(*widthItem)) }
+func (h *widthHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+/*
+WidestPath 用dijkstra的变形计算从source到target瓶颈(路径上最小的容量)最大的路径,
+也就是单条路径最多能通过多少.
+返回瓶颈和路径(包含source和target),没有路径时返回0和nil
+*/
+func (g *CapacityGraph) WidestPath(source, target int) (width *big.Int, path []int) {
+	width = new(big.Int)
+	if source >= len(g.arcs) || target >= len(g.arcs) || source == target {
+		return
+	}
+	best := make(map[int]*big.Int)
+	prev := make(map[int]int)
+	visited := make([]bool, len(g.arcs))
+	h := &widthHeap{{vertex: source}}
+	for h.Len() > 0 {
+		item := heap.Pop(h).(*widthItem)
+		if visited[item.vertex] {
+			continue
+		}
+		visited[item.vertex] = true
+		if item.vertex == target {
+			width.Set(item.width)
+			for v := target; v != source; v = prev[v] {
+				path = append([]int{v}, path...)
+			}
+			path = append([]int{source}, path...)
+			return
+		}
+		for to, c := range g.arcs[item.vertex] {
+			if visited[to] {
+				continue
+			}
+			w := c
+			if item.width != nil && item.width.Cmp(w) < 0 {
+				w = item.width
+			}
+			if b, ok := best[to]; ok && b.Cmp(w) >= 0 {
+				continue
+			}
+			best[to] = w
+			prev[to] = item.vertex
+			heap.Push(h, &widthItem{to, w})
+		}
+	}
+	return
+}
+
+/*
+MaxFlow 用Edmonds-Karp算法计算从source到target的最大流,也就是同时使用多条路径最多能通过多少.
+*/
+func (g *CapacityGraph) MaxFlow(source, target int) *big.Int {
+	flow := new(big.Int)
+	if source >= len(g.arcs) || target >= len(g.arcs) || source == target {
+		return flow
+	}
+	//残量网络
+	residual := make([]map[int]*big.Int, len(g.arcs))
+	for i := range residual {
+		residual[i] = make(map[int]*big.Int)
+	}
+	for from, arcs := range g.arcs {
+		for to, c := range arcs {
+			residual[from][to] = new(big.Int).Set(c)
+			if _, ok := residual[to][from]; !ok {
+				residual[to][from] = new(big.Int)
+			}
+		}
+	}
+	for {
+		//bfs 找最短的增广路径
+		prev := make([]int, len(g.arcs))
+		for i := range prev {
+			prev[i] = -1
+		}
+		prev[source] = source
+		queue := []int{source}
+		for len(queue) > 0 && prev[target] < 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for to, c := range residual[cur] {
+				if prev[to] < 0 && c.Sign() > 0 {
+					prev[to] = cur
+					queue = append(queue, to)
+				}
+			}
+		}
+		if prev[target] < 0 {
+			return flow
+		}
+		var bottleneck *big.Int
+		for v := target; v != source; v = prev[v] {
+			c := residual[prev[v]][v]
+			if bottleneck == nil || c.Cmp(bottleneck) < 0 {
+				bottleneck = c
+			}
+		}
+		bottleneck = new(big.Int).Set(bottleneck)
+		for v := target; v != source; v = prev[v] {
+			residual[prev[v]][v].Sub(residual[prev[v]][v], bottleneck)
+			residual[v][prev[v]].Add(residual[v][prev[v]], bottleneck)
+		}
+		flow.Add(flow, bottleneck)
+	}
+}
@@ -0,0 +1,62 @@
+package dijkstra
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestCapacityGraph(n int, arcs [][3]int64) *CapacityGraph {
+	g := NewCapacityGraph()
+	for i := 0; i < n; i++ {
+		g.AddVertex()
+	}
+	for _, a := range arcs {
+		g.AddEdge(int(a[0]), int(a[1]), big.NewInt(a[2]))
+	}
+	return g
+}
+
+func TestCapacityGraph_WidestPath(t *testing.T) {
+	/*
+		0->1->3 瓶颈是5
+		0->2->3 瓶颈是7
+	*/
+	g := buildTestCapacityGraph(5, [][3]int64{
+		{0, 1, 10}, {1, 3, 5},
+		{0, 2, 7}, {2, 3, 20},
+		{3, 0, 100},
+	})
+	w, path := g.WidestPath(0, 3)
+	assert.EqualValues(t, big.NewInt(7), w)
+	assert.EqualValues(t, []int{0, 2, 3}, path)
+	w, path = g.WidestPath(3, 2)
+	assert.EqualValues(t, big.NewInt(7), w)
+	assert.EqualValues(t, []int{3, 0, 2}, path)
+	//4 没有任何边
+	w, path = g.WidestPath(0, 4)
+	assert.EqualValues(t, big.NewInt(0), w)
+	assert.Nil(t, path)
+	w, _ = g.WidestPath(0, 0)
+	assert.EqualValues(t, big.NewInt(0), w)
+}
+
+func TestCapacityGraph_MaxFlow(t *testing.T) {
+	//两条路径同时使用可以通过12
+	g := buildTestCapacityGraph(5, [][3]int64{
+		{0, 1, 10}, {1, 3, 5},
+		{0, 2, 7}, {2, 3, 20},
+		{3, 0, 100},
+	})
+	assert.EqualValues(t, big.NewInt(12), g.MaxFlow(0, 3))
+	assert.EqualValues(t, big.NewInt(0), g.MaxFlow(0, 4))
+	//经典的需要反向边才能找到最大流的例子
+	g = buildTestCapacityGraph(4, [][3]int64{
+		{0, 1, 1}, {0, 2, 1}, {1, 2, 1}, {1, 3, 1}, {2, 3, 1},
+	})
+	assert.EqualValues(t, big.NewInt(2), g.MaxFlow(0, 3))
+	//最大流不会小于最宽路径
+	w, _ := g.WidestPath(0, 3)
+	assert.True(t, g.MaxFlow(0, 3).Cmp(w) >= 0)
+}
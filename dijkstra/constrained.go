@@ -0,0 +1,119 @@
+package dijkstra
+
+import (
+	"container/heap"
+	"math"
+	"math/big"
+)
+
+//ResourceGetter how much resource(such as fee) the edge from source to target consumes, must not be negative
+type ResourceGetter func(source, target int) *big.Int
+
+//maxLabels 标号太多时停止搜索,避免图太大时耗尽内存
+const maxLabels = 200000
+
+//label 从source到某个顶点的一条部分路径
+type label struct {
+	vertex   int
+	cost     int
+	edges    int
+	resource *big.Int
+	prev     *label
+	index    int //在heap中的顺序,保证相同cost时结果稳定
+}
+
+func (l *label) path() []int {
+	var path []int
+	for x := l; x != nil; x = x.prev {
+		path = append([]int{x.vertex}, path...)
+	}
+	return path
+}
+
+//dominates l的每一项都不比l2差
+func (l *label) dominates(l2 *label) bool {
+	return l.cost <= l2.cost && l.edges <= l2.edges && l.resource.Cmp(l2.resource) <= 0
+}
+
+type labelHeap []*label
+
+func (h labelHeap) Len() int { return len(h) }
+func (h labelHeap) Less(i, j int) bool {
+	if h[i].cost != h[j].cost {
+		return h[i].cost < h[j].cost
+	}
+	return h[i].index < h[j].index
+}
+func (h labelHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *labelHeap) Push(x interface{}) { *h = append(*h, x.(*label)) }
+func (h *labelHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+/*
+ConstrainedShortestPath 带资源限制的最短路径(resource constrained shortest path).
+路径的边数不能超过maxEdges(<=0表示不限制),所有边消耗的资源之和不能超过maxResource(nil表示不限制).
+用标号法按照权重从小到大扩展部分路径,同一个顶点上被其他标号支配(权重,边数,资源都不比它好)的标号才会被丢弃,
+所以权重较大但是满足限制的路径不会因为先找到了权重小但是违反限制的路径而丢失.
+返回权重从小到大最多limit(<=0表示只返回一条)条互不支配的路径,没有路径时返回nil
+*/
+func (g *Graph) ConstrainedShortestPath(source, target int, cg CostGetter, rg ResourceGetter, maxEdges int, maxResource *big.Int, limit int) (paths [][]int) {
+	if source >= len(g.vertices) || target >= len(g.vertices) || source == target {
+		return nil
+	}
+	if limit <= 0 {
+		limit = 1
+	}
+	labels := make([][]*label, len(g.vertices))
+	start := &label{vertex: source, resource: new(big.Int)}
+	labels[source] = []*label{start}
+	h := &labelHeap{start}
+	created := 1
+	for h.Len() > 0 && len(paths) < limit {
+		l := heap.Pop(h).(*label)
+		if l.vertex == target {
+			paths = append(paths, l.path())
+			continue
+		}
+		if maxEdges > 0 && l.edges >= maxEdges {
+			continue
+		}
+		for to := range g.vertices[l.vertex].Arcs {
+			w := cg(g, l.vertex, to)
+			if w == math.MaxInt32 {
+				continue
+			}
+			l2 := &label{
+				vertex:   to,
+				cost:     l.cost + w,
+				edges:    l.edges + 1,
+				resource: new(big.Int).Add(l.resource, rg(l.vertex, to)),
+				prev:     l,
+				index:    created,
+			}
+			if maxResource != nil && l2.resource.Cmp(maxResource) > 0 {
+				continue
+			}
+			dominated := false
+			for _, l3 := range labels[to] {
+				if l3.dominates(l2) {
+					dominated = true
+					break
+				}
+			}
+			if dominated {
+				continue
+			}
+			labels[to] = append(labels[to], l2)
+			heap.Push(h, l2)
+			created++
+			if created > maxLabels {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,45 @@
+package dijkstra
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraph_ConstrainedShortestPath(t *testing.T) {
+	/*
+		0->1->4 权重2,费用20
+		0->2->3->4 权重3,费用3
+		0->4 权重10,费用0
+	*/
+	g := NewEmptyGraph()
+	for i := 0; i < 6; i++ {
+		g.AddVertex()
+	}
+	fees := map[[2]int]int64{}
+	addEdge := func(src, dst, w int, fee int64) {
+		g.AddEdge(src, dst, w-1)
+		fees[[2]int{src, dst}] = fee
+	}
+	addEdge(0, 1, 1, 10)
+	addEdge(1, 4, 1, 10)
+	addEdge(0, 2, 1, 1)
+	addEdge(2, 3, 1, 1)
+	addEdge(3, 4, 1, 1)
+	addEdge(0, 4, 10, 0)
+	addEdge(4, 0, 1, 0)
+	rg := func(src, dst int) *big.Int {
+		return big.NewInt(fees[[2]int{src, dst}])
+	}
+	assert.EqualValues(t, [][]int{{0, 1, 4}}, g.ConstrainedShortestPath(0, 4, DefaultCostGetter, rg, 0, nil, 1))
+	//费用限制下,边数更多的路径
+	assert.EqualValues(t, [][]int{{0, 2, 3, 4}}, g.ConstrainedShortestPath(0, 4, DefaultCostGetter, rg, 0, big.NewInt(5), 1))
+	//同时限制边数和费用
+	assert.EqualValues(t, [][]int{{0, 4}}, g.ConstrainedShortestPath(0, 4, DefaultCostGetter, rg, 2, big.NewInt(5), 1))
+	assert.Nil(t, g.ConstrainedShortestPath(0, 4, DefaultCostGetter, rg, 0, big.NewInt(-1), 1))
+	//互不支配的多条路径,按照权重排序
+	assert.EqualValues(t, [][]int{{0, 1, 4}, {0, 2, 3, 4}, {0, 4}}, g.ConstrainedShortestPath(0, 4, DefaultCostGetter, rg, 0, nil, 5))
+	//5 不可达
+	assert.Nil(t, g.ConstrainedShortestPath(0, 5, DefaultCostGetter, rg, 0, nil, 1))
+}
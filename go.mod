@@ -1,21 +1,235 @@
 module github.com/SmartMeshFoundation/Photon-Path-Finder
 
+go 1.27.1
+
 replace (
 	github.com/SmartMeshFoundation/Photon v1.0.0 => github.com/nkbai/Photon v1.2.0-rc0
 	github.com/ethereum/go-ethereum v1.8.17 => github.com/nkbai/go-ethereum v0.1.2
-	github.com/mattn/go-xmpp v0.0.1 => github.com/nkbai/go-xmpp v0.0.1
+	github.com/mattn/go-xmpp v0.0.1 => ./third_party/go-xmpp
 )
 
 require (
 	github.com/SmartMeshFoundation/Photon v1.0.0
-	github.com/SmartMeshFoundation/matrix-regservice v0.0.0-20190219025223-14bc68e5eba7 // indirect
 	github.com/ant0ine/go-json-rest v3.3.2+incompatible
 	github.com/ethereum/go-ethereum v1.8.17
 	github.com/jinzhu/gorm v1.9.1
 	github.com/mattn/go-colorable v0.1.0
 	github.com/mattn/go-xmpp v0.0.1
 	github.com/nkbai/goutils v0.0.0-20181219015612-2fa82e8abe13
-	github.com/nkbai/log v0.0.0-20180519141659-86998e435e8c // indirect
 	github.com/stretchr/testify v1.2.2
 	gopkg.in/urfave/cli.v1 v1.20.0
 )
+
+require (
+	bazil.org/fuse v0.0.0-20160811212531-371fbbdaa898 // indirect
+	cloud.google.com/go v0.26.0 // indirect
+	github.com/Azure/azure-pipeline-go v0.0.0-20180607212504-7571e8eb0876 // indirect
+	github.com/Azure/azure-storage-blob-go v0.0.0-20180712005634-eaae161d9d5e // indirect
+	github.com/BurntSushi/toml v0.3.1 // indirect
+	github.com/DataDog/zstd v1.3.4 // indirect
+	github.com/Masterminds/semver v1.4.2 // indirect
+	github.com/NYTimes/gziphandler v1.0.1 // indirect
+	github.com/OneOfOne/xxhash v1.2.2 // indirect
+	github.com/RoaringBitmap/roaring v0.4.16 // indirect
+	github.com/Sereal/Sereal v0.0.0-20180905114147-563b78806e28 // indirect
+	github.com/SmartMeshFoundation/matrix-regservice v0.0.0-20190219025223-14bc68e5eba7 // indirect
+	github.com/StackExchange/wmi v0.0.0-20180725035823-b12b22c5341f // indirect
+	github.com/alecthomas/kingpin v2.2.6+incompatible // indirect
+	github.com/alecthomas/template v0.0.0-20160405071501-a0175ee3bccc // indirect
+	github.com/alecthomas/units v0.0.0-20151022065526-2efee857e7cf // indirect
+	github.com/andreyvit/diff v0.0.0-20170406064948-c7f18ee00883 // indirect
+	github.com/apex/log v1.1.0 // indirect
+	github.com/aristanetworks/goarista v0.0.0-20181101003910-5bb443fba8e0 // indirect
+	github.com/armon/go-metrics v0.0.0-20180917152333-f0300d1749da // indirect
+	github.com/asdine/storm v2.1.1+incompatible // indirect
+	github.com/aws/aws-sdk-go v1.15.59 // indirect
+	github.com/beorn7/perks v0.0.0-20180321164747-3a771d992973 // indirect
+	github.com/blakesmith/ar v0.0.0-20150311145944-8bd4349a67f2 // indirect
+	github.com/boltdb/bolt v1.3.1 // indirect
+	github.com/bouk/httprouter v0.0.0-20160817010721-ee8b3818a7f5 // indirect
+	github.com/btcsuite/btcd v0.0.0-20180924021209-2a560b2036be // indirect
+	github.com/c-bata/go-prompt v0.2.2 // indirect
+	github.com/caarlos0/ctrlc v1.0.0 // indirect
+	github.com/campoy/unique v0.0.0-20180121183637-88950e537e7e // indirect
+	github.com/cespare/cp v0.1.0 // indirect
+	github.com/cespare/xxhash v1.1.0 // indirect
+	github.com/client9/misspell v0.3.4 // indirect
+	github.com/codahale/hdrhistogram v0.0.0-20161010025455-3a0bb77429bd // indirect
+	github.com/coreos/bbolt v1.3.1-coreos.6 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/deckarep/golang-set v1.7.1 // indirect
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
+	github.com/dgryski/go-bitstream v0.0.0-20180413035011-3522498ce2c8 // indirect
+	github.com/docker/docker v0.0.0-20180625184442-8e610b2b55bf // indirect
+	github.com/edsrzf/mmap-go v0.0.0-20170320065105-0bce6a688712 // indirect
+	github.com/elastic/gosigar v0.9.0 // indirect
+	github.com/elazarl/go-bindata-assetfs v1.0.0 // indirect
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/fjl/memsize v0.0.0-20180929194037-2a09253e352a // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/getkin/kin-openapi v0.1.0 // indirect
+	github.com/ghodss/yaml v1.0.0 // indirect
+	github.com/gizak/termui v0.0.0-20170117222342-991cd3d38091 // indirect
+	github.com/glycerine/go-unsnap-stream v0.0.0-20180323001048-9f0cb55181dd // indirect
+	github.com/glycerine/goconvey v0.0.0-20180728074245-46e3a41ad493 // indirect
+	github.com/go-ole/go-ole v1.2.1 // indirect
+	github.com/go-sql-driver/mysql v1.4.0 // indirect
+	github.com/go-stack/stack v1.8.0 // indirect
+	github.com/gogo/protobuf v1.1.1 // indirect
+	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b // indirect
+	github.com/golang/lint v0.0.0-20180702182130-06c8688daad7 // indirect
+	github.com/golang/mock v1.1.1 // indirect
+	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/gonum/blas v0.0.0-20180125090452-e7c5890b24cf // indirect
+	github.com/gonum/diff v0.0.0-20180125090814-f0137a19aa16 // indirect
+	github.com/gonum/floats v0.0.0-20180125090339-7de1f4ea7ab5 // indirect
+	github.com/gonum/integrate v0.0.0-20180125090255-09c2f478329f // indirect
+	github.com/gonum/internal v0.0.0-20180125090855-fda53f8d2571 // indirect
+	github.com/gonum/lapack v0.0.0-20180125091020-f0b8b25edece // indirect
+	github.com/gonum/mathext v0.0.0-20180126232648-3ffefb3e36fc // indirect
+	github.com/gonum/matrix v0.0.0-20180124231301-a41cc49d4c29 // indirect
+	github.com/gonum/stat v0.0.0-20180125090729-ec9c8a1062f4 // indirect
+	github.com/google/go-cmp v0.2.0 // indirect
+	github.com/google/go-github v17.0.0+incompatible // indirect
+	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/google/uuid v1.0.0 // indirect
+	github.com/gopherjs/gopherjs v0.0.0-20181017120253-0766667cb4d1 // indirect
+	github.com/goreleaser/goreleaser v0.91.1 // indirect
+	github.com/goreleaser/nfpm v0.9.7 // indirect
+	github.com/hashicorp/go-immutable-radix v1.0.0 // indirect
+	github.com/hashicorp/go-msgpack v0.0.0-20150518234257-fa3f63826f7c // indirect
+	github.com/hashicorp/go-uuid v1.0.0 // indirect
+	github.com/hashicorp/go.net v0.0.0-20151006203346-104dcad90073 // indirect
+	github.com/hashicorp/golang-lru v0.5.0 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/hashicorp/mdns v0.0.0-20170221172940-4e527d9d8081 // indirect
+	github.com/hashicorp/raft v1.0.0 // indirect
+	github.com/howeyc/gopass v0.0.0-20170109162249-bf9dde6d0d2c // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	github.com/huin/goupnp v1.0.0 // indirect
+	github.com/huin/goutil v0.0.0-20170803182201-1ca381bf3150 // indirect
+	github.com/imdario/mergo v0.3.6 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/influxdata/flux v0.7.0 // indirect
+	github.com/influxdata/influxdb v1.7.0 // indirect
+	github.com/influxdata/influxql v0.0.0-20180925231337-1cbfca8e56b6 // indirect
+	github.com/influxdata/line-protocol v0.0.0-20180522152040-32c6aa80de5e // indirect
+	github.com/influxdata/platform v0.0.0-20181107003602-9b529771ebb3 // indirect
+	github.com/influxdata/tdigest v0.0.0-20180711151920-a7d76c6f093a // indirect
+	github.com/influxdata/usage-client v0.0.0-20160829180054-6d3895376368 // indirect
+	github.com/jackpal/go-nat-pmp v1.0.1 // indirect
+	github.com/jessevdk/go-flags v1.4.0 // indirect
+	github.com/jinzhu/inflection v0.0.0-20180308033659-04140366298a // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/jsternberg/zap-logfmt v1.2.0 // indirect
+	github.com/jtolds/gls v4.2.1+incompatible // indirect
+	github.com/julienschmidt/httprouter v1.2.0 // indirect
+	github.com/jwilder/encoding v0.0.0-20170811194829-b4e1701a28ef // indirect
+	github.com/karalabe/hid v0.0.0-20180420081245-2b4488a37358 // indirect
+	github.com/kevinburke/go-bindata v3.11.0+incompatible // indirect
+	github.com/kisielk/gotool v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/pty v1.1.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	github.com/lib/pq v1.0.0 // indirect
+	github.com/magiconair/properties v1.8.0 // indirect
+	github.com/maruel/panicparse v0.0.0-20160720141634-ad661195ed0e // indirect
+	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/mattn/go-runewidth v0.0.3 // indirect
+	github.com/mattn/go-sqlite3 v1.10.0 // indirect
+	github.com/mattn/go-tty v0.0.0-20180907095812-13ff1204f104 // indirect
+	github.com/mattn/go-zglob v0.0.0-20180803001819-2ea3427bfa53 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/miekg/dns v1.0.13 // indirect
+	github.com/mitchellh/go-homedir v1.0.0 // indirect
+	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
+	github.com/mitchellh/mapstructure v1.1.2 // indirect
+	github.com/mna/pigeon v1.0.1-0.20180808201053-bb0192cfc2ae // indirect
+	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
+	github.com/mschoch/smat v0.0.0-20160514031455-90eadee771ae // indirect
+	github.com/naoina/toml v0.0.0-20170918210437-9fafd6967416 // indirect
+	github.com/nats-io/gnatsd v1.3.0 // indirect
+	github.com/nats-io/go-nats v1.6.0 // indirect
+	github.com/nats-io/go-nats-streaming v0.4.0 // indirect
+	github.com/nats-io/nats-streaming-server v0.11.2 // indirect
+	github.com/nats-io/nuid v1.0.0 // indirect
+	github.com/nkbai/log v0.0.0-20180519141659-86998e435e8c // indirect
+	github.com/nsf/termbox-go v0.0.0-20170211012700-3540b76b9c77 // indirect
+	github.com/olekukonko/tablewriter v0.0.0-20170128050532-febf2d34b54a // indirect
+	github.com/onsi/ginkgo v1.6.0 // indirect
+	github.com/onsi/gomega v1.4.2 // indirect
+	github.com/opentracing/opentracing-go v1.0.2 // indirect
+	github.com/pascaldekloe/goe v0.0.0-20180627143212-57f6aae5913c // indirect
+	github.com/pborman/uuid v1.2.0 // indirect
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/peterh/liner v0.0.0-20170902204657-a37ad3984311 // indirect
+	github.com/philhofer/fwd v1.0.0 // indirect
+	github.com/pierrec/lz4 v2.0.5+incompatible // indirect
+	github.com/pkg/errors v0.8.0 // indirect
+	github.com/pkg/term v0.0.0-20180730021639-bffc007b7fd5 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/client_golang v0.9.0 // indirect
+	github.com/prometheus/client_model v0.0.0-20180712105110-5c3871d89910 // indirect
+	github.com/prometheus/common v0.0.0-20181020173914-7e9e6cabbd39 // indirect
+	github.com/prometheus/procfs v0.0.0-20181005140218-185b4288413d // indirect
+	github.com/prometheus/prometheus v0.0.0-20170814170113-3101606756c5 // indirect
+	github.com/rjeczalik/notify v0.9.2 // indirect
+	github.com/robertkrimen/otto v0.0.0-20170205013659-6a77b7cbc37d // indirect
+	github.com/rs/cors v1.6.0 // indirect
+	github.com/rs/xhandler v0.0.0-20160618193221-ed27b6fd6521 // indirect
+	github.com/satori/go.uuid v1.2.0 // indirect
+	github.com/segmentio/kafka-go v0.1.0 // indirect
+	github.com/sergi/go-diff v1.0.0 // indirect
+	github.com/sirupsen/logrus v1.1.1 // indirect
+	github.com/smartystreets/assertions v0.0.0-20180927180507-b2de0cb4f26d // indirect
+	github.com/smartystreets/goconvey v0.0.0-20180222194500-ef6db91d284a // indirect
+	github.com/spaolacci/murmur3 v0.0.0-20180118202830-f09979ecbc72 // indirect
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.2.0 // indirect
+	github.com/spf13/cobra v0.0.3 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/spf13/viper v1.2.1 // indirect
+	github.com/syndtr/goleveldb v0.0.0-20181105012736-f9080354173f // indirect
+	github.com/tcnksm/go-input v0.0.0-20180404061846-548a7d7a8ee8 // indirect
+	github.com/theckman/go-flock v0.7.0 // indirect
+	github.com/tinylib/msgp v1.0.2 // indirect
+	github.com/tylerb/graceful v1.2.15 // indirect
+	github.com/uber/jaeger-client-go v0.0.0-20180607151842-f7e0d4744fa6 // indirect
+	github.com/uber/jaeger-lib v0.0.0-20180615202729-a51202d6f4a7 // indirect
+	github.com/urfave/cli v1.20.0 // indirect
+	github.com/vmihailenco/msgpack v4.0.0+incompatible // indirect
+	github.com/willf/bitset v1.1.9 // indirect
+	github.com/xlab/treeprint v0.0.0-20180616005107-d6fb6747feb6 // indirect
+	go.uber.org/atomic v1.3.2 // indirect
+	go.uber.org/multierr v1.1.0 // indirect
+	go.uber.org/zap v1.9.1 // indirect
+	golang.org/x/crypto v0.0.0-20181106171534-e4dc69e5b2fd // indirect
+	golang.org/x/lint v0.0.0-20180702182130-06c8688daad7 // indirect
+	golang.org/x/net v0.0.0-20181023162649-9b4f9f5ad519 // indirect
+	golang.org/x/oauth2 v0.0.0-20181017192945-9dcd33a902f4 // indirect
+	golang.org/x/sync v0.0.0-20180314180146-1d60e4601c6f // indirect
+	golang.org/x/sys v0.0.0-20181023152157-44b849a8bc13 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	golang.org/x/time v0.0.0-20180412165947-fbb02b2291d2 // indirect
+	golang.org/x/tools v0.0.0-20181023010539-40a48ad93fbe // indirect
+	google.golang.org/api v0.0.0-20181021000519-a2651947f503 // indirect
+	google.golang.org/appengine v1.2.0 // indirect
+	google.golang.org/genproto v0.0.0-20181016170114-94acd270e44e // indirect
+	google.golang.org/grpc v1.15.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/karalabe/cookiejar.v2 v2.0.0-20150724131613-8dcd6a7f4951 // indirect
+	gopkg.in/natefinch/npipe.v2 v2.0.0-20160621034901-c1b8fa8bdcce // indirect
+	gopkg.in/olebedev/go-duktape.v3 v3.0.0-20180723110524-d53328019b21 // indirect
+	gopkg.in/robfig/cron.v2 v2.0.0-20150107220207-be2e0b0deed5 // indirect
+	gopkg.in/sourcemap.v1 v1.0.5 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/vmihailenco/msgpack.v2 v2.9.1 // indirect
+	gopkg.in/yaml.v2 v2.2.1 // indirect
+	honnef.co/go/tools v0.0.0-20180728063816-88497007e858 // indirect
+	labix.org/v2/mgo v0.0.0-20140701140051-000000000287 // indirect
+	launchpad.net/gocheck v0.0.0-20140225173054-000000000087 // indirect
+)
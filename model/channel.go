@@ -77,9 +77,19 @@ func (c *ChannelParticipantInfo) BalanceValue() *big.Int {
 	return stringToBigInt(c.Balance)
 }
 
-//Fee return this participant's charge fee
-func (c *ChannelParticipantInfo) Fee(token common.Address) *Fee {
-	return GetChannelFeeRate(common.HexToHash(c.ChannelID), common.HexToAddress(c.Participant), token)
+//DepositValue return this participant's deposit
+func (c *ChannelParticipantInfo) DepositValue() *big.Int {
+	return stringToBigInt(c.Deposit)
+}
+
+//TransferredAmountValue return the amount this participant has transferred to partner
+func (c *ChannelParticipantInfo) TransferredAmountValue() *big.Int {
+	return stringToBigInt(c.TransferedAmount)
+}
+
+//LockedAmountValue return this participant's locked amount
+func (c *ChannelParticipantInfo) LockedAmountValue() *big.Int {
+	return stringToBigInt(c.LockedAmount)
 }
 
 //Channel Channel基本信息
@@ -99,11 +109,11 @@ func orderParticipants(p1, p2 *ChannelParticipantInfo) (rp1, rp2 *ChannelPartici
 }
 
 //GetChannel from db
-func GetChannel(channelID string) (c *Channel, err error) {
+func (model *ModelDB) GetChannel(channelID string) (c *Channel, err error) {
 	c = &Channel{
 		ChannelID: channelID,
 	}
-	err = db.Where(c).Preload("Participants").Find(c).Error
+	err = model.db.Where(c).Preload("Participants").Find(c).Error
 	if err != nil {
 		return
 	}
@@ -112,8 +122,8 @@ func GetChannel(channelID string) (c *Channel, err error) {
 }
 
 //GetAllTokenChannels get all channels of this `token`
-func GetAllTokenChannels(token common.Address) (cs []*Channel, err error) {
-	err = db.Where(&Channel{
+func (model *ModelDB) GetAllTokenChannels(token common.Address) (cs []*Channel, err error) {
+	err = model.db.Where(&Channel{
 		Token:  token.String(),
 		Status: ChannelStatusOpen,
 	}).Preload("Participants").Find(&cs).Error
@@ -126,10 +136,47 @@ func GetAllTokenChannels(token common.Address) (cs []*Channel, err error) {
 	return
 }
 
+//GetTokenChannelsPage 分页获取`token`的所有通道,包括已经关闭的,按通道id排序
+func (model *ModelDB) GetTokenChannelsPage(token common.Address, offset, limit int) (cs []*Channel, total int, err error) {
+	where := &Channel{Token: token.String()}
+	err = model.db.Model(&Channel{}).Where(where).Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = model.db.Where(where).Order("channel_id").Offset(offset).Limit(limit).Preload("Participants").Find(&cs).Error
+	if err != nil {
+		return
+	}
+	for _, c := range cs {
+		c.Participants[0], c.Participants[1] = orderParticipants(c.Participants[0], c.Participants[1])
+	}
+	return
+}
+
+//GetParticipantChannelsPage 分页获取`participant`参与的所有通道,包括已经关闭的,按通道id排序
+func (model *ModelDB) GetParticipantChannelsPage(participant common.Address, offset, limit int) (cs []*Channel, total int, err error) {
+	//参与方很多通道时,in (?)的参数个数会超过sqlite的限制,所以用子查询
+	ids := model.db.Model(&ChannelParticipantInfo{}).Select("channel_id").Where(&ChannelParticipantInfo{
+		Participant: participant.String(),
+	}).SubQuery()
+	err = model.db.Model(&Channel{}).Where("channel_id in ?", ids).Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = model.db.Where("channel_id in ?", ids).Order("channel_id").Offset(offset).Limit(limit).Preload("Participants").Find(&cs).Error
+	if err != nil {
+		return
+	}
+	for _, c := range cs {
+		c.Participants[0], c.Participants[1] = orderParticipants(c.Participants[0], c.Participants[1])
+	}
+	return
+}
+
 //AddChannel add channel to db, 必须将相应的participant 信息清空.
-func AddChannel(token, participant1, participant2 common.Address, ChannelIdentifier common.Hash, blockNumber int64) (c *Channel, err error) {
+func (model *ModelDB) AddChannel(token, participant1, participant2 common.Address, ChannelIdentifier common.Hash, blockNumber int64) (c *Channel, err error) {
 	channelID := ChannelIdentifier.String()
-	c, err = GetChannel(channelID)
+	c, err = model.GetChannel(channelID)
 	if err == nil {
 		err = fmt.Errorf("channelId %s duplicate", channelID)
 		return
@@ -146,10 +193,54 @@ func AddChannel(token, participant1, participant2 common.Address, ChannelIdentif
 	}
 	p1, p2 = orderParticipants(p1, p2)
 	c.Participants = []*ChannelParticipantInfo{p1, p2}
-	err = db.Create(c).Error
+	err = model.db.Create(c).Error
 	return
 }
 
+//更新balance proof失败的错误码
+const (
+	//ErrCodeChannelNotFound 通道不存在或者已经结算
+	ErrCodeChannelNotFound = "channel_not_found"
+	//ErrCodeOpenBlockNumberMismatch 通道被重新打开过,balance proof属于以前的通道
+	ErrCodeOpenBlockNumberMismatch = "open_block_number_mismatch"
+	//ErrCodeParticipantMismatch 提交者或者balance proof的签名者不是通道参与方
+	ErrCodeParticipantMismatch = "participant_mismatch"
+	//ErrCodeNonceRegression nonce比已知的小
+	ErrCodeNonceRegression = "nonce_regression"
+	//ErrCodeTransferAmountDecrease transfer amount比已知的小
+	ErrCodeTransferAmountDecrease = "transfer_amount_decrease"
+	//ErrCodeInvalidBalanceProof balance proof缺少字段或者金额为负数
+	ErrCodeInvalidBalanceProof = "invalid_balance_proof"
+	//ErrCodeInvalidSignature 提交者或者balance proof的签名不正确
+	ErrCodeInvalidSignature = "invalid_signature"
+	//ErrCodeLocksRootMismatch 提交的锁计算出来的locksroot与balance proof不一致
+	ErrCodeLocksRootMismatch = "locksroot_mismatch"
+	//ErrCodeLockedAmountMismatch LockedAmount与提交的锁金额之和不一致,或者没有锁却有LockedAmount
+	ErrCodeLockedAmountMismatch = "locked_amount_mismatch"
+	//ErrCodeLockedAmountExceeded LockedAmount超过了对方在通道中剩下的钱
+	ErrCodeLockedAmountExceeded = "locked_amount_exceeded"
+	//ErrCodeLocksMissing locksroot不为空但是没有提交锁,无法验证LockedAmount
+	ErrCodeLocksMissing = "locks_missing"
+)
+
+//BalanceProofError 更新balance proof失败的原因,Code用于节点区分不同的错误
+type BalanceProofError struct {
+	Code    string
+	Message string
+}
+
+func (e *BalanceProofError) Error() string {
+	return e.Message
+}
+
+//NewBalanceProofError create a BalanceProofError with `code`
+func NewBalanceProofError(code string, format string, a ...interface{}) error {
+	return &BalanceProofError{
+		Code:    code,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
 // BalanceProof is the json request for BalanceProof
 type BalanceProof struct {
 	Nonce           uint64      `json:"nonce"`
@@ -170,19 +261,33 @@ func verifyParticipants(c *Channel, participant1, participant2 common.Address) (
 	} else if participant1.String() == p2.Participant && participant2.String() == p1.Participant {
 		p1, p2 = p2, p1
 	} else {
-		err = fmt.Errorf("channel participants not match ")
+		err = NewBalanceProofError(ErrCodeParticipantMismatch, "channel %s participants not match %s,%s", c.ChannelID, participant1.String(), participant2.String())
+	}
+	return
+}
+
+//GetChannelWithParticipants 获取通道,并且检查participant1和participant2是否是这个通道的双方
+func (model *ModelDB) GetChannelWithParticipants(channelIdentifier common.Hash, participant1, participant2 common.Address) (c *Channel, err error) {
+	c, err = model.GetChannel(channelIdentifier.String())
+	if gorm.IsRecordNotFoundError(err) {
+		err = NewBalanceProofError(ErrCodeChannelNotFound, "channel %s not found", channelIdentifier.String())
+		return
+	}
+	if err != nil {
+		return
 	}
+	_, _, err = verifyParticipants(c, participant1, participant2)
 	return
 }
 
 //UpdateChannelBalanceProof update balance proof
-func UpdateChannelBalanceProof(participant, partner common.Address, lockedAmount *big.Int, partnerBalanceProof *BalanceProof, ignoreMediatedTransfer bool) (c *Channel, err error) {
-	c, err = GetChannel(partnerBalanceProof.ChannelID.String())
+func (model *ModelDB) UpdateChannelBalanceProof(participant, partner common.Address, lockedAmount *big.Int, partnerBalanceProof *BalanceProof, ignoreMediatedTransfer bool) (c *Channel, err error) {
+	c, err = model.GetChannelWithParticipants(partnerBalanceProof.ChannelID, participant, partner)
 	if err != nil {
 		return
 	}
 	if c.OpenBlockNumber != partnerBalanceProof.OpenBlockNumber {
-		err = fmt.Errorf("receive UpdateChannelBalanceProof on channel=%s,but open block number not match ,database openblocknumber=%d,balanceproof=%d",
+		err = NewBalanceProofError(ErrCodeOpenBlockNumberMismatch, "receive UpdateChannelBalanceProof on channel=%s,but open block number not match ,database openblocknumber=%d,balanceproof=%d",
 			c.ChannelID, c.OpenBlockNumber, partnerBalanceProof.OpenBlockNumber,
 		)
 		return
@@ -191,10 +296,21 @@ func UpdateChannelBalanceProof(participant, partner common.Address, lockedAmount
 	if err != nil {
 		return
 	}
+	if lockedAmount == nil {
+		lockedAmount = new(big.Int)
+	}
+	//对方锁定的钱不可能超过对方在通道中剩下的钱,也就是存款+收到的-这个balance proof中转出的
+	maxLocked := new(big.Int).Add(stringToBigInt(p.Deposit), stringToBigInt(p1.TransferedAmount))
+	maxLocked.Sub(maxLocked, partnerBalanceProof.TransferAmount)
+	if lockedAmount.Sign() > 0 && lockedAmount.Cmp(maxLocked) > 0 {
+		err = NewBalanceProofError(ErrCodeLockedAmountExceeded, "locked amount %s exceeds deposit %s plus received %s minus transferred %s",
+			lockedAmount, stringToBigInt(p.Deposit), stringToBigInt(p1.TransferedAmount), partnerBalanceProof.TransferAmount)
+		return
+	}
 	//在测试链上的时候,启用debug mode,这样节点删除数据库也不影响,否则会导致删除之后交易不能提交.
 	if !params.DebugMode {
 		if p.Nonce > partnerBalanceProof.Nonce {
-			err = fmt.Errorf("nonce not match,now=%d,got=%d", p.Nonce, partnerBalanceProof.Nonce)
+			err = NewBalanceProofError(ErrCodeNonceRegression, "nonce not match,now=%d,got=%d", p.Nonce, partnerBalanceProof.Nonce)
 			return
 		}
 		if p.Nonce == partnerBalanceProof.Nonce {
@@ -203,7 +319,7 @@ func UpdateChannelBalanceProof(participant, partner common.Address, lockedAmount
 		}
 		bi := stringToBigInt(p.TransferedAmount)
 		if bi.Cmp(partnerBalanceProof.TransferAmount) > 0 {
-			err = fmt.Errorf("transfer amount cannot decrease now=%s,got=%s", bi, partnerBalanceProof.TransferAmount)
+			err = NewBalanceProofError(ErrCodeTransferAmountDecrease, "transfer amount cannot decrease now=%s,got=%s", bi, partnerBalanceProof.TransferAmount)
 			return
 		}
 	}
@@ -211,15 +327,15 @@ func UpdateChannelBalanceProof(participant, partner common.Address, lockedAmount
 	p.TransferedAmount = bigIntToString(partnerBalanceProof.TransferAmount)
 	p.LockedAmount = bigIntToString(lockedAmount)
 	p.IgnoreMediatedTransfer = ignoreMediatedTransfer
-	err = updateBalance(p, p1)
+	err = model.updateBalance(p, p1)
 	//没必要再来查一次了,c中的就已经是最新的了
-	//c, err = GetChannel(partnerBalanceProof.ChannelID.String())
+	//c, err = model.GetChannel(partnerBalanceProof.ChannelID.String())
 	return
 }
 
 //UpdateChannelDeposit 链上发生了deposit事件,需要更新信息
-func UpdateChannelDeposit(channelIdentifier common.Hash, participant common.Address, deposit *big.Int) (c *Channel, err error) {
-	c, err = GetChannel(channelIdentifier.String())
+func (model *ModelDB) UpdateChannelDeposit(channelIdentifier common.Hash, participant common.Address, deposit *big.Int) (c *Channel, err error) {
+	c, err = model.GetChannel(channelIdentifier.String())
 	if err != nil {
 		return
 	}
@@ -229,42 +345,42 @@ func UpdateChannelDeposit(channelIdentifier common.Hash, participant common.Addr
 		p = c.Participants[1]
 	}
 	p.Deposit = bigIntToString(deposit)
-	err = updateBalance(c.Participants[0], c.Participants[1])
+	err = model.updateBalance(c.Participants[0], c.Participants[1])
 	return
 }
 
 //CloseChannel because of channel closed event
-func CloseChannel(channelIdentifier common.Hash) (c *Channel, err error) {
-	c, err = GetChannel(channelIdentifier.String())
+func (model *ModelDB) CloseChannel(channelIdentifier common.Hash) (c *Channel, err error) {
+	c, err = model.GetChannel(channelIdentifier.String())
 	if err != nil {
 		return
 	}
 	c.Status = ChannelStatusClosed
-	err = db.Model(c).UpdateColumn("status", c.Status).Error
+	err = model.db.Model(c).UpdateColumn("status", c.Status).Error
 	return
 }
 
 //SettleChannel because of channel settled event
-func SettleChannel(channelIdentifier common.Hash) (c *Channel, err error) {
-	c, err = GetChannel(channelIdentifier.String())
+func (model *ModelDB) SettleChannel(channelIdentifier common.Hash) (c *Channel, err error) {
+	c, err = model.GetChannel(channelIdentifier.String())
 	if err != nil {
 		return
 	}
 	c.Status = ChannelStatusSettled
-	tx := db.Begin()
+	tx := model.begin()
 	err = tx.Delete(c).Error
 	if err != nil {
-		tx.Rollback()
+		tx.rollback()
 		return
 	}
 	err = tx.Delete(c.Participants[0]).Error
 	if err != nil {
-		tx.Rollback()
+		tx.rollback()
 		return
 	}
 	err = tx.Delete(c.Participants[1]).Error
 	if err != nil {
-		tx.Rollback()
+		tx.rollback()
 		return
 	}
 	s := &SettledChannel{
@@ -274,23 +390,23 @@ func SettleChannel(channelIdentifier common.Hash) (c *Channel, err error) {
 	}
 	raw, err := json.Marshal(c)
 	if err != nil {
-		tx.Rollback()
+		tx.rollback()
 		return
 	}
 	s.Data = string(raw)
 	err = tx.Create(s).Error
 	if err != nil {
-		tx.Rollback()
+		tx.rollback()
 		return
 	}
-	err = tx.Commit().Error
+	err = tx.commit()
 	return
 
 }
 
 //WithDrawChannel because of withdraw event
-func WithDrawChannel(channelIdentifier common.Hash, p1Address, p2Address common.Address, p1Balance, p2Balance *big.Int, blockNumber int64) (c *Channel, err error) {
-	c, err = GetChannel(channelIdentifier.String())
+func (model *ModelDB) WithDrawChannel(channelIdentifier common.Hash, p1Address, p2Address common.Address, p1Balance, p2Balance *big.Int, blockNumber int64) (c *Channel, err error) {
+	c, err = model.GetChannel(channelIdentifier.String())
 	if err != nil {
 		return
 	}
@@ -311,7 +427,7 @@ func WithDrawChannel(channelIdentifier common.Hash, p1Address, p2Address common.
 	p2.TransferedAmount = utils.BigInt0.String()
 	p2.LockedAmount = utils.BigInt0.String()
 	p2.Balance = bigIntToString(p2Balance)
-	err = db.Save(c).Error
+	err = model.db.Save(c).Error
 	return
 }
 func bigIntToString(b *big.Int) string {
@@ -328,56 +444,77 @@ func stringToBigInt(s string) *big.Int {
 	return bi
 }
 
-func updateBalance(p1, p2 *ChannelParticipantInfo) (err error) {
-	p1TransferAmount := stringToBigInt(p1.TransferedAmount)
-	p1LockedAmount := stringToBigInt(p1.LockedAmount)
-	p1Deposit := stringToBigInt(p1.Deposit)
-	p2TransferAmount := stringToBigInt(p2.TransferedAmount)
-	p2LockedAmount := stringToBigInt(p2.LockedAmount)
-	p2Deposit := stringToBigInt(p2.Deposit)
-	p1Balance := p1Deposit.Add(p1Deposit, p2TransferAmount).Sub(p1Deposit, p1TransferAmount).Sub(p1Deposit, p1LockedAmount)
-	p2Balance := p2Deposit.Add(p2Deposit, p1TransferAmount).Sub(p2Deposit, p2TransferAmount).Sub(p2Deposit, p2LockedAmount)
+/*
+balanceOf 计算p的可用余额: 存款+收到的-转出的-锁定的,
+可能是负数
+*/
+func balanceOf(p, partner *ChannelParticipantInfo) *big.Int {
+	b := stringToBigInt(p.Deposit)
+	b.Add(b, stringToBigInt(partner.TransferedAmount))
+	b.Sub(b, stringToBigInt(p.TransferedAmount))
+	b.Sub(b, stringToBigInt(p.LockedAmount))
+	return b
+}
+
+/*
+ComputeBalances 根据双方的存款,转账金额和锁定金额计算双方的可用余额,不会修改p1,p2.
+虽然正常情况下是不会出现负数,但是问题就在于如果一方无网,一方有网,就会出现负数的情况. 如果无网一方不提交balance proof,
+那么会造成有网一方也不能提交. 这会造成不必要的麻烦. 所以负数的余额按0处理.
+*/
+func ComputeBalances(p1, p2 *ChannelParticipantInfo) (p1Balance, p2Balance *big.Int) {
+	p1Balance = balanceOf(p1, p2)
+	p2Balance = balanceOf(p2, p1)
+	if p1Balance.Sign() < 0 {
+		p1Balance = new(big.Int)
+	}
+	if p2Balance.Sign() < 0 {
+		p2Balance = new(big.Int)
+	}
+	return
+}
+
+//updateBalance 重新计算双方的余额并保存
+func (model *ModelDB) updateBalance(p1, p2 *ChannelParticipantInfo) (err error) {
+	if b := balanceOf(p1, p2); b.Sign() < 0 {
+		log.Error(fmt.Sprintf("p1 %s balance is negative  %s,channel=%s", p1.Participant, b, p1.ChannelID))
+	}
+	if b := balanceOf(p2, p1); b.Sign() < 0 {
+		log.Error(fmt.Sprintf("p2 %s balance is negative  %s,channel=%s", p2.Participant, b, p2.ChannelID))
+	}
+	p1Balance, p2Balance := ComputeBalances(p1, p2)
 	p1.Balance = p1Balance.String()
 	p2.Balance = p2Balance.String()
-	/*
-		虽然正常情况下是不会出现负数,但是问题就在于如果一方无网,一方有网,就会出现负数的情况. 如果无网一方不提交balance proof,
-		那么会造成有网一方也不能提交. 这会造成不必要的麻烦.
-	*/
-	if p1Balance.Cmp(utils.BigInt0) < 0 {
-		log.Error(fmt.Sprintf("p1 %s balance is negative  %s,channel=%s", p1.Participant, p1Balance, p1.ChannelID))
-		p1.Balance = utils.BigInt0.String()
-		//return fmt.Errorf("p1 %s balance is negative  %s", p1.Participant, p1Balance)
-	}
-	if p2Balance.Cmp(utils.BigInt0) < 0 {
-		log.Error(fmt.Sprintf("p2 %s balance is negative  %s,channel=%s", p2.Participant, p2Balance, p2.ChannelID))
-		p2.Balance = utils.BigInt0.String()
-		//return fmt.Errorf("p2 %s balance is negative %s", p2.Participant, p2Balance)
-	}
-	tx := db.Begin()
+	return model.saveParticipants(p1, p2)
+}
+
+//saveParticipants 在一个事务中保存通道双方的信息
+func (model *ModelDB) saveParticipants(p1, p2 *ChannelParticipantInfo) (err error) {
+	tx := model.begin()
 	err = tx.Save(p1).Error
 	if err != nil {
+		tx.rollback()
 		return
 	}
 	err = tx.Save(p2).Error
 	if err != nil {
-		tx.Rollback()
+		tx.rollback()
 		return
 	}
-	return tx.Commit().Error
+	return tx.commit()
 }
 
-func getDirectChannelFee(channelIdentifier common.Hash, participant common.Address) (cf *ChannelParticipantFee, err error) {
+func (model *ModelDB) getDirectChannelFee(channelIdentifier common.Hash, participant common.Address) (cf *ChannelParticipantFee, err error) {
 	cf = &ChannelParticipantFee{
 		ChannelID:   channelIdentifier.String(),
 		Participant: participant.String(),
 	}
-	err = db.Where(cf).Find(cf).Error
+	err = model.db.Where(cf).Find(cf).Error
 	return
 }
 
 //UpdateChannelFeeRate update channel's fee rate
-func UpdateChannelFeeRate(channelIdentifier common.Hash, participant, token common.Address, fee *Fee) (err error) {
-	cf, err := getDirectChannelFee(channelIdentifier, participant)
+func (model *ModelDB) UpdateChannelFeeRate(channelIdentifier common.Hash, participant, token common.Address, fee *Fee) (err error) {
+	cf, err := model.getDirectChannelFee(channelIdentifier, participant)
 	if err != nil {
 		cf = &ChannelParticipantFee{
 			ChannelID:   channelIdentifier.String(),
@@ -389,13 +526,13 @@ func UpdateChannelFeeRate(channelIdentifier common.Hash, participant, token comm
 	cf.FeeConstantPart = bigIntToString(fee.FeeConstant)
 	cf.FeePercentPart = fee.FeePercent
 
-	err = db.Save(cf).Error
+	err = model.db.Save(cf).Error
 	return
 }
 
 //GetChannelFeeRate get channel's fee rate
-func GetChannelFeeRate(channelIdentifier common.Hash, participant, token common.Address) (fee *Fee) {
-	cf, err := getDirectChannelFee(channelIdentifier, participant)
+func (model *ModelDB) GetChannelFeeRate(channelIdentifier common.Hash, participant, token common.Address) (fee *Fee) {
+	cf, err := model.getDirectChannelFee(channelIdentifier, participant)
 	if err == nil {
 		fee = &Fee{
 			FeePolicy:   cf.FeePolicy,
@@ -405,10 +542,10 @@ func GetChannelFeeRate(channelIdentifier common.Hash, participant, token common.
 		return
 	}
 	//从来没有针对通道设置过
-	fee, err = GetAccountTokenFee(participant, token)
+	fee, err = model.GetAccountTokenFee(participant, token)
 	if err == nil {
 		return
 	}
-	fee = GetAccountFeePolicy(participant)
+	fee = model.GetAccountFeePolicy(participant)
 	return
 }
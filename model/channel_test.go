@@ -1,8 +1,12 @@
 package model
 
 import (
+	"errors"
 	"math/big"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
 
@@ -12,6 +16,7 @@ import (
 
 	"github.com/SmartMeshFoundation/Photon/utils"
 
+	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
@@ -20,7 +25,7 @@ func TestSetUpDB(t *testing.T) {
 }
 
 func TestChannel(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	c := &Channel{
 		ChannelID: utils.NewRandomHash().String(),
 		Status:    ChannelStatusClosed,
@@ -33,16 +38,16 @@ func TestChannel(t *testing.T) {
 	c.Participants[1] = &ChannelParticipantInfo{
 		Nonce: 2,
 	}
-	if err := db.Create(c).Error; err != nil {
+	if err := model.db.Create(c).Error; err != nil {
 		t.Errorf("new channel error %s", err)
 		return
 	}
-	if err := db.Create(c); err == nil {
+	if err := model.db.Create(c); err == nil {
 		t.Error("cannot duplicate")
 		return
 	}
 	var c2 Channel
-	err := db.First(&c2, &Channel{ChannelID: c.ChannelID}).Error
+	err := model.db.First(&c2, &Channel{ChannelID: c.ChannelID}).Error
 	if err != nil {
 		t.Error(err)
 		return
@@ -54,7 +59,7 @@ func TestChannel(t *testing.T) {
 	c3 := &Channel{
 		ChannelID: c.ChannelID,
 	}
-	if err := db.Where(c3).First(c3).Error; err != nil {
+	if err := model.db.Where(c3).First(c3).Error; err != nil {
 		t.Error(err)
 		return
 	}
@@ -63,28 +68,28 @@ func TestChannel(t *testing.T) {
 		return
 	}
 	var c4 Channel
-	if err := db.Debug().Where(c3).Preload("Participants").Find(&c4).Error; err != nil {
+	if err := model.db.Debug().Where(c3).Preload("Participants").Find(&c4).Error; err != nil {
 		t.Error(err)
 		return
 	}
-	//db.Preloads("Participants").Find(c2)
+	//model.db.Preloads("Participants").Find(c2)
 	if c4.Participants == nil {
 		//t.Logf("c=%s\nc4=%s", utils.StringInterface(c, 3), utils.StringInterface(c2, 3))
 		t.Error("must equal")
 	}
 	t.Logf("c4=%s", utils.StringInterface(c4, 3))
 }
-func testCreateChannel(t *testing.T) (c2 *Channel) {
+func testCreateChannel(t *testing.T, model *ModelDB) (c2 *Channel) {
 	token := utils.NewRandomAddress()
 	channelIdentifier := utils.NewRandomHash()
 	participant1 := utils.NewRandomAddress()
 	participant2 := utils.NewRandomAddress()
-	_, err := AddChannel(token, participant1, participant2, channelIdentifier, 3)
+	_, err := model.AddChannel(token, participant1, participant2, channelIdentifier, 3)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, err = GetChannel(channelIdentifier.String())
+	c2, err = model.GetChannel(channelIdentifier.String())
 	if err != nil {
 		t.Error(err)
 		panic(err)
@@ -92,7 +97,7 @@ func testCreateChannel(t *testing.T) (c2 *Channel) {
 	return c2
 }
 func TestAddChannel(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	token := utils.NewRandomAddress()
 	channelIdentifier := utils.NewRandomHash()
 	c := &Channel{
@@ -110,12 +115,12 @@ func TestAddChannel(t *testing.T) {
 		Participant: participant2.String(),
 	}
 	c.Participants[0], c.Participants[1] = orderParticipants(c.Participants[0], c.Participants[1])
-	_, err := AddChannel(token, participant1, participant2, channelIdentifier, 3)
+	_, err := model.AddChannel(token, participant1, participant2, channelIdentifier, 3)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, err := GetChannel(c.ChannelID)
+	c2, err := model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c.ChannelID, c2.ChannelID)
 	assert.EqualValues(t, c.Status, c2.Status)
 	assert.EqualValues(t, c.Token, c2.Token)
@@ -125,7 +130,7 @@ func TestAddChannel(t *testing.T) {
 	assert.EqualValues(t, c.Participants[1].Nonce, c2.Participants[1].Nonce)
 }
 func TestGetAllTokenChannels(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 
 	token := utils.NewRandomAddress()
 	channelIdentifier := utils.NewRandomHash()
@@ -143,18 +148,18 @@ func TestGetAllTokenChannels(t *testing.T) {
 	c.Participants[1] = &ChannelParticipantInfo{
 		Participant: participant2.String(),
 	}
-	cs, err := GetAllTokenChannels(token)
+	cs, err := model.GetAllTokenChannels(token)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	t.Logf("cs=%s", utils.StringInterface(cs, 3))
-	_, err = AddChannel(token, participant1, participant2, channelIdentifier, 3)
+	_, err = model.AddChannel(token, participant1, participant2, channelIdentifier, 3)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	cs, err = GetAllTokenChannels(token)
+	cs, err = model.GetAllTokenChannels(token)
 	if err != nil {
 		t.Error(err)
 		return
@@ -165,15 +170,15 @@ func TestGetAllTokenChannels(t *testing.T) {
 }
 
 func TestCloseChannel(t *testing.T) {
-	SetupTestDB()
-	c := testCreateChannel(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
 	assert.EqualValues(t, c.Status, ChannelStatusOpen)
-	_, err := CloseChannel(common.HexToHash(c.ChannelID))
+	_, err := model.CloseChannel(common.HexToHash(c.ChannelID))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, err := GetChannel(c.ChannelID)
+	c2, err := model.GetChannel(c.ChannelID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -182,27 +187,27 @@ func TestCloseChannel(t *testing.T) {
 }
 
 func TestSettleChannel(t *testing.T) {
-	SetupTestDB()
-	c := testCreateChannel(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
 	assert.EqualValues(t, len(c.Participants), 2)
-	_, err := SettleChannel(common.HexToHash(c.ChannelID))
+	_, err := model.SettleChannel(common.HexToHash(c.ChannelID))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	_, err = GetChannel(c.ChannelID)
+	_, err = model.GetChannel(c.ChannelID)
 	if err == nil {
 		t.Error("should deleted")
 	}
-	//AddChannel(token, participant1, participant2 common.Address, ChannelIdentifier common.Hash, blockNumber int64)
-	c2, err := AddChannel(common.HexToAddress(c.Token), common.HexToAddress(c.Participants[0].Participant),
+	//model.AddChannel(token, participant1, participant2 common.Address, ChannelIdentifier common.Hash, blockNumber int64)
+	c2, err := model.AddChannel(common.HexToAddress(c.Token), common.HexToAddress(c.Participants[0].Participant),
 		common.HexToAddress(c.Participants[1].Participant), common.HexToHash(c.ChannelID), c.OpenBlockNumber)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	assert.EqualValues(t, len(c2.Participants), 2)
-	c3, err := GetChannel(c.ChannelID)
+	c3, err := model.GetChannel(c.ChannelID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -211,174 +216,174 @@ func TestSettleChannel(t *testing.T) {
 }
 
 func TestUpdateChannelDeposit(t *testing.T) {
-	SetupTestDB()
-	c := testCreateChannel(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
 	channelIdentifier := common.HexToHash(c.ChannelID)
 	p1 := c.Participants[0]
 	p2 := c.Participants[1]
-	_, err := UpdateChannelDeposit(channelIdentifier, common.HexToAddress(p1.Participant), big.NewInt(20))
+	_, err := model.UpdateChannelDeposit(channelIdentifier, common.HexToAddress(p1.Participant), big.NewInt(20))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, _ := GetChannel(c.ChannelID)
+	c2, _ := model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c2.Participants[0].Balance, big.NewInt(20).String())
-	_, err = UpdateChannelDeposit(channelIdentifier, common.HexToAddress(p2.Participant), big.NewInt(30))
+	_, err = model.UpdateChannelDeposit(channelIdentifier, common.HexToAddress(p2.Participant), big.NewInt(30))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, _ = GetChannel(c.ChannelID)
+	c2, _ = model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c2.Participants[0].Balance, big.NewInt(20).String())
 	assert.EqualValues(t, c2.Participants[1].Balance, big.NewInt(30).String())
 }
 func TestUpdateChannelBalanceProof(t *testing.T) {
 	ast := assert.New(t)
-	SetupTestDB()
-	c := testCreateChannel(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
 	participant := common.HexToAddress(c.Participants[0].Participant)
 	partner := common.HexToAddress(c.Participants[1].Participant)
-	_, err := UpdateChannelDeposit(common.HexToHash(c.ChannelID), participant, big.NewInt(50))
+	_, err := model.UpdateChannelDeposit(common.HexToHash(c.ChannelID), participant, big.NewInt(50))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	_, err = UpdateChannelDeposit(common.HexToHash(c.ChannelID), partner, big.NewInt(70))
+	_, err = model.UpdateChannelDeposit(common.HexToHash(c.ChannelID), partner, big.NewInt(70))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, _ := GetChannel(c.ChannelID)
+	c2, _ := model.GetChannel(c.ChannelID)
 	ast.EqualValues(c2.Participants[0].Balance, "50")
 	ast.EqualValues(c2.Participants[1].Balance, "70")
 
-	_, err = UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
+	_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
 		ChannelID:       common.HexToHash(c.ChannelID),
 		OpenBlockNumber: c.OpenBlockNumber,
 		TransferAmount:  big.NewInt(32),
 		Nonce:           1,
 		LocksRoot:       utils.NewRandomHash(),
-	})
+	}, false)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	if !params.DebugMode {
-		_, err = UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
+		_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
 			ChannelID:       common.HexToHash(c.ChannelID),
 			OpenBlockNumber: c.OpenBlockNumber,
 			TransferAmount:  big.NewInt(32),
 			Nonce:           0,
 			LocksRoot:       utils.NewRandomHash(),
-		})
+		}, false)
 		if err == nil {
 			t.Error("should failed because of nonce")
 			return
 		}
-		_, err = UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
+		_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
 			ChannelID:       common.HexToHash(c.ChannelID),
 			OpenBlockNumber: c.OpenBlockNumber,
 			TransferAmount:  big.NewInt(22),
 			Nonce:           3,
 			LocksRoot:       utils.NewRandomHash(),
-		})
+		}, false)
 		if err == nil {
 			t.Error("should failed because of transfer amount decrease")
 			return
 		}
 	}
 
-	c2, _ = GetChannel(c.ChannelID)
+	c2, _ = model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c2.Participants[0].Balance, big.NewInt(82).String())
 	assert.EqualValues(t, c2.Participants[1].Balance, big.NewInt(38).String())
-	_, err = UpdateChannelBalanceProof(partner, participant, big.NewInt(50), &BalanceProof{
+	_, err = model.UpdateChannelBalanceProof(partner, participant, big.NewInt(50), &BalanceProof{
 		ChannelID:       common.HexToHash(c.ChannelID),
 		OpenBlockNumber: c.OpenBlockNumber,
 		TransferAmount:  big.NewInt(10),
 		Nonce:           1,
 		LocksRoot:       utils.NewRandomHash(),
-	})
+	}, false)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, _ = GetChannel(c.ChannelID)
+	c2, _ = model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c2.Participants[0].Balance, big.NewInt(22).String())
 	assert.EqualValues(t, c2.Participants[0].LockedAmount, big.NewInt(50).String())
 	assert.EqualValues(t, c2.Participants[1].Balance, big.NewInt(48).String())
 }
 
 func TestWithDrawChannel(t *testing.T) {
-	SetupTestDB()
-	c := testCreateChannel(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
 	channelIdentifier := common.HexToHash(c.ChannelID)
 	participant := common.HexToAddress(c.Participants[0].Participant)
 	partner := common.HexToAddress(c.Participants[1].Participant)
-	_, err := UpdateChannelDeposit(common.HexToHash(c.ChannelID), participant, big.NewInt(50))
+	_, err := model.UpdateChannelDeposit(common.HexToHash(c.ChannelID), participant, big.NewInt(50))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	_, err = UpdateChannelDeposit(common.HexToHash(c.ChannelID), partner, big.NewInt(50))
+	_, err = model.UpdateChannelDeposit(common.HexToHash(c.ChannelID), partner, big.NewInt(50))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	_, err = UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
+	_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
 		ChannelID:       common.HexToHash(c.ChannelID),
 		OpenBlockNumber: c.OpenBlockNumber,
 		TransferAmount:  big.NewInt(32),
 		Nonce:           1,
 		LocksRoot:       utils.NewRandomHash(),
-	})
+	}, false)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	if !params.DebugMode {
-		_, err = UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
+		_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
 			ChannelID:       common.HexToHash(c.ChannelID),
 			OpenBlockNumber: c.OpenBlockNumber,
 			TransferAmount:  big.NewInt(32),
 			Nonce:           0,
 			LocksRoot:       utils.NewRandomHash(),
-		})
+		}, false)
 		if err == nil {
 			t.Error("should failed because of nonce")
 			return
 		}
-		_, err = UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
+		_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), &BalanceProof{
 			ChannelID:       common.HexToHash(c.ChannelID),
 			OpenBlockNumber: c.OpenBlockNumber,
 			TransferAmount:  big.NewInt(22),
 			Nonce:           3,
 			LocksRoot:       utils.NewRandomHash(),
-		})
+		}, false)
 		if err == nil {
 			t.Error("should failed because of transfer amount decrease")
 			return
 		}
 	}
 
-	c2, _ := GetChannel(c.ChannelID)
+	c2, _ := model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c2.Participants[0].Balance, big.NewInt(82).String())
 	assert.EqualValues(t, c2.Participants[1].Balance, big.NewInt(18).String())
-	_, err = UpdateChannelBalanceProof(partner, participant, big.NewInt(50), &BalanceProof{
+	_, err = model.UpdateChannelBalanceProof(partner, participant, big.NewInt(50), &BalanceProof{
 		ChannelID:       common.HexToHash(c.ChannelID),
 		OpenBlockNumber: c.OpenBlockNumber,
 		TransferAmount:  big.NewInt(10),
 		Nonce:           1,
 		LocksRoot:       utils.NewRandomHash(),
-	})
+	}, false)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	c2, _ = GetChannel(c.ChannelID)
+	c2, _ = model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c2.Participants[0].Balance, big.NewInt(22).String())
 	assert.EqualValues(t, c2.Participants[0].LockedAmount, big.NewInt(50).String())
 	assert.EqualValues(t, c2.Participants[1].Balance, big.NewInt(28).String())
-	_, err = WithDrawChannel(channelIdentifier,
+	_, err = model.WithDrawChannel(channelIdentifier,
 		common.HexToAddress(c.Participants[0].Participant),
 		common.HexToAddress(c.Participants[1].Participant),
 		big.NewInt(10), big.NewInt(20), 5)
@@ -386,7 +391,7 @@ func TestWithDrawChannel(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	c2, _ = GetChannel(c.ChannelID)
+	c2, _ = model.GetChannel(c.ChannelID)
 	assert.EqualValues(t, c2.Participants[0].Balance, big.NewInt(10).String())
 	assert.EqualValues(t, c2.Participants[0].LockedAmount, big.NewInt(0).String())
 	assert.EqualValues(t, c2.Participants[0].TransferedAmount, big.NewInt(0).String())
@@ -398,13 +403,13 @@ func TestWithDrawChannel(t *testing.T) {
 }
 
 func TestUpdateChannelFeeRate(t *testing.T) {
-	SetupTestDB()
-	c := testCreateChannel(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
 	channelIdentifier := common.HexToHash(c.ChannelID)
 	participant := common.HexToAddress(c.Participants[0].Participant)
 	token := common.HexToAddress(c.Token)
 
-	err := UpdateAccountDefaultFeePolicy(common.HexToAddress(c.Participants[0].Participant), &Fee{
+	err := model.UpdateAccountDefaultFeePolicy(common.HexToAddress(c.Participants[0].Participant), &Fee{
 		FeePolicy:   FeePolicyConstant,
 		FeeConstant: big.NewInt(30),
 	})
@@ -412,13 +417,13 @@ func TestUpdateChannelFeeRate(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	fee := GetChannelFeeRate(common.HexToHash(c.ChannelID), common.HexToAddress(c.Participants[0].Participant), token)
+	fee := model.GetChannelFeeRate(common.HexToHash(c.ChannelID), common.HexToAddress(c.Participants[0].Participant), token)
 
 	assert.EqualValues(t, fee.FeePolicy, FeePolicyConstant)
 	assert.EqualValues(t, fee.FeeConstant, big.NewInt(30))
 	assert.EqualValues(t, fee.FeePercent, 0)
 
-	err = UpdateAccountTokenFee(common.HexToAddress(c.Participants[0].Participant), common.HexToAddress(c.Token), &Fee{
+	err = model.UpdateAccountTokenFee(common.HexToAddress(c.Participants[0].Participant), common.HexToAddress(c.Token), &Fee{
 		FeePolicy:  FeePolicyPercent,
 		FeePercent: 500,
 	})
@@ -427,13 +432,13 @@ func TestUpdateChannelFeeRate(t *testing.T) {
 		return
 	}
 
-	fee = GetChannelFeeRate(common.HexToHash(c.ChannelID), common.HexToAddress(c.Participants[0].Participant), token)
+	fee = model.GetChannelFeeRate(common.HexToHash(c.ChannelID), common.HexToAddress(c.Participants[0].Participant), token)
 
 	assert.EqualValues(t, fee.FeePolicy, FeePolicyPercent)
 	assert.EqualValues(t, fee.FeeConstant, big.NewInt(0))
 	assert.EqualValues(t, fee.FeePercent, 500)
 
-	err = UpdateChannelFeeRate(channelIdentifier, participant, common.HexToAddress(c.Token), &Fee{
+	err = model.UpdateChannelFeeRate(channelIdentifier, participant, common.HexToAddress(c.Token), &Fee{
 		FeePolicy:   FeePolicyCombined,
 		FeeConstant: big.NewInt(30),
 		FeePercent:  50,
@@ -442,13 +447,13 @@ func TestUpdateChannelFeeRate(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	fee = GetChannelFeeRate(channelIdentifier, participant, token)
+	fee = model.GetChannelFeeRate(channelIdentifier, participant, token)
 
 	assert.EqualValues(t, fee.FeePolicy, FeePolicyCombined)
 	assert.EqualValues(t, fee.FeePercent, 50)
 	assert.EqualValues(t, fee.FeeConstant, big.NewInt(30))
 
-	err = UpdateChannelFeeRate(channelIdentifier, participant, common.HexToAddress(c.Token), &Fee{
+	err = model.UpdateChannelFeeRate(channelIdentifier, participant, common.HexToAddress(c.Token), &Fee{
 		FeePolicy:   FeePolicyCombined,
 		FeeConstant: big.NewInt(50),
 		FeePercent:  10,
@@ -458,20 +463,291 @@ func TestUpdateChannelFeeRate(t *testing.T) {
 		return
 	}
 
-	fee = GetChannelFeeRate(channelIdentifier, participant, token)
+	fee = model.GetChannelFeeRate(channelIdentifier, participant, token)
 	assert.EqualValues(t, fee.FeePolicy, FeePolicyCombined)
 	assert.EqualValues(t, fee.FeePercent, 10)
 	assert.EqualValues(t, fee.FeeConstant, big.NewInt(50))
 
-	err=DeleteAccountAllFeeRate(participant)
+	err=model.DeleteAccountAllFeeRate(participant)
 	if err!=nil{
 		t.Error(err)
 		return
 	}
 	//删除后应该是缺省的
-	fee = GetAccountFeePolicy(participant)
+	fee = model.GetAccountFeePolicy(participant)
 	if fee.FeePolicy != params.DefaultFeePolicy ||
 		fee.FeePercent != params.DefaultFeePercentPart {
 		t.Error("not equal default")
 	}
 }
+
+//code 错误码,不是BalanceProofError时为空
+func code(err error) string {
+	bpErr, ok := err.(*BalanceProofError)
+	if !ok {
+		return ""
+	}
+	return bpErr.Code
+}
+
+func TestUpdateChannelBalanceProofErrorCode(t *testing.T) {
+	ast := assert.New(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
+	participant := common.HexToAddress(c.Participants[0].Participant)
+	partner := common.HexToAddress(c.Participants[1].Participant)
+	bp := &BalanceProof{
+		ChannelID:       utils.NewRandomHash(),
+		OpenBlockNumber: c.OpenBlockNumber,
+		TransferAmount:  big.NewInt(10),
+		Nonce:           2,
+	}
+	_, err := model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), bp, false)
+	ast.EqualValues(ErrCodeChannelNotFound, code(err))
+	bp.ChannelID = common.HexToHash(c.ChannelID)
+	bp.OpenBlockNumber = c.OpenBlockNumber + 1
+	_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), bp, false)
+	ast.EqualValues(ErrCodeOpenBlockNumberMismatch, code(err))
+	bp.OpenBlockNumber = c.OpenBlockNumber
+	_, err = model.UpdateChannelBalanceProof(participant, utils.NewRandomAddress(), big.NewInt(0), bp, false)
+	ast.EqualValues(ErrCodeParticipantMismatch, code(err))
+	c2, err := model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), bp, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(big.NewInt(10), c2.Participants[1].TransferredAmountValue())
+	//partner没有存款,也没有收到过钱,不可能锁定任何金额
+	bp.Nonce = 5
+	_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(1), bp, false)
+	ast.EqualValues(ErrCodeLockedAmountExceeded, code(err))
+	if params.DebugMode {
+		return
+	}
+	bp.Nonce = 1
+	_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), bp, false)
+	ast.EqualValues(ErrCodeNonceRegression, code(err))
+	bp.Nonce = 3
+	bp.TransferAmount = big.NewInt(5)
+	_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(0), bp, false)
+	ast.EqualValues(ErrCodeTransferAmountDecrease, code(err))
+}
+
+//LockedAmount不能超过对方存款加上收到的再减去这次转出的
+func TestUpdateChannelBalanceProofLockedAmount(t *testing.T) {
+	ast := assert.New(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
+	channelIdentifier := common.HexToHash(c.ChannelID)
+	participant := common.HexToAddress(c.Participants[0].Participant)
+	partner := common.HexToAddress(c.Participants[1].Participant)
+	_, err := model.UpdateChannelDeposit(channelIdentifier, participant, big.NewInt(50))
+	ast.Nil(err)
+	_, err = model.UpdateChannelDeposit(channelIdentifier, partner, big.NewInt(100))
+	ast.Nil(err)
+	//participant给partner转了20
+	_, err = model.UpdateChannelBalanceProof(partner, participant, big.NewInt(0), &BalanceProof{
+		ChannelID:       channelIdentifier,
+		OpenBlockNumber: c.OpenBlockNumber,
+		TransferAmount:  big.NewInt(20),
+		Nonce:           1,
+	}, false)
+	ast.Nil(err)
+	//partner转出50以后只剩100+20-50=70
+	bp := &BalanceProof{
+		ChannelID:       channelIdentifier,
+		OpenBlockNumber: c.OpenBlockNumber,
+		TransferAmount:  big.NewInt(50),
+		Nonce:           1,
+	}
+	_, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(71), bp, false)
+	ast.EqualValues(ErrCodeLockedAmountExceeded, code(err))
+	c2, err := model.GetChannel(c.ChannelID)
+	ast.Nil(err)
+	ast.EqualValues(0, c2.Participants[1].TransferredAmountValue().Int64())
+	ast.EqualValues(big.NewInt(120).String(), c2.Participants[1].Balance)
+
+	c2, err = model.UpdateChannelBalanceProof(participant, partner, big.NewInt(70), bp, false)
+	ast.Nil(err)
+	ast.EqualValues(big.NewInt(0).String(), c2.Participants[1].Balance)
+	ast.EqualValues(big.NewInt(80).String(), c2.Participants[0].Balance)
+}
+
+//quickParticipant 用随机数构造通道一方的信息
+type quickParticipant struct {
+	Deposit, Transferred, Locked uint32
+}
+
+func (q quickParticipant) info() *ChannelParticipantInfo {
+	return &ChannelParticipantInfo{
+		Deposit:          bigIntToString(new(big.Int).SetUint64(uint64(q.Deposit))),
+		TransferedAmount: bigIntToString(new(big.Int).SetUint64(uint64(q.Transferred))),
+		LockedAmount:     bigIntToString(new(big.Int).SetUint64(uint64(q.Locked))),
+	}
+}
+
+//quickChannel 双方余额都不是负数的通道,双方的存款,转账金额和锁定金额都不为0
+type quickChannel struct {
+	P1, P2 quickParticipant
+}
+
+//Generate 实现quick.Generator
+func (quickChannel) Generate(r *rand.Rand, size int) reflect.Value {
+	var q quickChannel
+	q.P1.Deposit = 2 + uint32(r.Int31n(1<<30))
+	q.P2.Deposit = 2 + uint32(r.Int31n(1<<30))
+	//转出的比存款少,锁定的不超过可用的
+	q.P1.Transferred = 1 + uint32(r.Int63n(int64(q.P1.Deposit-1)))
+	q.P2.Transferred = 1 + uint32(r.Int63n(int64(q.P2.Deposit-1)))
+	q.P1.Locked = 1 + uint32(r.Int63n(int64(q.P1.Deposit)+int64(q.P2.Transferred)-int64(q.P1.Transferred)))
+	q.P2.Locked = 1 + uint32(r.Int63n(int64(q.P2.Deposit)+int64(q.P1.Transferred)-int64(q.P2.Transferred)))
+	return reflect.ValueOf(q)
+}
+
+func TestComputeBalances(t *testing.T) {
+	//余额不能为负数,并且不能修改参数
+	nonNegative := func(q1, q2 quickParticipant) bool {
+		p1, p2 := q1.info(), q2.info()
+		b1, b2 := ComputeBalances(p1, p2)
+		return b1.Sign() >= 0 && b2.Sign() >= 0 && *p1 == *q1.info() && *p2 == *q2.info()
+	}
+	//没有负数的时候,双方余额加上锁定的钱等于双方存款之和
+	conservation := func(q quickChannel) bool {
+		p1, p2 := q.P1.info(), q.P2.info()
+		b1, b2 := ComputeBalances(p1, p2)
+		if b1.Cmp(balanceOf(p1, p2)) != 0 || b2.Cmp(balanceOf(p2, p1)) != 0 {
+			return false
+		}
+		total := new(big.Int).Add(b1, b2)
+		total.Add(total, p1.LockedAmountValue()).Add(total, p2.LockedAmountValue())
+		return total.Cmp(new(big.Int).Add(p1.DepositValue(), p2.DepositValue())) == 0
+	}
+	//p1转出的越多,p1余额越少,p2余额越多
+	monotonic := func(q1, q2 quickParticipant, delta uint32) bool {
+		b1, b2 := ComputeBalances(q1.info(), q2.info())
+		more := q1.info()
+		more.TransferedAmount = bigIntToString(new(big.Int).Add(more.TransferredAmountValue(), new(big.Int).SetUint64(uint64(delta))))
+		b1More, b2More := ComputeBalances(more, q2.info())
+		return b1More.Cmp(b1) <= 0 && b2More.Cmp(b2) >= 0
+	}
+	for name, f := range map[string]interface{}{
+		"non-negative": nonNegative,
+		"conservation": conservation,
+		"monotonic":    monotonic,
+	} {
+		if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+			t.Errorf("%s %s", name, err)
+		}
+	}
+}
+
+//保存通道第二方失败时,第一方的修改也不能提交
+func TestUpdateBalanceRollback(t *testing.T) {
+	ast := assert.New(t)
+	model := SetupTestDB()
+	c := testCreateChannel(t, model)
+	channelIdentifier := common.HexToHash(c.ChannelID)
+	_, err := model.UpdateChannelDeposit(channelIdentifier, common.HexToAddress(c.Participants[0].Participant), big.NewInt(20))
+	ast.Nil(err)
+	_, err = model.UpdateChannelDeposit(channelIdentifier, common.HexToAddress(c.Participants[1].Participant), big.NewInt(30))
+	ast.Nil(err)
+	saves := 0
+	model.db.Callback().Update().Before("gorm:update").Register("test:fail_second_save", func(scope *gorm.Scope) {
+		if _, ok := scope.Value.(*ChannelParticipantInfo); !ok {
+			return
+		}
+		saves++
+		if saves == 2 {
+			scope.Err(errors.New("injected failure"))
+		}
+	})
+	defer model.db.Callback().Update().Remove("test:fail_second_save")
+
+	c, err = model.GetChannel(c.ChannelID)
+	ast.Nil(err)
+	p1, p2 := c.Participants[0], c.Participants[1]
+	p1.TransferedAmount = bigIntToString(big.NewInt(5))
+	p2.LockedAmount = bigIntToString(big.NewInt(3))
+	ast.NotNil(model.updateBalance(p1, p2))
+	ast.EqualValues(2, saves)
+	c2, err := model.GetChannel(c.ChannelID)
+	ast.Nil(err)
+	ast.EqualValues(big.NewInt(20).String(), c2.Participants[0].Balance)
+	ast.EqualValues(big.NewInt(30).String(), c2.Participants[1].Balance)
+	ast.EqualValues(0, c2.Participants[0].TransferredAmountValue().Int64())
+	ast.EqualValues(0, c2.Participants[1].LockedAmountValue().Int64())
+
+	//在外层事务中失败,整个事务回滚
+	saves = 0
+	err = model.Transaction(func(tx *ModelDB) error {
+		_, err := tx.UpdateChannelDeposit(channelIdentifier, common.HexToAddress(p1.Participant), big.NewInt(50))
+		return err
+	})
+	ast.NotNil(err)
+	c2, err = model.GetChannel(c.ChannelID)
+	ast.Nil(err)
+	ast.EqualValues(big.NewInt(20).String(), c2.Participants[0].Balance)
+	ast.EqualValues(big.NewInt(20).String(), c2.Participants[0].Deposit)
+	ast.EqualValues(big.NewInt(30).String(), c2.Participants[1].Balance)
+
+	//在外层事务中保存第二方失败,只回滚这一次更新,外层事务提交的其他修改不包含第一方的修改
+	saves = 0
+	err = model.Transaction(func(tx *ModelDB) error {
+		ast.NotNil(tx.updateBalance(p1, p2))
+		_, err := tx.UpdateChannelDeposit(channelIdentifier, common.HexToAddress(p1.Participant), big.NewInt(25))
+		return err
+	})
+	ast.Nil(err)
+	c2, err = model.GetChannel(c.ChannelID)
+	ast.Nil(err)
+	ast.EqualValues(big.NewInt(25).String(), c2.Participants[0].Deposit)
+	ast.EqualValues(0, c2.Participants[0].TransferredAmountValue().Int64())
+	ast.EqualValues(0, c2.Participants[1].LockedAmountValue().Int64())
+}
+
+func TestGetChannelsPage(t *testing.T) {
+	ast := assert.New(t)
+	//子查询也必须使用表前缀
+	model := SetupTestDB().WithTablePrefix("chain1_")
+	token := utils.NewRandomAddress()
+	p := utils.NewRandomAddress()
+	for i := 0; i < 5; i++ {
+		_, err := model.AddChannel(token, p, utils.NewRandomAddress(), utils.NewRandomHash(), 3)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err := model.AddChannel(utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomHash(), 3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cs, total, err := model.GetTokenChannelsPage(token, 0, 3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(5, total)
+	ast.EqualValues(3, len(cs))
+	cs2, _, err := model.GetTokenChannelsPage(token, 3, 3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(2, len(cs2))
+	ast.True(cs[2].ChannelID < cs2[0].ChannelID)
+
+	cs, total, err = model.GetParticipantChannelsPage(p, 4, 10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(5, total)
+	if ast.EqualValues(1, len(cs)) {
+		ast.EqualValues(2, len(cs[0].Participants))
+	}
+	_, total, err = model.GetParticipantChannelsPage(utils.NewRandomAddress(), 0, 10)
+	ast.Nil(err)
+	ast.EqualValues(0, total)
+}
@@ -9,8 +9,6 @@ import (
 
 	"github.com/SmartMeshFoundation/Photon/log"
 
-	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
-
 	"os"
 
 	"github.com/jinzhu/gorm"
@@ -18,12 +16,29 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/sqlite"   //for gorm
 )
 
-var db *gorm.DB
+//ModelDB 一个网络(registry合约)对应的存储,
+//多个网络可以共用一个数据库连接,通过表名前缀区分
+type ModelDB struct {
+	db *gorm.DB
+	lb *latestBlockNumber
+	//txDepth Transaction创建的ModelDB的嵌套层数,0表示不在事务中,嵌套的事务用SAVEPOINT实现
+	txDepth int
+}
+
+const tablePrefixKey = "pfs:table_prefix"
+
+func init() {
+	gorm.DefaultTableNameHandler = func(db *gorm.DB, defaultTableName string) string {
+		if prefix, ok := db.Get(tablePrefixKey); ok {
+			return prefix.(string) + defaultTableName
+		}
+		return defaultTableName
+	}
+}
 
 //SetUpDB init db
-func SetUpDB(dbtype, path string) {
-	var err error
-	db, err = gorm.Open(dbtype, path)
+func SetUpDB(dbtype, path string) *ModelDB {
+	db, err := gorm.Open(dbtype, path)
 	if err != nil {
 		panic("failed to connect database")
 	}
@@ -38,6 +53,16 @@ func SetUpDB(dbtype, path string) {
 	//}
 	//db.SetLogger(gorm.Logger{revel.TRACE})
 	db.SetLogger(log2.New(os.Stdout, "\r\n", 0))
+	return newModelDB(db)
+}
+
+//WithTablePrefix 在同一个数据库连接上为另一个网络建立存储,该网络的所有表名都加上prefix
+func (model *ModelDB) WithTablePrefix(prefix string) *ModelDB {
+	return newModelDB(model.db.Set(tablePrefixKey, prefix))
+}
+
+func newModelDB(db *gorm.DB) *ModelDB {
+	var err error
 	db.AutoMigrate(&Channel{})
 	if err = db.AutoMigrate(&ChannelParticipantInfo{}).Error; err != nil {
 		panic(err)
@@ -50,26 +75,86 @@ func SetUpDB(dbtype, path string) {
 	db.AutoMigrate(&xmpp{})
 	db.AutoMigrate(&observerKey{})
 	db.AutoMigrate(&ChannelParticipantFee{})
-	db.FirstOrCreate(lb)
-	params.ObserverKey = GetObserverKey
-	return
+	db.AutoMigrate(&ChannelPenalty{})
+	model := &ModelDB{
+		db: db,
+		lb: &latestBlockNumber{ID: 1},
+	}
+	db.FirstOrCreate(model.lb)
+	return model
+}
+
+/*
+Transaction 在一个数据库事务中执行fn,fn的参数tx是只在这个事务中使用的ModelDB,
+通过tx调用的所有model函数都使用这个事务,fn返回错误则整体回滚.
+model本身不受影响,其他goroutine通过model的读写不会加入这个事务.
+在tx上再次调用Transaction时使用SAVEPOINT,fn返回错误只回滚内层的修改,外层事务可以继续.
+*/
+func (model *ModelDB) Transaction(fn func(tx *ModelDB) error) (err error) {
+	tx := model.begin()
+	if err = tx.Error; err != nil {
+		return
+	}
+	err = fn(&ModelDB{
+		db:      tx.DB,
+		lb:      &latestBlockNumber{ID: model.lb.ID},
+		txDepth: model.txDepth + 1,
+	})
+	if err != nil {
+		tx.rollback()
+		return
+	}
+	return tx.commit()
+}
+
+//dbTx 如果已经处于Transaction中,在外层事务中建立SAVEPOINT,回滚只撤销SAVEPOINT以后的修改
+type dbTx struct {
+	*gorm.DB
+	savepoint string
+}
+
+func (model *ModelDB) begin() *dbTx {
+	if model.txDepth > 0 {
+		//每层只会有一个未释放的SAVEPOINT,用层数命名不会和外层冲突
+		savepoint := fmt.Sprintf("pfs_sp_%d", model.txDepth)
+		return &dbTx{model.db.Exec("SAVEPOINT " + savepoint), savepoint}
+	}
+	return &dbTx{model.db.Begin(), ""}
+}
+
+func (tx *dbTx) commit() error {
+	if tx.savepoint != "" {
+		return tx.DB.Exec("RELEASE SAVEPOINT " + tx.savepoint).Error
+	}
+	return tx.DB.Commit().Error
+}
+
+func (tx *dbTx) rollback() {
+	if tx.savepoint != "" {
+		err := tx.DB.Exec("ROLLBACK TO SAVEPOINT " + tx.savepoint).Exec("RELEASE SAVEPOINT " + tx.savepoint).Error
+		if err != nil {
+			log.Error(fmt.Sprintf("rollback to savepoint %s err %s", tx.savepoint, err))
+		}
+		return
+	}
+	tx.DB.Rollback()
 }
 
-//CloseDB release connection
-func CloseDB() {
-	err := db.Close()
+//CloseDB release connection, 共用连接的其他网络也会关闭
+func (model *ModelDB) CloseDB() {
+	err := model.db.Close()
 	if err != nil {
 		log.Error(fmt.Sprintf("closedb err %s", err))
 	}
 }
 
 //SetupTestDB for test only
-func SetupTestDB() {
+func SetupTestDB() *ModelDB {
 	dbPath := path.Join(os.TempDir(), fmt.Sprintf("test%s.db", utils.RandomString(10)))
-	log.Trace(fmt.Sprintf(dbPath))
+	log.Trace(dbPath)
 	err := os.Remove(dbPath)
 	if err != nil {
 		log.Error(fmt.Sprintf("remove err %s",err))
 	}
-	SetUpDB("sqlite3", dbPath)
+	return SetUpDB("sqlite3", dbPath)
 }
@@ -43,18 +43,18 @@ type Fee struct {
 }
 
 //UpdateAccountDefaultFeePolicy 设置某个账户的缺省收费,新创建的通道都会按照此缺省设置进行
-func UpdateAccountDefaultFeePolicy(account common.Address, fee *Fee) error {
+func (model *ModelDB) UpdateAccountDefaultFeePolicy(account common.Address, fee *Fee) error {
 	a := &AccountFee{
 		Account:         account.String(),
 		FeePolicy:       fee.FeePolicy,
 		FeeConstantPart: bigIntToString(fee.FeeConstant),
 		FeePercentPart:  fee.FeePercent,
 	}
-	err := db.Where(&AccountFee{Account: account.String()}).Find(&AccountFee{}).Error
+	err := model.db.Where(&AccountFee{Account: account.String()}).Find(&AccountFee{}).Error
 	if err == nil {
-		return db.Save(a).Error
+		return model.db.Save(a).Error
 	}
-	return db.Create(a).Error
+	return model.db.Create(a).Error
 }
 
 var defaultFee = &Fee{
@@ -64,9 +64,9 @@ var defaultFee = &Fee{
 }
 
 //GetAccountFeePolicy 获取某个账户的缺省收费,新创建的通道都会按照此缺省设置进行
-func GetAccountFeePolicy(account common.Address) (fee *Fee) {
+func (model *ModelDB) GetAccountFeePolicy(account common.Address) (fee *Fee) {
 	a := &AccountFee{}
-	err := db.Where(&AccountFee{Account: account.String()}).Find(a).Error
+	err := model.db.Where(&AccountFee{Account: account.String()}).Find(a).Error
 	if err == nil {
 		return &Fee{
 			FeePolicy:   a.FeePolicy,
@@ -80,12 +80,12 @@ func GetAccountFeePolicy(account common.Address) (fee *Fee) {
 }
 
 // GetAccountTokenFee 获取账户针对某个token的缺省收费设置
-func GetAccountTokenFee(account, token common.Address) (fee *Fee, err error) {
+func (model *ModelDB) GetAccountTokenFee(account, token common.Address) (fee *Fee, err error) {
 	atf := &AccountTokenFee{
 		Token:   token.String(),
 		Account: account.String(),
 	}
-	err = db.Where(atf).Find(atf).Error
+	err = model.db.Where(atf).Find(atf).Error
 	if err == nil {
 		fee = &Fee{
 			FeePolicy:   atf.FeePolicy,
@@ -97,25 +97,25 @@ func GetAccountTokenFee(account, token common.Address) (fee *Fee, err error) {
 }
 
 //UpdateAccountTokenFee 更新用户针对某个token的缺省收费设置
-func UpdateAccountTokenFee(account, token common.Address, fee *Fee) (err error) {
+func (model *ModelDB) UpdateAccountTokenFee(account, token common.Address, fee *Fee) (err error) {
 	atf := &AccountTokenFee{
 		Token:   token.String(),
 		Account: account.String(),
 	}
-	err = db.Where(atf).Find(atf).Error
+	err = model.db.Where(atf).Find(atf).Error
 	atf.FeePolicy = fee.FeePolicy
 	atf.FeeConstantPart = bigIntToString(fee.FeeConstant)
 	atf.FeePercentPart = fee.FeePercent
 	if err == nil {
-		return db.Save(atf).Error
+		return model.db.Save(atf).Error
 	}
-	return db.Create(atf).Error
+	return model.db.Create(atf).Error
 }
 //DeleteAccountAllFeeRate 删除账户所有收费记录
-func DeleteAccountAllFeeRate(account common.Address) (err error){
-	tx:=db.Begin()
+func (model *ModelDB) DeleteAccountAllFeeRate(account common.Address) (err error){
+	tx:=model.begin()
 	if err!=nil{
-		tx.Rollback()
+		tx.rollback()
 	}
 	tx2:=tx.Where("account=?",account.String()).Delete(&AccountFee{})
 	if tx2.Error!=nil{
@@ -132,5 +132,5 @@ func DeleteAccountAllFeeRate(account common.Address) (err error){
 		err=tx2.Error
 		return
 	}
-	return tx.Commit().Error
+	return tx.commit()
 }
\ No newline at end of file
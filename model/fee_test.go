@@ -13,9 +13,9 @@ import (
 )
 
 func TestGetAccountFeePolicy(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	a := utils.NewRandomAddress()
-	fee := GetAccountFeePolicy(a)
+	fee := model.GetAccountFeePolicy(a)
 	if fee.FeePolicy != params.DefaultFeePolicy ||
 		fee.FeePercent != params.DefaultFeePercentPart {
 		t.Error("not equal default")
@@ -23,29 +23,29 @@ func TestGetAccountFeePolicy(t *testing.T) {
 	fee.FeePolicy = FeePolicyConstant
 	fee.FeePercent = 0
 	fee.FeeConstant = big.NewInt(30)
-	err := UpdateAccountDefaultFeePolicy(a, fee)
+	err := model.UpdateAccountDefaultFeePolicy(a, fee)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	fee.FeePercent = 30
-	err = UpdateAccountDefaultFeePolicy(a, fee)
+	err = model.UpdateAccountDefaultFeePolicy(a, fee)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	fee2 := GetAccountFeePolicy(a)
+	fee2 := model.GetAccountFeePolicy(a)
 	if !reflect.DeepEqual(fee2, fee) {
 		t.Error("not equal")
 		return
 	}
-	err=DeleteAccountAllFeeRate(a)
+	err=model.DeleteAccountAllFeeRate(a)
 	if err!=nil{
 		t.Error(err)
 		return
 	}
 	//删除后应该是缺省的
-	fee = GetAccountFeePolicy(a)
+	fee = model.GetAccountFeePolicy(a)
 	if fee.FeePolicy != params.DefaultFeePolicy ||
 		fee.FeePercent != params.DefaultFeePercentPart {
 		t.Error("not equal default")
@@ -53,10 +53,10 @@ func TestGetAccountFeePolicy(t *testing.T) {
 }
 
 func TestGetAccountTokenFee(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	a := utils.NewRandomAddress()
 	token := utils.NewRandomAddress()
-	fee, err := GetAccountTokenFee(a, token)
+	fee, err := model.GetAccountTokenFee(a, token)
 	if err == nil {
 		t.Error("should not found")
 		return
@@ -65,12 +65,12 @@ func TestGetAccountTokenFee(t *testing.T) {
 	fee.FeePolicy = FeePolicyConstant
 	fee.FeePercent = 0
 	fee.FeeConstant = big.NewInt(30)
-	err = UpdateAccountTokenFee(a, token, fee)
+	err = model.UpdateAccountTokenFee(a, token, fee)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	fee2, err := GetAccountTokenFee(a, token)
+	fee2, err := model.GetAccountTokenFee(a, token)
 	if err != nil {
 		t.Error(err)
 		return
@@ -79,18 +79,18 @@ func TestGetAccountTokenFee(t *testing.T) {
 		t.Error("not equal")
 		return
 	}
-	err = UpdateAccountTokenFee(a, token, fee)
+	err = model.UpdateAccountTokenFee(a, token, fee)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	err=DeleteAccountAllFeeRate(a)
+	err=model.DeleteAccountAllFeeRate(a)
 	if err!=nil{
 		t.Error(err)
 		return
 	}
 	//删除后应该是缺省的
-	fee,err = GetAccountTokenFee(a,token)
+	fee,err = model.GetAccountTokenFee(a,token)
 	if err==nil{
 		t.Error("must not found")
 		return
@@ -98,10 +98,10 @@ func TestGetAccountTokenFee(t *testing.T) {
 }
 
 func TestGetAccountTokenFeeSqlite(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	a := utils.NewRandomAddress()
 	token := utils.NewRandomAddress()
-	fee, err := GetAccountTokenFee(a, token)
+	fee, err := model.GetAccountTokenFee(a, token)
 	if err == nil {
 		t.Error("should not found")
 		return
@@ -117,7 +117,7 @@ func TestGetAccountTokenFeeSqlite(t *testing.T) {
 	start:=time.Now()
 	for i:=0;i<n;i++{
 		go func(){
-			err = UpdateAccountTokenFee(a, token, fee)
+			err = model.UpdateAccountTokenFee(a, token, fee)
 			if err != nil {
 				panic(err)
 			}
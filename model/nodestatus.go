@@ -1,64 +1,83 @@
 package model
 
 import (
-	"fmt"
+	"time"
 
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/ethereum/go-ethereum/common"
 )
 
-//NodeStatus photon account status
+//节点状态中各个字段的来源
+const (
+	NodeSourcePresence     = "presence"      //上下线发现服务
+	NodeSourceBalanceProof = "balance_proof" //节点提交balance proof时带上的参数
+	NodeSourceHeartbeat    = "heartbeat"     //节点通过http发送的心跳
+)
+
+/*
+NodeStatus photon account status,
+每个字段独立更新,并记录是谁在什么时候更新的,更新一个字段不会影响其他字段
+*/
 type NodeStatus struct {
-	Address    string `gorm:"primary_key"`
-	DeviceType string
-	IsOnline   bool
+	Address                         string `gorm:"primary_key"`
+	DeviceType                      string
+	DeviceTypeSource                string
+	DeviceTypeUpdatedAt             time.Time
+	IsOnline                        bool
+	OnlineSource                    string
+	OnlineUpdatedAt                 time.Time
+	IgnoreMediatedTransfer          bool
+	IgnoreMediatedTransferSource    string
+	IgnoreMediatedTransferUpdatedAt time.Time
 }
 
 //GetAllNodes get all matrix account
-func GetAllNodes() []*NodeStatus {
+func (model *ModelDB) GetAllNodes() []*NodeStatus {
 	var nodes []*NodeStatus
 
-	if err := db.Limit(1000000).Find(&nodes).Error; err != nil {
+	if err := model.db.Limit(1000000).Find(&nodes).Error; err != nil {
 		log.Crit(err.Error())
 	}
 	return nodes
 }
 
-//NewOrUpdateNodeOnline  update node's status
-func NewOrUpdateNodeOnline(address common.Address, isOnline bool) {
-	var node = &NodeStatus{}
-	node.Address = address.String()
-	if err := db.Where(node).Find(node).Error; err != nil {
-		err = db.Create(node).Error
-		if err != nil {
-			log.Error(fmt.Sprintf("NewOrUpdateNodeOnline but cannot found node %s", address.String()))
-		}
-	}
-	node.IsOnline = isOnline
-	err := db.Model(node).UpdateColumn("IsOnline", isOnline).Error
+//GetNode returns status of address
+func (model *ModelDB) GetNode(address common.Address) (node *NodeStatus, err error) {
+	node = &NodeStatus{}
+	err = model.db.Where(&NodeStatus{Address: address.String()}).Find(node).Error
+	return
+}
+
+//updateNodeColumns 更新节点的部分字段,节点不存在时创建
+func (model *ModelDB) updateNodeColumns(address common.Address, columns map[string]interface{}) error {
+	node := &NodeStatus{}
+	err := model.db.Where(&NodeStatus{Address: address.String()}).FirstOrCreate(node).Error
 	if err != nil {
-		log.Error(fmt.Sprintf("update online err %s", err))
+		return err
 	}
+	return model.db.Model(node).UpdateColumns(columns).Error
 }
 
-//NewOrUpdateNodeStatus update node's online info
-func NewOrUpdateNodeStatus(address common.Address, isOnline bool, deviceType string) {
-	var node = &NodeStatus{}
-	node.Address = address.String()
-	if err := db.Where(node).Find(node).Error; err != nil {
-		//log.Error(fmt.Sprintf("NewOrUpdateNodeStatus but cannot found node %s", address.String()))
-		//return
-		err = db.Create(node).Error
-		if err != nil {
-			log.Error("create error %s", err)
-		}
+//UpdateNodeOnline 更新节点的在线状态,deviceType为空时不更新设备类型
+func (model *ModelDB) UpdateNodeOnline(address common.Address, isOnline bool, deviceType, source string, now time.Time) error {
+	columns := map[string]interface{}{
+		"is_online":         isOnline,
+		"online_source":     source,
+		"online_updated_at": now,
 	}
-	node.IsOnline = isOnline
-	err := db.Model(node).UpdateColumns(&NodeStatus{
-		IsOnline:   isOnline,
-		DeviceType: deviceType,
-	}).Error
-	if err != nil {
-		log.Error(fmt.Sprintf("update online err %s", err))
+	if len(deviceType) > 0 {
+		columns["device_type"] = deviceType
+		columns["device_type_source"] = source
+		columns["device_type_updated_at"] = now
 	}
+	return model.updateNodeColumns(address, columns)
+}
+
+//UpdateNodeIgnoreMediatedTransfer 更新节点是否拒绝作为中间节点
+func (model *ModelDB) UpdateNodeIgnoreMediatedTransfer(address common.Address, ignore bool, source string, now time.Time) error {
+	return model.updateNodeColumns(address, map[string]interface{}{
+		"ignore_mediated_transfer":            ignore,
+		"ignore_mediated_transfer_source":     source,
+		"ignore_mediated_transfer_updated_at": now,
+	})
 }
@@ -2,6 +2,7 @@ package model
 
 import (
 	"testing"
+	"time"
 
 	"github.com/SmartMeshFoundation/Photon/utils"
 
@@ -9,19 +10,42 @@ import (
 )
 
 func TestGetAllNodes(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	//key, _ := utils.MakePrivateKeyAddress()
 	//t.Logf(hex.EncodeToString(crypto.FromECDSA(key)))
-	nodes := GetAllNodes()
+	nodes := model.GetAllNodes()
 	assert.EqualValues(t, len(nodes), 0)
-	NewOrUpdateNodeStatus(utils.NewRandomAddress(), true, "mobile")
-	nodes = GetAllNodes()
+	assert.Nil(t, model.UpdateNodeOnline(utils.NewRandomAddress(), true, "mobile", NodeSourcePresence, time.Now()))
+	nodes = model.GetAllNodes()
 	assert.EqualValues(t, len(nodes), 1)
 	addr := utils.NewRandomAddress()
-	NewOrUpdateNodeStatus(addr, true, "mobile")
-	assert.EqualValues(t, len(GetAllNodes()), 2)
-	NewOrUpdateNodeOnline(addr, false)
-	nodes = GetAllNodes()
+	assert.Nil(t, model.UpdateNodeOnline(addr, true, "mobile", NodeSourcePresence, time.Now()))
+	assert.EqualValues(t, len(model.GetAllNodes()), 2)
+	assert.Nil(t, model.UpdateNodeOnline(addr, false, "", NodeSourcePresence, time.Now()))
+	nodes = model.GetAllNodes()
 	assert.EqualValues(t, len(nodes), 2)
 	t.Logf("nodes=%s", utils.StringInterface(nodes, 3))
 }
+
+func TestUpdateNodeFields(t *testing.T) {
+	ast := assert.New(t)
+	model := SetupTestDB()
+	addr := utils.NewRandomAddress()
+	now := time.Now()
+	ast.Nil(model.UpdateNodeIgnoreMediatedTransfer(addr, true, NodeSourceBalanceProof, now))
+	ast.Nil(model.UpdateNodeOnline(addr, true, "mobile", NodeSourcePresence, now.Add(time.Second)))
+	ast.Nil(model.UpdateNodeOnline(addr, false, "", NodeSourcePresence, now.Add(2*time.Second)))
+	n, err := model.GetNode(addr)
+	ast.Nil(err)
+	ast.False(n.IsOnline)
+	ast.Equal("mobile", n.DeviceType)
+	ast.True(n.IgnoreMediatedTransfer)
+	ast.Equal(NodeSourceBalanceProof, n.IgnoreMediatedTransferSource)
+	ast.Equal(now.Unix(), n.IgnoreMediatedTransferUpdatedAt.Unix())
+	ast.Equal(now.Add(2*time.Second).Unix(), n.OnlineUpdatedAt.Unix())
+	ast.Equal(now.Add(time.Second).Unix(), n.DeviceTypeUpdatedAt.Unix())
+	ast.Nil(model.UpdateNodeIgnoreMediatedTransfer(addr, false, NodeSourceHeartbeat, now))
+	n, _ = model.GetNode(addr)
+	ast.False(n.IgnoreMediatedTransfer)
+	ast.Equal("mobile", n.DeviceType)
+}
@@ -12,11 +12,11 @@ type observerKey struct {
 }
 
 //GetObserverKey get or create a key from db
-func GetObserverKey() *ecdsa.PrivateKey {
+func (model *ModelDB) GetObserverKey() *ecdsa.PrivateKey {
 	x := &observerKey{
 		ID: 1,
 	}
-	err := db.Where(x).Find(x).Error
+	err := model.db.Where(x).Find(x).Error
 	if err != nil {
 		//第一次启动
 		key, err := crypto.GenerateKey()
@@ -24,7 +24,7 @@ func GetObserverKey() *ecdsa.PrivateKey {
 			panic(err)
 		}
 		x.Key = crypto.FromECDSA(key)
-		err = db.Create(x).Error
+		err = model.db.Create(x).Error
 		if err != nil {
 			panic(err)
 		}
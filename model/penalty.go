@@ -0,0 +1,61 @@
+package model
+
+import (
+	"math"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+)
+
+//ChannelPenalty 节点报告通道某个方向转账失败以后的惩罚,惩罚随时间衰减
+type ChannelPenalty struct {
+	ChannelID   string `gorm:"primary_key"`
+	Participant string `gorm:"primary_key"` //从Participant转给通道另一方的方向
+	Token       string
+	Penalty     float64   //PenalizedAt时的惩罚
+	PenalizedAt time.Time //最近一次受到惩罚的时间
+	Reason      string    //最近一次失败的原因
+	Reporter    string    //最近一次报告失败的节点
+	Reports     int       //一共被报告失败了多少次
+}
+
+//Value now时的惩罚,每过PenaltyHalfLife减半
+func (p *ChannelPenalty) Value(now time.Time) float64 {
+	if !now.After(p.PenalizedAt) {
+		return p.Penalty
+	}
+	return p.Penalty * math.Pow(0.5, float64(now.Sub(p.PenalizedAt))/float64(params.PenaltyHalfLife))
+}
+
+//AddChannelPenalty 在通道从participant出发的方向上增加weight惩罚,原有的惩罚衰减以后累加
+func (model *ModelDB) AddChannelPenalty(channelID common.Hash, participant, token, reporter common.Address, reason string, weight float64, now time.Time) (p *ChannelPenalty, err error) {
+	p = &ChannelPenalty{}
+	err = model.db.Where(&ChannelPenalty{
+		ChannelID:   channelID.String(),
+		Participant: participant.String(),
+	}).Find(p).Error
+	if gorm.IsRecordNotFoundError(err) {
+		p = &ChannelPenalty{
+			ChannelID:   channelID.String(),
+			Participant: participant.String(),
+		}
+	} else if err != nil {
+		return
+	}
+	p.Penalty = p.Value(now) + weight
+	p.PenalizedAt = now
+	p.Token = token.String()
+	p.Reason = reason
+	p.Reporter = reporter.String()
+	p.Reports++
+	err = model.db.Save(p).Error
+	return
+}
+
+//GetChannelPenalties 所有的惩罚记录,包括已经衰减到可以忽略的
+func (model *ModelDB) GetChannelPenalties() (ps []*ChannelPenalty, err error) {
+	err = model.db.Order("penalized_at desc").Find(&ps).Error
+	return
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddChannelPenalty(t *testing.T) {
+	ast := assert.New(t)
+	model := SetupTestDB()
+	channelID := utils.NewRandomHash()
+	p1, p2, token, reporter := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	now := time.Now()
+	p, err := model.AddChannelPenalty(channelID, p1, token, reporter, "timeout", 1, now)
+	ast.Nil(err)
+	ast.EqualValues(1, p.Reports)
+	ast.InDelta(1, p.Value(now), 1e-9)
+	ast.InDelta(0.5, p.Value(now.Add(params.PenaltyHalfLife)), 1e-9)
+	//衰减以后再累加
+	p, err = model.AddChannelPenalty(channelID, p1, token, reporter, "refused", 1, now.Add(params.PenaltyHalfLife))
+	ast.Nil(err)
+	ast.EqualValues(2, p.Reports)
+	ast.InDelta(1.5, p.Penalty, 1e-9)
+	ast.EqualValues("refused", p.Reason)
+	_, err = model.AddChannelPenalty(channelID, p2, token, reporter, "timeout", 1, now)
+	ast.Nil(err)
+
+	ps, err := model.GetChannelPenalties()
+	ast.Nil(err)
+	ast.EqualValues(2, len(ps))
+	ast.EqualValues(p1.String(), ps[0].Participant)
+	ast.InDelta(1.5, ps[0].Penalty, 1e-9)
+	ast.EqualValues(token.String(), ps[0].Token)
+	ast.EqualValues(reporter.String(), ps[0].Reporter)
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//SnapshotVersion 快照格式版本,格式发生不兼容变化时必须递增
+const SnapshotVersion = 1
+
+/*
+Snapshot 通道图快照
+新启动的pfs可以直接从快照恢复token network,通道,押金以及收费信息,
+然后从BlockNumber继续处理链上事件,而不必从0块开始重放所有事件.
+*/
+type Snapshot struct {
+	Version          int                      `json:"version"`
+	ChainID          int64                    `json:"chain_id"`
+	RegistryAddress  common.Address           `json:"registry_address"`
+	BlockNumber      int64                    `json:"block_number"` //快照对应的已处理块数
+	TokenNetworks    []*tokenNetwork          `json:"token_networks"`
+	Channels         []*Channel               `json:"channels"` //包含participant信息
+	ChannelFees      []*ChannelParticipantFee `json:"channel_fees"`
+	AccountFees      []*AccountFee            `json:"account_fees"`
+	AccountTokenFees []*AccountTokenFee       `json:"account_token_fees"`
+	Signer           common.Address           `json:"signer"`
+	Signature        []byte                   `json:"signature"`
+}
+
+var (
+	//ErrSnapshotDBNotEmpty 只能向空数据库导入快照
+	ErrSnapshotDBNotEmpty = errors.New("database is not empty, snapshot can only be imported into a new database")
+	//ErrSnapshotSignature 快照签名不正确
+	ErrSnapshotSignature = errors.New("snapshot signature invalid")
+)
+
+//ExportSnapshot 导出当前数据库中的通道图,导出在一个事务中完成,保证数据与BlockNumber一致
+func (model *ModelDB) ExportSnapshot(chainID int64, registryAddress common.Address) (s *Snapshot, err error) {
+	s = &Snapshot{
+		Version:         SnapshotVersion,
+		ChainID:         chainID,
+		RegistryAddress: registryAddress,
+	}
+	tx := model.db.Begin()
+	defer tx.Rollback() //只读事务,不需要提交
+	l := &latestBlockNumber{}
+	if err = tx.First(l).Error; err != nil {
+		return
+	}
+	s.BlockNumber = l.BlockNumber
+	if err = tx.Find(&s.TokenNetworks).Error; err != nil {
+		return
+	}
+	if err = tx.Preload("Participants").Find(&s.Channels).Error; err != nil {
+		return
+	}
+	for _, c := range s.Channels {
+		if len(c.Participants) != 2 {
+			err = fmt.Errorf("channel %s participants number error %d", c.ChannelID, len(c.Participants))
+			return
+		}
+		c.Participants[0], c.Participants[1] = orderParticipants(c.Participants[0], c.Participants[1])
+	}
+	if err = tx.Find(&s.ChannelFees).Error; err != nil {
+		return
+	}
+	if err = tx.Find(&s.AccountFees).Error; err != nil {
+		return
+	}
+	err = tx.Find(&s.AccountTokenFees).Error
+	return
+}
+
+//signData 快照中需要签名的内容,不包含签名本身
+func (s *Snapshot) signData() ([]byte, error) {
+	s2 := *s
+	s2.Signer = utils.EmptyAddress
+	s2.Signature = nil
+	return json.Marshal(&s2)
+}
+
+//Sign 导出方对快照签名
+func (s *Snapshot) Sign(key *ecdsa.PrivateKey) (err error) {
+	data, err := s.signData()
+	if err != nil {
+		return
+	}
+	s.Signature, err = utils.SignData(key, data)
+	if err != nil {
+		return
+	}
+	s.Signer = crypto.PubkeyToAddress(key.PublicKey)
+	return
+}
+
+//VerifySignature 验证快照没有被篡改,并且确实是由Signer签名
+func (s *Snapshot) VerifySignature() (err error) {
+	data, err := s.signData()
+	if err != nil {
+		return
+	}
+	signer, err := utils.Ecrecover(utils.Sha3(data), s.Signature)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrSnapshotSignature, err)
+	}
+	if signer != s.Signer {
+		return ErrSnapshotSignature
+	}
+	return nil
+}
+
+//ImportSnapshot 将快照导入到一个新的数据库中,并把已处理块数设置为快照对应的块数
+func (model *ModelDB) ImportSnapshot(s *Snapshot) (err error) {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("snapshot version %d not supported, expect %d", s.Version, SnapshotVersion)
+	}
+	var cnt int
+	if err = model.db.Model(&tokenNetwork{}).Count(&cnt).Error; err != nil {
+		return
+	}
+	if cnt > 0 {
+		return ErrSnapshotDBNotEmpty
+	}
+	if err = model.db.Model(&Channel{}).Count(&cnt).Error; err != nil {
+		return
+	}
+	if cnt > 0 {
+		return ErrSnapshotDBNotEmpty
+	}
+	tx := model.db.Begin()
+	for _, t := range s.TokenNetworks {
+		if err = tx.Create(t).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	for _, c := range s.Channels {
+		//participants随channel一起创建
+		if err = tx.Create(c).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	for _, f := range s.ChannelFees {
+		if err = tx.Create(f).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	for _, f := range s.AccountFees {
+		if err = tx.Create(f).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	for _, f := range s.AccountTokenFees {
+		if err = tx.Create(f).Error; err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Model(model.lb).UpdateColumn("BlockNumber", s.BlockNumber).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportSnapshot(t *testing.T) {
+	ast := assert.New(t)
+	model := SetupTestDB()
+	token := utils.NewRandomAddress()
+	registry := utils.NewRandomAddress()
+	err := model.AddTokeNetwork(token, utils.EmptyAddress, 3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c := testCreateChannel(t, model)
+	p1 := common.HexToAddress(c.Participants[0].Participant)
+	p2 := common.HexToAddress(c.Participants[1].Participant)
+	_, err = model.UpdateChannelDeposit(common.HexToHash(c.ChannelID), p1, big.NewInt(50))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = model.UpdateChannelFeeRate(common.HexToHash(c.ChannelID), p2, token, &Fee{
+		FeePolicy:   FeePolicyConstant,
+		FeeConstant: big.NewInt(3),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = model.UpdateAccountDefaultFeePolicy(p1, &Fee{
+		FeePolicy:   FeePolicyPercent,
+		FeeConstant: big.NewInt(0),
+		FeePercent:  1000,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	model.UpdateBlockNumber(100)
+
+	s, err := model.ExportSnapshot(8888, registry)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(100, s.BlockNumber)
+	ast.EqualValues(1, len(s.TokenNetworks))
+	ast.EqualValues(1, len(s.Channels))
+	key, addr := utils.MakePrivateKeyAddress()
+	err = s.Sign(key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(addr, s.Signer)
+
+	//经过序列化以后签名必须仍然有效
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s2 := &Snapshot{}
+	err = json.Unmarshal(data, s2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.Nil(s2.VerifySignature())
+	s2.BlockNumber = 10
+	ast.NotNil(s2.VerifySignature(), "tampered snapshot must be rejected")
+	s2.BlockNumber = 100
+
+	model = SetupTestDB()
+	err = model.ImportSnapshot(s2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues(100, model.GetLatestBlockNumber())
+	ast.EqualValues(1, len(model.GetAllTokenNetworks()))
+	c2, err := model.GetChannel(c.ChannelID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ast.EqualValues("50", c2.Participants[0].Balance)
+	ast.EqualValues(big.NewInt(3), model.GetChannelFeeRate(common.HexToHash(c.ChannelID), p2, token).FeeConstant)
+	ast.EqualValues(1000, model.GetAccountFeePolicy(p1).FeePercent)
+
+	//不能重复导入
+	ast.Equal(ErrSnapshotDBNotEmpty, model.ImportSnapshot(s2))
+}
@@ -18,40 +18,45 @@ type latestBlockNumber struct {
 	BlockNumber int64
 }
 
-var lb = &latestBlockNumber{ID: 1}
-
 //UpdateBlockNumber 更新最新的已经处理过的块数
-func UpdateBlockNumber(blockNumber int64) {
-	err := db.Model(lb).UpdateColumn("BlockNumber", blockNumber).Error
+func (model *ModelDB) UpdateBlockNumber(blockNumber int64) {
+	err := model.db.Model(model.lb).UpdateColumn("BlockNumber", blockNumber).Error
 	if err != nil {
 		log.Crit(fmt.Sprintf("err=%s", err))
 	}
 }
 
 //GetLatestBlockNumber 获取已经处理的最新块数
-func GetLatestBlockNumber() int64 {
-	l2 := &latestBlockNumber{}
-	if err := db.First(l2).Error; err != nil {
+func (model *ModelDB) GetLatestBlockNumber() int64 {
+	n, err := model.LatestBlockNumber()
+	if err != nil {
 		log.Crit(fmt.Sprintf("err=%s", err))
 	}
-	return l2.BlockNumber
+	return n
+}
+
+//LatestBlockNumber 获取已经处理的最新块数,数据库出错时返回错误,用于检查数据库是否可用
+func (model *ModelDB) LatestBlockNumber() (int64, error) {
+	l2 := &latestBlockNumber{}
+	err := model.db.First(l2).Error
+	return l2.BlockNumber, err
 }
 
 //AddTokeNetwork 链上新建了一个tokennetwork
-func AddTokeNetwork(token, tw common.Address, blockNumber int64) error {
+func (model *ModelDB) AddTokeNetwork(token, tw common.Address, blockNumber int64) error {
 	t := &tokenNetwork{
 		Token:        token.String(),
 		TokenNetwork: tw.String(),
 		BlockNumber:  blockNumber,
 	}
-	return db.Create(t).Error
+	return model.db.Create(t).Error
 }
 
 //GetAllTokenNetworks 获取目前已知的tokenNetwork
-func GetAllTokenNetworks() map[common.Address]common.Address {
+func (model *ModelDB) GetAllTokenNetworks() map[common.Address]common.Address {
 	m := make(map[common.Address]common.Address)
 	var ts []tokenNetwork
-	if err := db.Find(&ts).Error; err != nil {
+	if err := model.db.Find(&ts).Error; err != nil {
 		log.Crit(err.Error())
 	}
 	for i := range ts {
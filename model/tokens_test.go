@@ -1,41 +1,111 @@
 package model
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/SmartMeshFoundation/Photon/utils"
 )
 
 func TestGetLatestBlockNumber(t *testing.T) {
-	SetupTestDB()
-	if GetLatestBlockNumber() != 0 {
+	model := SetupTestDB()
+	if model.GetLatestBlockNumber() != 0 {
 		t.Error("should be 0 first")
 		return
 	}
-	UpdateBlockNumber(32)
-	if GetLatestBlockNumber() != 32 {
+	model.UpdateBlockNumber(32)
+	if model.GetLatestBlockNumber() != 32 {
 		t.Error("should 32")
 		return
 	}
 }
 
 func TestGetAllTokenNetworks(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	a1 := utils.NewRandomAddress()
 	a2 := utils.NewRandomAddress()
-	m := GetAllTokenNetworks()
-	err := AddTokeNetwork(a1, a2, 3)
+	m := model.GetAllTokenNetworks()
+	err := model.AddTokeNetwork(a1, a2, 3)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	err = AddTokeNetwork(a1, a2, 5)
+	err = model.AddTokeNetwork(a1, a2, 5)
 	if err == nil {
 		t.Error("cannot duplicate")
 		return
 	}
-	m = GetAllTokenNetworks()
+	m = model.GetAllTokenNetworks()
 	if len(m) != 1 {
 		t.Error("length error")
 	}
 }
+
+func TestTransaction(t *testing.T) {
+	model := SetupTestDB()
+	err := model.Transaction(func(tx *ModelDB) error {
+		tx.UpdateBlockNumber(10)
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Error("should return error of fn")
+		return
+	}
+	if model.GetLatestBlockNumber() != 0 {
+		t.Error("should rollback")
+		return
+	}
+	err = model.Transaction(func(tx *ModelDB) error {
+		tx.UpdateBlockNumber(20)
+		return tx.AddTokeNetwork(utils.NewRandomAddress(), utils.NewRandomAddress(), 20)
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if model.GetLatestBlockNumber() != 20 || len(model.GetAllTokenNetworks()) != 1 {
+		t.Error("should commit")
+	}
+}
+
+//事务进行中通过model的写入不属于这个事务,不会跟着回滚
+func TestTransactionIsolated(t *testing.T) {
+	model := SetupTestDB()
+	err := model.Transaction(func(tx *ModelDB) error {
+		if err := model.AddTokeNetwork(utils.NewRandomAddress(), utils.NewRandomAddress(), 10); err != nil {
+			return err
+		}
+		tx.UpdateBlockNumber(10)
+		return errors.New("rollback")
+	})
+	if err == nil || err.Error() != "rollback" {
+		t.Errorf("should return error of fn, got %v", err)
+		return
+	}
+	if model.GetLatestBlockNumber() != 0 {
+		t.Error("write in tx should rollback")
+		return
+	}
+	if len(model.GetAllTokenNetworks()) != 1 {
+		t.Error("write outside tx should not rollback")
+	}
+}
+
+func TestWithTablePrefix(t *testing.T) {
+	model := SetupTestDB()
+	model2 := model.WithTablePrefix("chain2_")
+	model.UpdateBlockNumber(10)
+	model2.UpdateBlockNumber(20)
+	err := model2.AddTokeNetwork(utils.NewRandomAddress(), utils.NewRandomAddress(), 20)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if model.GetLatestBlockNumber() != 10 || model2.GetLatestBlockNumber() != 20 {
+		t.Error("block number should be separated")
+		return
+	}
+	if len(model.GetAllTokenNetworks()) != 0 || len(model2.GetAllTokenNetworks()) != 1 {
+		t.Error("token networks should be separated")
+	}
+}
@@ -12,26 +12,26 @@ type xmpp struct {
 	IsSubScribed bool   //是否已经订阅改地址
 }
 
-func getxmpp(address string) (x *xmpp, err error) {
+func (model *ModelDB) getxmpp(address string) (x *xmpp, err error) {
 	x = &xmpp{
 		Address: address,
 	}
-	err = db.Where(x).Find(x).Error
+	err = model.db.Where(x).Find(x).Error
 	return
 }
 
 //XMPPMarkAddrSubed mark `addr` subscribed
-func XMPPMarkAddrSubed(addr common.Address) {
-	x, err := getxmpp(addr.String())
+func (model *ModelDB) XMPPMarkAddrSubed(addr common.Address) {
+	x, err := model.getxmpp(addr.String())
 	if err == nil {
 		x.IsSubScribed = true
-		err = db.Model(x).UpdateColumn("IsSubScribed", true).Error
+		err = model.db.Model(x).UpdateColumn("IsSubScribed", true).Error
 	} else {
 		x := &xmpp{
 			Address:      addr.String(),
 			IsSubScribed: true,
 		}
-		err = db.Create(x).Error
+		err = model.db.Create(x).Error
 	}
 	if err != nil {
 		log.Error(fmt.Sprintf("XMPPMarkAddrSubed %s err %s", addr.String(), err))
@@ -39,17 +39,17 @@ func XMPPMarkAddrSubed(addr common.Address) {
 }
 
 //XMPPIsAddrSubed return true when `addr` already subscirbed
-func XMPPIsAddrSubed(addr common.Address) bool {
-	x, err := getxmpp(addr.String())
+func (model *ModelDB) XMPPIsAddrSubed(addr common.Address) bool {
+	x, err := model.getxmpp(addr.String())
 	return err == nil && x.IsSubScribed
 }
 
 //XMPPUnMarkAddr mark `addr` has been unsubscribed
-func XMPPUnMarkAddr(addr common.Address) {
-	x, err := getxmpp(addr.String())
+func (model *ModelDB) XMPPUnMarkAddr(addr common.Address) {
+	x, err := model.getxmpp(addr.String())
 	if err == nil {
 		x.IsSubScribed = false
-		err = db.Model(x).UpdateColumn("IsSubScribed", false).Error
+		err = model.db.Model(x).UpdateColumn("IsSubScribed", false).Error
 	}
 	if err != nil {
 		log.Error(fmt.Sprintf("XMPPUnMarkAddr %s err %s", addr.String(), err))
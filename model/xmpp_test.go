@@ -7,22 +7,22 @@ import (
 )
 
 func TestXMPPIsAddrSubed(t *testing.T) {
-	SetupTestDB()
+	model := SetupTestDB()
 	addr1 := utils.NewRandomAddress()
-	if XMPPIsAddrSubed(addr1) {
+	if model.XMPPIsAddrSubed(addr1) {
 		t.Error("should not sub")
 		return
 	}
 	//should not error
-	XMPPUnMarkAddr(addr1)
+	model.XMPPUnMarkAddr(addr1)
 	//no error
-	XMPPMarkAddrSubed(addr1)
-	if !XMPPIsAddrSubed(addr1) {
+	model.XMPPMarkAddrSubed(addr1)
+	if !model.XMPPIsAddrSubed(addr1) {
 		t.Error("should subed")
 		return
 	}
-	XMPPUnMarkAddr(addr1)
-	if XMPPIsAddrSubed(addr1) {
+	model.XMPPUnMarkAddr(addr1)
+	if model.XMPPIsAddrSubed(addr1) {
 		t.Error("should not subed")
 	}
 }
@@ -1,18 +1,21 @@
 package params
 
 import (
-	"crypto/ecdsa"
 	"math/big"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
-//ChainID for the block chain
-var ChainID = big.NewInt(0)
+//Version of this pfs
+var Version = "0.1"
+
+//GitCommit 编译时通过 -ldflags "-X github.com/SmartMeshFoundation/Photon-Path-Finder/params.GitCommit=xxx" 设置
+var GitCommit string
 
 //DefaultFeePolicy 缺省按比例收费
 var DefaultFeePolicy = 1 //model3.FeePolicyPercent
@@ -35,18 +38,114 @@ var DBPath string
 //Port is listening service port
 var Port int
 
-//ObserverKey is the key login to matrix server to observer other's presence
-var ObserverKey func() *ecdsa.PrivateKey
-
 //MatrixServer the matrix server for path finder use
 var MatrixServer = "transport01.smartmesh.cn"
 
+//MatrixServers homeserver urls like https://transport01.smartmesh.cn:8448, tried in order, empty means http://MatrixServer:8008
+var MatrixServers []string
+
+//MatrixCAFile CA certificate to verify https homeservers, empty means system CAs
+var MatrixCAFile string
+
+//PresenceMinBackoff 上下线发现服务连接失败以后第一次重试的间隔,之后每次加倍
+var PresenceMinBackoff = time.Second
+
+//PresenceMaxBackoff 上下线发现服务连接失败以后重试的最大间隔
+var PresenceMaxBackoff = 2 * time.Minute
+
 //DebugMode for debug setting
 var DebugMode = false
 
+//EnableBackfill 启动时先分批回放历史事件,追上链上最新块以后才提供路由服务
+var EnableBackfill = false
+
+//BackfillBatchSize 历史事件回放时每次从链上获取多少块的事件
+var BackfillBatchSize int64 = 5000
+
+//BackfillRetryInterval 历史事件回放时获取事件或者处理事件失败,等待多久以后重试
+var BackfillRetryInterval = 5 * time.Second
+
+//SyncedThreshold 已处理块数与链上最新块相差不超过这么多块时,认为已经同步完毕,可以提供路由服务
+var SyncedThreshold int64 = 10
+
+//HeadBlockPollInterval 每隔多久获取一次链上最新块,用于判断是否已经同步完毕,避免每个请求都访问以太坊节点
+var HeadBlockPollInterval = 5 * time.Second
+
+//MaxBalanceProofsPerRequest 批量提交balance proof时一次最多提交多少个
+var MaxBalanceProofsPerRequest = 1000
+
+//UserRequestTimeout 用户提交的balance proof等请求最多等待多长时间处理完毕
+var UserRequestTimeout = 10 * time.Second
+
+//ShutdownTimeout 退出时等待正在处理的http请求以及已经提交的事件处理完毕的最长时间
+var ShutdownTimeout = 30 * time.Second
+
+//BalanceAgeHalfLife pfs知道的余额每过这么长时间,认为其可信度减半,用于估计路径成功概率
+var BalanceAgeHalfLife = 24 * time.Hour
+
+//MinHopProbability 估计的每一跳成功概率最小值,避免一条通道因为过时或者失败过多完全不可用
+var MinHopProbability = 0.05
+
+/*
+ProbabilityWeight 计算路径时成功概率每减半,这一跳的权重增加多少.
+权重的单位是10^-5个token(decimals小于5的token是最小单位),和手续费一样
+*/
+var ProbabilityWeight = 1000.0
+
+//FeedbackPenalty 节点每报告一次通道转账失败,这个方向增加的惩罚,惩罚为x时估计的成功概率除以1+x
+var FeedbackPenalty = 1.0
+
+//FeedbackMaxClockSkew 转账结果报告中的时间与pfs的时间最多相差多少,超过的报告认为是重放
+var FeedbackMaxClockSkew = time.Minute
+
+//FeedbackRateLimit 每个节点每分钟最多报告多少次转账结果
+var FeedbackRateLimit = 20
+
+//PenaltyHalfLife 通道受到的惩罚每过这么长时间减半
+var PenaltyHalfLife = time.Hour
+
+//MinChannelPenalty 惩罚衰减到这个值以下时认为已经没有惩罚了
+var MinChannelPenalty = 0.01
+
+//MaxRouteHintHops 推荐给收款方的路由提示最多包含多少跳
+var MaxRouteHintHops = 3
+
+//MaxRouteHints 最多推荐多少条路由提示
+var MaxRouteHints = 5
+
+//PresenceSilenceWindow 使用http心跳发现节点上下线时,超过这么长时间没有心跳认为节点下线
+var PresenceSilenceWindow = 2 * time.Minute
+
+//HeartbeatMaxClockSkew 心跳中的时间与pfs的时间最多相差多少
+var HeartbeatMaxClockSkew = time.Minute
+
+//OfflineGracePeriod 节点报告下线以后,还要等这么长时间才不再用于路由,避免网络不好的节点路由频繁变化
+var OfflineGracePeriod = 30 * time.Second
+
+//FlapWindow 节点下线后在这么长时间之内重新上线才记为一次抖动,下线很久以后重新上线(比如计划中的重启)不算
+var FlapWindow = 5 * time.Minute
+
+//FlapPenaltyHalfLife 节点下线后重新上线记为一次抖动,抖动惩罚每过这么长时间减半
+var FlapPenaltyHalfLife = 15 * time.Minute
+
+//FlapSuppressThreshold 抖动惩罚达到这个值时,节点暂时不用于路由
+var FlapSuppressThreshold = 3.0
+
+//FlapReuseThreshold 被抑制的节点抖动惩罚衰减到这个值以下时恢复路由
+var FlapReuseThreshold = 1.0
+
 //DefaultXMPPServer xmpp server
 const DefaultXMPPServer = "193.112.248.133:5222"
 
+//XMPPServer the xmpp server for path finder use, host:port
+var XMPPServer = DefaultXMPPServer
+
+//XMPPTLS none, starttls or tls, with starttls and tls the server certificate is always verified
+var XMPPTLS = "none"
+
+//XMPPCAFile CA certificate to verify xmpp server, empty means system CAs
+var XMPPCAFile string
+
 //DefaultDataDir default work directory
 func DefaultDataDir() string {
 	// Try to place the data folder in the user's home dir
@@ -4,6 +4,11 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
+	"runtime"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
 
 	"github.com/nkbai/goutils"
 
@@ -21,35 +26,240 @@ type balanceProofRequest struct {
 	BalanceSignature []byte              `json:"balance_signature"`
 	ProofSigner      common.Address      `json:"proof_signer"`
 	LockedAmount     *big.Int            `json:"lock_amount"`
+	// 可选,对方balance proof中所有未完成的锁,提交以后pfs会重新计算locksroot并检查LockedAmount,不需要签名
+	Locks []*lockRequest `json:"locks,omitempty"`
 	// 如果节点启动参数中开启了ignore-mediatednode-request参数,那么该节点将不接收MediatedTransfer交易,此时需要报告给pfs,以免pfs把自己当中间节点来计算路由
 	// 该参数没必要纳入签名
 	IgnoreMediatedTransfer bool `json:"ignore_mediated_transfer"`
 }
 
-// UpdateBalanceProof handle the request with balance proof,implements GET and POST /balance
+//lockRequest 与photon中的mtree.Lock对应
+type lockRequest struct {
+	Expiration     int64       `json:"expiration"`
+	Amount         *big.Int    `json:"amount"`
+	LockSecretHash common.Hash `json:"lock_secret_hash"`
+}
+
+//除了model.BalanceProofError中的错误码以外,rest接口自己的错误码
+const (
+	errCodeBadRequest = "bad_request"
+	errCodeTimeout    = "timeout"
+	errCodeStopping   = "stopping"
+	errCodeInternal   = "internal_error"
+)
+
+//errorResponse 带错误码的错误信息,节点根据code判断失败原因
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+//balanceProofErrorCode 将处理balance proof的错误转换为错误码和http状态码
+func balanceProofErrorCode(err error) (code string, status int) {
+	switch err {
+	case blockchainlistener.ErrRequestTimeout:
+		return errCodeTimeout, http.StatusGatewayTimeout
+	case blockchainlistener.ErrStopping:
+		return errCodeStopping, http.StatusServiceUnavailable
+	}
+	bpErr, ok := err.(*model.BalanceProofError)
+	if !ok {
+		return errCodeInternal, http.StatusInternalServerError
+	}
+	switch bpErr.Code {
+	case model.ErrCodeChannelNotFound:
+		status = http.StatusNotFound
+	case model.ErrCodeInvalidBalanceProof, model.ErrCodeInvalidSignature, model.ErrCodeParticipantMismatch,
+		model.ErrCodeLocksRootMismatch, model.ErrCodeLockedAmountMismatch, model.ErrCodeLocksMissing:
+		status = http.StatusBadRequest
+	default:
+		//与pfs中已知的通道状态冲突
+		status = http.StatusConflict
+	}
+	return bpErr.Code, status
+}
+
+func writeError(w rest.ResponseWriter, status int, code string, msg string) {
+	w.WriteHeader(status)
+	err := w.WriteJson(&errorResponse{
+		Code:    code,
+		Message: msg,
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+//participantBalanceView 通道一方在pfs中的状态
+type participantBalanceView struct {
+	Participant       common.Address `json:"participant"`
+	Nonce             uint64         `json:"nonce"`
+	Deposit           *big.Int       `json:"deposit"`
+	TransferredAmount *big.Int       `json:"transferred_amount"`
+	LockedAmount      *big.Int       `json:"locked_amount"`
+	Balance           *big.Int       `json:"balance"`
+}
+
+//channelBalanceView 更新balance proof成功以后返回给节点的通道状态
+type channelBalanceView struct {
+	ChannelID       common.Hash               `json:"channel_identifier"`
+	OpenBlockNumber int64                     `json:"open_block_number"`
+	Participants    []*participantBalanceView `json:"participants"`
+}
+
+func newChannelBalanceView(c *model.Channel) *channelBalanceView {
+	v := &channelBalanceView{
+		ChannelID:       common.HexToHash(c.ChannelID),
+		OpenBlockNumber: c.OpenBlockNumber,
+	}
+	for _, p := range c.Participants {
+		v.Participants = append(v.Participants, &participantBalanceView{
+			Participant:       common.HexToAddress(p.Participant),
+			Nonce:             p.Nonce,
+			Deposit:           p.DepositValue(),
+			TransferredAmount: p.TransferredAmountValue(),
+			LockedAmount:      p.LockedAmountValue(),
+			Balance:           p.BalanceValue(),
+		})
+	}
+	return v
+}
+
+/*
+UpdateBalanceProof handle the request with balance proof,implements PUT /:peer/balance
+成功时返回更新后的通道状态,失败时返回errorResponse
+*/
 func UpdateBalanceProof(w rest.ResponseWriter, r *rest.Request) {
 	var req = &balanceProofRequest{}
 	var err error
 	defer func() {
 		log.Trace(fmt.Sprintf("UpdateBalanceProof op req=%s,err=%s", utils.StringInterface(req, 5), err))
 	}()
+	ce, ok := getNetwork(w, r)
+	//同步历史事件期间不处理balance proof,否则请求会一直阻塞
+	if !ok || !checkSynced(w, ce) {
+		return
+	}
 	peer := r.PathParam("peer")
 	peerAddress := common.HexToAddress(peer)
 	err = r.DecodeJsonPayload(req)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+	partner, err := verifyBalanceProof(ce.DB(), req, peerAddress, ce.ChainID())
+	if err != nil {
+		code, status := balanceProofErrorCode(err)
+		writeError(w, status, code, err.Error())
+		return
+	}
+	var c *model.Channel
+	if req.BalanceProof.Nonce > 0 {
+		c, err = ce.HandleReceiveUserUpdateBalanceProof(peerAddress, partner, req.LockedAmount, req.BalanceProof, req.IgnoreMediatedTransfer)
+	} else {
+		//没有新的balance proof,返回当前状态
+		c, err = ce.DB().GetChannelWithParticipants(req.BalanceProof.ChannelID, peerAddress, partner)
+	}
+	if err != nil {
+		code, status := balanceProofErrorCode(err)
+		writeError(w, status, code, err.Error())
+		return
+	}
+	err = w.WriteJson(newChannelBalanceView(c))
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+//bulkBalanceProofRequest 一次提交多个通道的balance proof,适用于通道数量很多的节点
+type bulkBalanceProofRequest struct {
+	BalanceProofs []*balanceProofRequest `json:"balance_proofs"`
+}
+
+//balanceProofResult 批量提交时每一个balance proof的处理结果,顺序与请求一致
+type balanceProofResult struct {
+	ChannelID common.Hash `json:"channel_identifier"`
+	Code      string      `json:"code,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// verifyBalanceProofs verify balance proofs in parallel, returns partner and error of every request
+func verifyBalanceProofs(db *model.ModelDB, reqs []*balanceProofRequest, participant common.Address, chainID *big.Int) (partners []common.Address, errs []error) {
+	partners = make([]common.Address, len(reqs))
+	errs = make([]error, len(reqs))
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req *balanceProofRequest) {
+			sem <- struct{}{}
+			partners[i], errs[i] = verifyBalanceProof(db, req, participant, chainID)
+			<-sem
+			wg.Done()
+		}(i, req)
+	}
+	wg.Wait()
+	return
+}
+
+// UpdateBalanceProofs handle a batch of balance proofs, implements PUT /:peer/balances
+// all valid balance proofs are applied in one database transaction
+func UpdateBalanceProofs(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok || !checkSynced(w, ce) {
 		return
 	}
-	//var locksAmount *big.Int
-	partner, err := verifyBalanceProofSignature(req, peerAddress)
+	peerAddress := common.HexToAddress(r.PathParam("peer"))
+	req := &bulkBalanceProofRequest{}
+	err := r.DecodeJsonPayload(req)
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if req.BalanceProof != nil && req.BalanceProof.Nonce > 0 {
-		ce.HandleReceiveUserUpdateBalanceProof(peerAddress, partner, req.LockedAmount, req.BalanceProof, req.IgnoreMediatedTransfer)
+	if len(req.BalanceProofs) > params.MaxBalanceProofsPerRequest {
+		rest.Error(w, fmt.Sprintf("too many balance proofs %d, max %d", len(req.BalanceProofs), params.MaxBalanceProofsPerRequest), http.StatusBadRequest)
+		return
+	}
+	partners, errs := verifyBalanceProofs(ce.DB(), req.BalanceProofs, peerAddress, ce.ChainID())
+	results := make([]*balanceProofResult, len(req.BalanceProofs))
+	var updates []*blockchainlistener.BalanceProofUpdate
+	var indexes []int //updates[i]对应的是第indexes[i]个请求
+	for i, bpr := range req.BalanceProofs {
+		results[i] = &balanceProofResult{}
+		if errs[i] != nil {
+			results[i].Code, _ = balanceProofErrorCode(errs[i])
+			results[i].Error = errs[i].Error()
+			continue
+		}
+		results[i].ChannelID = bpr.BalanceProof.ChannelID
+		if bpr.BalanceProof.Nonce == 0 {
+			continue
+		}
+		updates = append(updates, &blockchainlistener.BalanceProofUpdate{
+			Participant:            peerAddress,
+			Partner:                partners[i],
+			LockedAmount:           bpr.LockedAmount,
+			PartnerBalanceProof:    bpr.BalanceProof,
+			IgnoreMediatedTransfer: bpr.IgnoreMediatedTransfer,
+		})
+		indexes = append(indexes, i)
+	}
+	if len(updates) > 0 {
+		errs, err = ce.HandleReceiveUserUpdateBalanceProofs(updates)
+		if err != nil {
+			code, status := balanceProofErrorCode(err)
+			writeError(w, status, code, err.Error())
+			return
+		}
+		for i, err := range errs {
+			if err != nil {
+				results[indexes[i]].Code, _ = balanceProofErrorCode(err)
+				results[indexes[i]].Error = err.Error()
+			}
+		}
 	}
-	err = w.WriteJson(nil)
+	log.Trace(fmt.Sprintf("UpdateBalanceProofs peer=%s,results=%s", peerAddress.String(), utils.StringInterface(results, 3)))
+	err = w.WriteJson(results)
 	if err != nil {
 		log.Error(fmt.Sprintf("write json err %s", err))
 	}
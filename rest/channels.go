@@ -0,0 +1,172 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+var channelStatusNames = map[int]string{
+	model.ChannelStatusOpen:    "open",
+	model.ChannelStatusClosed:  "closed",
+	model.ChannelStatusSettled: "settled",
+}
+
+//participantInfo 通道一方在pfs中的全部状态,用于排查找不到路由的问题
+type participantInfo struct {
+	*participantBalanceView
+	IgnoreMediatedTransfer bool       `json:"ignore_mediated_transfer"`
+	Fee                    *model.Fee `json:"fee"`
+	Online                 bool       `json:"online"`
+	Mobile                 bool       `json:"mobile"`
+}
+
+//channelInfo 通道在pfs中的状态
+type channelInfo struct {
+	ChannelID       common.Hash        `json:"channel_identifier"`
+	Token           common.Address     `json:"token"`
+	Status          string             `json:"status"`
+	OpenBlockNumber int64              `json:"open_block_number"`
+	Participants    []*participantInfo `json:"participants"`
+}
+
+//channelPage 分页返回的通道列表
+type channelPage struct {
+	Total    int            `json:"total"`
+	Offset   int            `json:"offset"`
+	Limit    int            `json:"limit"`
+	Channels []*channelInfo `json:"channels"`
+}
+
+func newChannelInfo(ce *blockchainlistener.ChainEvents, c *model.Channel) *channelInfo {
+	ci := &channelInfo{
+		ChannelID:       common.HexToHash(c.ChannelID),
+		Token:           common.HexToAddress(c.Token),
+		Status:          channelStatusNames[c.Status],
+		OpenBlockNumber: c.OpenBlockNumber,
+	}
+	views := newChannelBalanceView(c).Participants
+	for i, p := range c.Participants {
+		pi := &participantInfo{
+			participantBalanceView: views[i],
+			IgnoreMediatedTransfer: p.IgnoreMediatedTransfer,
+			Fee:                    ce.DB().GetChannelFeeRate(ci.ChannelID, views[i].Participant, ci.Token),
+		}
+		state, _ := ce.TokenNetwork.GetNodeState(views[i].Participant)
+		pi.Online = state.Online
+		pi.Mobile = state.Mobile
+		ci.Participants = append(ci.Participants, pi)
+	}
+	return ci
+}
+
+//getPageArgs 解析offset和limit参数
+func getPageArgs(r *rest.Request) (offset, limit int, err error) {
+	limit = defaultPageLimit
+	q := r.URL.Query()
+	if s := q.Get("offset"); len(s) > 0 {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			err = fmt.Errorf("invalid offset %s", s)
+			return
+		}
+	}
+	if s := q.Get("limit"); len(s) > 0 {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			err = fmt.Errorf("invalid limit %s, must be 1-%d", s, maxPageLimit)
+			return
+		}
+	}
+	return
+}
+
+// getChannel returns what pfs knows about a channel, implements GET /channels/:channel
+func getChannel(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	channelID := common.HexToHash(r.PathParam("channel"))
+	c, err := ce.DB().GetChannel(channelID.String())
+	if gorm.IsRecordNotFoundError(err) {
+		rest.Error(w, fmt.Sprintf("channel %s not found", channelID.String()), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = w.WriteJson(newChannelInfo(ce, c))
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+func writeChannelPage(w rest.ResponseWriter, ce *blockchainlistener.ChainEvents, cs []*model.Channel, total, offset, limit int) {
+	page := &channelPage{
+		Total:    total,
+		Offset:   offset,
+		Limit:    limit,
+		Channels: []*channelInfo{},
+	}
+	for _, c := range cs {
+		page.Channels = append(page.Channels, newChannelInfo(ce, c))
+	}
+	err := w.WriteJson(page)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+// getNodeChannels returns channels of a node, implements GET /nodes/:address/channels?offset=0&limit=100
+func getNodeChannels(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	offset, limit, err := getPageArgs(r)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	address := common.HexToAddress(r.PathParam("address"))
+	cs, total, err := ce.DB().GetParticipantChannelsPage(address, offset, limit)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeChannelPage(w, ce, cs, total, offset, limit)
+}
+
+// getTokenChannels returns channels of a token, implements GET /tokens/:token/channels?offset=0&limit=100
+func getTokenChannels(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	offset, limit, err := getPageArgs(r)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token := common.HexToAddress(r.PathParam("token"))
+	cs, total, err := ce.DB().GetTokenChannelsPage(token, offset, limit)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeChannelPage(w, ce, cs, total, offset, limit)
+}
@@ -0,0 +1,29 @@
+package rest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPageArgs(t *testing.T) {
+	r := &rest.Request{Request: httptest.NewRequest("GET", "/pfs/1/tokens/0x1/channels", nil)}
+	offset, limit, err := getPageArgs(r)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, offset)
+	assert.EqualValues(t, defaultPageLimit, limit)
+
+	r = &rest.Request{Request: httptest.NewRequest("GET", "/pfs/1/tokens/0x1/channels?offset=20&limit=5", nil)}
+	offset, limit, err = getPageArgs(r)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 20, offset)
+	assert.EqualValues(t, 5, limit)
+
+	for _, q := range []string{"offset=-1", "offset=a", "limit=0", "limit=100000"} {
+		r = &rest.Request{Request: httptest.NewRequest("GET", "/pfs/1/tokens/0x1/channels?"+q, nil)}
+		_, _, err = getPageArgs(r)
+		assert.NotNil(t, err, q)
+	}
+}
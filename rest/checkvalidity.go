@@ -5,37 +5,55 @@ import (
 	"crypto/ecdsa"
 	"encoding/binary"
 	"fmt"
+	"math/big"
 
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
-
-	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 
 	smparams "github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// checkBalanceProofFields 签名计算需要的字段都必须存在,金额不能为负数
+func checkBalanceProofFields(bpr *balanceProofRequest) error {
+	if bpr == nil || bpr.BalanceProof == nil {
+		return model.NewBalanceProofError(model.ErrCodeInvalidBalanceProof, "balance proof missing")
+	}
+	if bpr.BalanceProof.TransferAmount == nil || bpr.BalanceProof.TransferAmount.Sign() < 0 {
+		return model.NewBalanceProofError(model.ErrCodeInvalidBalanceProof, "invalid transfer amount %s", bpr.BalanceProof.TransferAmount)
+	}
+	if bpr.LockedAmount == nil || bpr.LockedAmount.Sign() < 0 {
+		return model.NewBalanceProofError(model.ErrCodeInvalidBalanceProof, "invalid locked amount %s", bpr.LockedAmount)
+	}
+	return nil
+}
+
 // verifyBalanceProofSignature verify balance proof sinature and caller's sinature
 // 1\verify bob's balance proof sinature
 // 2\verify alice(caller)'s infomation's sinature
 // 3\Balance_Proof_Hash	(nonce,transfer_amount,locksroot,channel_id,open_block_number,additional_hash)
 // 4\Message_Hash		(balance_proof,lock_amount)
-func verifyBalanceProofSignature(bpr *balanceProofRequest, participant common.Address) (partner common.Address, err error) {
+func verifyBalanceProofSignature(bpr *balanceProofRequest, participant common.Address, chainID *big.Int) (partner common.Address, err error) {
+	err = checkBalanceProofFields(bpr)
+	if err != nil {
+		return
+	}
+	//写入bytes.Buffer不会失败
 	tmpBuf := new(bytes.Buffer)
-	err = binary.Write(tmpBuf, binary.BigEndian, bpr.BalanceProof.Nonce)           //nonce
-	_, err = tmpBuf.Write(utils.BigIntTo32Bytes(bpr.BalanceProof.TransferAmount))  //transfer_amount
-	_, err = tmpBuf.Write(bpr.BalanceProof.LocksRoot[:])                           //locksroot
-	_, err = tmpBuf.Write(bpr.BalanceProof.ChannelID[:])                           //channel_id
-	err = binary.Write(tmpBuf, binary.BigEndian, bpr.BalanceProof.OpenBlockNumber) //open_block_number
-	_, err = tmpBuf.Write(bpr.BalanceProof.AdditionalHash[:])                      //additional_hash
-	_, err = tmpBuf.Write(bpr.BalanceProof.Signature)
-	_, err = tmpBuf.Write(utils.BigIntTo32Bytes(bpr.LockedAmount)) //locks_amount
-	_, err = tmpBuf.Write(bpr.ProofSigner[:])
+	binary.Write(tmpBuf, binary.BigEndian, bpr.BalanceProof.Nonce)           //nonce
+	tmpBuf.Write(utils.BigIntTo32Bytes(bpr.BalanceProof.TransferAmount))     //transfer_amount
+	tmpBuf.Write(bpr.BalanceProof.LocksRoot[:])                              //locksroot
+	tmpBuf.Write(bpr.BalanceProof.ChannelID[:])                              //channel_id
+	binary.Write(tmpBuf, binary.BigEndian, bpr.BalanceProof.OpenBlockNumber) //open_block_number
+	tmpBuf.Write(bpr.BalanceProof.AdditionalHash[:])                         //additional_hash
+	tmpBuf.Write(bpr.BalanceProof.Signature)
+	tmpBuf.Write(utils.BigIntTo32Bytes(bpr.LockedAmount)) //locks_amount
+	tmpBuf.Write(bpr.ProofSigner[:])
 	messageHash := utils.Sha3(tmpBuf.Bytes())
-	messageSignature := bpr.BalanceSignature
-	signer, err := utils.Ecrecover(messageHash, messageSignature)
-	if signer != participant {
-		err = fmt.Errorf("illegal signature of balance message, for participant")
+	signer, err := utils.Ecrecover(messageHash, bpr.BalanceSignature)
+	if err != nil || signer != participant {
+		err = model.NewBalanceProofError(model.ErrCodeInvalidSignature, "illegal signature of balance message, for participant")
 		return
 	}
 	//ignore empty balance proof
@@ -45,25 +63,93 @@ func verifyBalanceProofSignature(bpr *balanceProofRequest, participant common.Ad
 	}
 	//检查是谁的balance proof
 	bpBuf := new(bytes.Buffer)
-	_, err = bpBuf.Write(smparams.ContractSignaturePrefix)
-	_, err = bpBuf.Write([]byte(smparams.ContractBalanceProofMessageLength))
-	_, err = bpBuf.Write(utils.BigIntTo32Bytes(bpr.BalanceProof.TransferAmount))
-	_, err = bpBuf.Write(bpr.BalanceProof.LocksRoot[:])
-	err = binary.Write(bpBuf, binary.BigEndian, bpr.BalanceProof.Nonce)
-	_, err = bpBuf.Write(bpr.BalanceProof.AdditionalHash[:])
-	_, err = bpBuf.Write(bpr.BalanceProof.ChannelID[:])
-	err = binary.Write(bpBuf, binary.BigEndian, bpr.BalanceProof.OpenBlockNumber)
-	_, err = bpBuf.Write(utils.BigIntTo32Bytes(params.ChainID)) //smparams.ChainID
+	bpBuf.Write(smparams.ContractSignaturePrefix)
+	bpBuf.Write([]byte(smparams.ContractBalanceProofMessageLength))
+	bpBuf.Write(utils.BigIntTo32Bytes(bpr.BalanceProof.TransferAmount))
+	bpBuf.Write(bpr.BalanceProof.LocksRoot[:])
+	binary.Write(bpBuf, binary.BigEndian, bpr.BalanceProof.Nonce)
+	bpBuf.Write(bpr.BalanceProof.AdditionalHash[:])
+	bpBuf.Write(bpr.BalanceProof.ChannelID[:])
+	binary.Write(bpBuf, binary.BigEndian, bpr.BalanceProof.OpenBlockNumber)
+	bpBuf.Write(utils.BigIntTo32Bytes(chainID)) //smparams.ChainID
 	balanceProofHash := utils.Sha3(bpBuf.Bytes())
-	balanceProofSignature := bpr.BalanceProof.Signature
-	partner, err = utils.Ecrecover(balanceProofHash, balanceProofSignature)
+	partner, err = utils.Ecrecover(balanceProofHash, bpr.BalanceProof.Signature)
 	if err != nil {
-		err = fmt.Errorf("illegal balance proof signature %s", err)
+		err = model.NewBalanceProofError(model.ErrCodeInvalidSignature, "illegal balance proof signature %s", err)
+		return
+	}
+	if partner != bpr.ProofSigner {
+		err = model.NewBalanceProofError(model.ErrCodeInvalidSignature, "balance proof signed by %s, but proof signer is %s", partner.String(), bpr.ProofSigner.String())
 		return
 	}
 	return
 }
 
+/*
+verifyLockedAmount 保证LockedAmount与locksroot一致,避免节点随意修改对方的可用余额.
+提交了locks时重新计算locksroot,LockedAmount必须等于所有锁的金额之和;
+没有提交locks时,locksroot必须为空并且LockedAmount必须为0,否则无法验证LockedAmount.
+*/
+func verifyLockedAmount(bpr *balanceProofRequest) error {
+	if bpr.BalanceProof.Nonce == 0 {
+		return nil
+	}
+	if bpr.Locks == nil {
+		if bpr.BalanceProof.LocksRoot != utils.EmptyHash {
+			return model.NewBalanceProofError(model.ErrCodeLocksMissing, "locksroot is %s but no locks submitted", bpr.BalanceProof.LocksRoot.String())
+		}
+		if bpr.LockedAmount.Sign() != 0 {
+			return model.NewBalanceProofError(model.ErrCodeLockedAmountMismatch, "no locks but locked amount is %s", bpr.LockedAmount)
+		}
+		return nil
+	}
+	locks := make([]*mtree.Lock, len(bpr.Locks))
+	hashes := make(map[common.Hash]bool)
+	sum := new(big.Int)
+	for i, l := range bpr.Locks {
+		if l == nil || l.Amount == nil || l.Amount.Sign() <= 0 {
+			return model.NewBalanceProofError(model.ErrCodeInvalidBalanceProof, "invalid lock %d", i)
+		}
+		locks[i] = &mtree.Lock{
+			Expiration:     l.Expiration,
+			Amount:         l.Amount,
+			LockSecretHash: l.LockSecretHash,
+		}
+		//重复的锁会导致NewMerkleTree panic
+		h := locks[i].Hash()
+		if hashes[h] {
+			return model.NewBalanceProofError(model.ErrCodeInvalidBalanceProof, "duplicate lock %s", l.LockSecretHash.String())
+		}
+		hashes[h] = true
+		sum.Add(sum, l.Amount)
+	}
+	root := mtree.NewMerkleTree(locks).MerkleRoot()
+	if root != bpr.BalanceProof.LocksRoot {
+		return model.NewBalanceProofError(model.ErrCodeLocksRootMismatch, "locksroot of locks is %s, but balance proof is %s", root.String(), bpr.BalanceProof.LocksRoot.String())
+	}
+	if sum.Cmp(bpr.LockedAmount) != 0 {
+		return model.NewBalanceProofError(model.ErrCodeLockedAmountMismatch, "sum of locks is %s, but locked amount is %s", sum, bpr.LockedAmount)
+	}
+	return nil
+}
+
+/*
+verifyBalanceProof 检查签名,锁,以及balance proof的签名者是否是通道的另一方,
+返回通道的另一方
+*/
+func verifyBalanceProof(db *model.ModelDB, bpr *balanceProofRequest, participant common.Address, chainID *big.Int) (partner common.Address, err error) {
+	partner, err = verifyBalanceProofSignature(bpr, participant, chainID)
+	if err != nil {
+		return
+	}
+	err = verifyLockedAmount(bpr)
+	if err != nil {
+		return
+	}
+	_, err = db.GetChannelWithParticipants(bpr.BalanceProof.ChannelID, participant, partner)
+	return
+}
+
 // verifySinatureSetFeeRate verify Fee_rate sinature
 func verifySinatureSetFeeRate(sfr *model.SetFeeRateRequest, peerAddress common.Address) (err error) {
 	tmpBuf := new(bytes.Buffer)
@@ -80,17 +166,43 @@ func verifySinatureSetFeeRate(sfr *model.SetFeeRateRequest, peerAddress common.A
 	return nil
 }
 
-//verifySinaturePaths signature=caller
-func verifySinaturePaths(pr pathRequest, peerAddress common.Address) (err error) {
+//pathRequestData 路径查询请求中需要签名的数据,包括路由提示和限制
+func pathRequestData(pr *pathRequest) []byte {
+	//写入bytes.Buffer不会失败
 	tmpBuf := new(bytes.Buffer)
-	_, err = tmpBuf.Write(pr.PeerFrom[:])                       //peer_from
-	_, err = tmpBuf.Write(pr.PeerTo[:])                         //peer_to
-	_, err = tmpBuf.Write(pr.TokenAddress[:])                   //token_address
-	err = binary.Write(tmpBuf, binary.BigEndian, pr.LimitPaths) //limit_paths
-	_, err = tmpBuf.Write(utils.BigIntTo32Bytes(pr.SendAmount)) //send_amount
-	_, err = tmpBuf.Write([]byte(pr.SortDemand))                //send_amount
+	tmpBuf.Write(pr.PeerFrom[:])                          //peer_from
+	tmpBuf.Write(pr.PeerTo[:])                            //peer_to
+	tmpBuf.Write(pr.TokenAddress[:])                      //token_address
+	binary.Write(tmpBuf, binary.BigEndian, pr.LimitPaths) //limit_paths
+	tmpBuf.Write(utils.BigIntTo32Bytes(pr.SendAmount))    //send_amount
+	tmpBuf.Write([]byte(pr.SortDemand))                   //sort_demand
+	//没有路由提示和限制时签名的数据保持不变
+	if len(pr.ExcludeNodes) > 0 || len(pr.ExcludeChannels) > 0 || len(pr.ViaNodes) > 0 {
+		binary.Write(tmpBuf, binary.BigEndian, uint32(len(pr.ExcludeNodes)))
+		for _, n := range pr.ExcludeNodes {
+			tmpBuf.Write(n[:])
+		}
+		binary.Write(tmpBuf, binary.BigEndian, uint32(len(pr.ExcludeChannels)))
+		for _, c := range pr.ExcludeChannels {
+			tmpBuf.Write(c[:])
+		}
+		binary.Write(tmpBuf, binary.BigEndian, uint32(len(pr.ViaNodes)))
+		for _, n := range pr.ViaNodes {
+			tmpBuf.Write(n[:])
+		}
+	}
+	if pr.MaxHops > 0 || pr.MaxFee != nil {
+		binary.Write(tmpBuf, binary.BigEndian, uint32(pr.MaxHops)) //max_hops
+		if pr.MaxFee != nil {
+			tmpBuf.Write(utils.BigIntTo32Bytes(pr.MaxFee)) //max_fee
+		}
+	}
+	return tmpBuf.Bytes()
+}
 
-	pathHash := utils.Sha3(tmpBuf.Bytes())
+//verifySinaturePaths signature=caller
+func verifySinaturePaths(pr *pathRequest, peerAddress common.Address) (err error) {
+	pathHash := utils.Sha3(pathRequestData(pr))
 	pathSignature := pr.Signature
 	pathSigner, err := utils.Ecrecover(pathHash, pathSignature)
 	if pathSigner != peerAddress {
@@ -101,7 +213,7 @@ func verifySinaturePaths(pr pathRequest, peerAddress common.Address) (err error)
 }
 
 // SignDataForBalanceProof0 signature data,just for test
-func SignDataForBalanceProof0(peerKey *ecdsa.PrivateKey, bp *model.BalanceProof) (err error) {
+func SignDataForBalanceProof0(peerKey *ecdsa.PrivateKey, bp *model.BalanceProof, chainID *big.Int) (err error) {
 	bpBuf := new(bytes.Buffer)
 	_, err = bpBuf.Write(smparams.ContractSignaturePrefix)
 	_, err = bpBuf.Write([]byte(smparams.ContractBalanceProofMessageLength))
@@ -111,7 +223,7 @@ func SignDataForBalanceProof0(peerKey *ecdsa.PrivateKey, bp *model.BalanceProof)
 	_, err = bpBuf.Write(bp.AdditionalHash[:])
 	_, err = bpBuf.Write(bp.ChannelID[:])
 	err = binary.Write(bpBuf, binary.BigEndian, bp.OpenBlockNumber)
-	_, err = bpBuf.Write(utils.BigIntTo32Bytes(params.ChainID)) //smparams.ChainID
+	_, err = bpBuf.Write(utils.BigIntTo32Bytes(chainID)) //smparams.ChainID
 	bp.Signature, err = utils.SignData(peerKey, bpBuf.Bytes())
 
 	return
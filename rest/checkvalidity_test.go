@@ -1,13 +1,17 @@
 package rest
 
 import (
+	"errors"
 	"math/big"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
 
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 	"github.com/SmartMeshFoundation/Photon/utils"
 )
 
@@ -22,7 +26,7 @@ func TestVerifySinature(t *testing.T) {
 
 	key1, addr1 := utils.MakePrivateKeyAddress()
 	key2, addr2 := utils.MakePrivateKeyAddress()
-	err := SignDataForBalanceProof0(key1, br)
+	err := SignDataForBalanceProof0(key1, br, big.NewInt(8888))
 	if err != nil {
 		t.Error(err)
 		return
@@ -38,12 +42,15 @@ func TestVerifySinature(t *testing.T) {
 		return
 	}
 
-	maddr, err := verifyBalanceProofSignature(brm, addr2)
+	maddr, err := verifyBalanceProofSignature(brm, addr2, big.NewInt(8888))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	assert.EqualValues(t, maddr, addr1)
+	//其他链上的balance proof不能被接受
+	maddr, err = verifyBalanceProofSignature(brm, addr2, big.NewInt(3))
+	assert.NotEqual(t, maddr, addr1)
 }
 
 func TestVerifySinatureEmptyBalanceProof(t *testing.T) {
@@ -65,10 +72,124 @@ func TestVerifySinatureEmptyBalanceProof(t *testing.T) {
 		return
 	}
 
-	maddr, err := verifyBalanceProofSignature(brm, addr2)
+	maddr, err := verifyBalanceProofSignature(brm, addr2, big.NewInt(8888))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	assert.EqualValues(t, maddr, addr1)
 }
+
+func errCode(err error) string {
+	bpErr, ok := err.(*model.BalanceProofError)
+	if !ok {
+		return ""
+	}
+	return bpErr.Code
+}
+
+func TestVerifyBalanceProofs(t *testing.T) {
+	chainID := big.NewInt(8888)
+	db := model.SetupTestDB()
+	token := utils.NewRandomAddress()
+	key1, addr1 := utils.MakePrivateKeyAddress()
+	key2, addr2 := utils.MakePrivateKeyAddress()
+	var reqs []*balanceProofRequest
+	for i := 0; i < 4; i++ {
+		br := &model.BalanceProof{
+			Nonce:          uint64(i + 1),
+			TransferAmount: big.NewInt(int64(i)),
+			ChannelID:      utils.NewRandomHash(),
+		}
+		other := addr2
+		//最后一个通道与调用者无关
+		if i == 3 {
+			other = utils.NewRandomAddress()
+		}
+		_, err := db.AddChannel(token, addr1, other, br.ChannelID, 3)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = SignDataForBalanceProof0(key1, br, chainID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		brm := &balanceProofRequest{
+			BalanceProof: br,
+			LockedAmount: big.NewInt(0),
+			ProofSigner:  addr1,
+		}
+		err = SignDataForBalanceProofMessage0(key2, brm)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		reqs = append(reqs, brm)
+	}
+	//篡改以后调用者的签名无效
+	reqs[1].LockedAmount = big.NewInt(3)
+	reqs = append(reqs, &balanceProofRequest{})
+	partners, errs := verifyBalanceProofs(db, reqs, addr2, chainID)
+	assert.Nil(t, errs[0])
+	assert.EqualValues(t, addr1, partners[0])
+	assert.EqualValues(t, model.ErrCodeInvalidSignature, errCode(errs[1]))
+	assert.Nil(t, errs[2])
+	assert.EqualValues(t, addr1, partners[2])
+	assert.EqualValues(t, model.ErrCodeParticipantMismatch, errCode(errs[3]))
+	assert.EqualValues(t, model.ErrCodeInvalidBalanceProof, errCode(errs[4]))
+}
+
+func TestVerifyLockedAmount(t *testing.T) {
+	l1 := &lockRequest{Expiration: 10, Amount: big.NewInt(3), LockSecretHash: utils.NewRandomHash()}
+	l2 := &lockRequest{Expiration: 20, Amount: big.NewInt(5), LockSecretHash: utils.NewRandomHash()}
+	root := mtree.NewMerkleTree([]*mtree.Lock{
+		{Expiration: l1.Expiration, Amount: l1.Amount, LockSecretHash: l1.LockSecretHash},
+		{Expiration: l2.Expiration, Amount: l2.Amount, LockSecretHash: l2.LockSecretHash},
+	}).MerkleRoot()
+	bpr := &balanceProofRequest{
+		BalanceProof: &model.BalanceProof{
+			Nonce:     1,
+			LocksRoot: root,
+		},
+		LockedAmount: big.NewInt(8),
+		Locks:        []*lockRequest{l1, l2},
+	}
+	assert.Nil(t, verifyLockedAmount(bpr))
+	bpr.LockedAmount = big.NewInt(100)
+	assert.EqualValues(t, model.ErrCodeLockedAmountMismatch, errCode(verifyLockedAmount(bpr)))
+	bpr.LockedAmount = big.NewInt(3)
+	bpr.Locks = []*lockRequest{l1}
+	assert.EqualValues(t, model.ErrCodeLocksRootMismatch, errCode(verifyLockedAmount(bpr)))
+	bpr.Locks = []*lockRequest{l1, l1}
+	assert.EqualValues(t, model.ErrCodeInvalidBalanceProof, errCode(verifyLockedAmount(bpr)))
+	//有锁的时候必须提交锁,否则无法验证LockedAmount
+	bpr.Locks = nil
+	assert.EqualValues(t, model.ErrCodeLocksMissing, errCode(verifyLockedAmount(bpr)))
+	bpr.LockedAmount = big.NewInt(0)
+	assert.EqualValues(t, model.ErrCodeLocksMissing, errCode(verifyLockedAmount(bpr)))
+	//没有锁就不能有LockedAmount
+	bpr.BalanceProof.LocksRoot = utils.EmptyHash
+	assert.Nil(t, verifyLockedAmount(bpr))
+	bpr.LockedAmount = big.NewInt(3)
+	assert.EqualValues(t, model.ErrCodeLockedAmountMismatch, errCode(verifyLockedAmount(bpr)))
+}
+
+func TestBalanceProofErrorCode(t *testing.T) {
+	code, status := balanceProofErrorCode(blockchainlistener.ErrRequestTimeout)
+	assert.EqualValues(t, errCodeTimeout, code)
+	assert.EqualValues(t, http.StatusGatewayTimeout, status)
+	code, status = balanceProofErrorCode(&model.BalanceProofError{Code: model.ErrCodeNonceRegression})
+	assert.EqualValues(t, model.ErrCodeNonceRegression, code)
+	assert.EqualValues(t, http.StatusConflict, status)
+	code, status = balanceProofErrorCode(&model.BalanceProofError{Code: model.ErrCodeLocksMissing})
+	assert.EqualValues(t, model.ErrCodeLocksMissing, code)
+	assert.EqualValues(t, http.StatusBadRequest, status)
+	code, status = balanceProofErrorCode(&model.BalanceProofError{Code: model.ErrCodeChannelNotFound})
+	assert.EqualValues(t, model.ErrCodeChannelNotFound, code)
+	assert.EqualValues(t, http.StatusNotFound, status)
+	code, status = balanceProofErrorCode(errors.New("db error"))
+	assert.EqualValues(t, errCodeInternal, code)
+	assert.EqualValues(t, http.StatusInternalServerError, status)
+}
@@ -0,0 +1,247 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//转账失败的原因
+const (
+	feedbackReasonRefused = "refused" //下一跳拒绝了转账
+	feedbackReasonTimeout = "timeout" //下一跳超时没有响应
+)
+
+/*
+feedbackRequest 节点报告沿着pfs给出的路径转账的结果
+Path 和 /paths 返回的result一样,不包含peer_from,最后一个是target
+Success 为false时,FailedHop是Path中没有收到转账的节点的下标,
+也就是说 peer_from->Path[0] 这一跳失败时FailedHop为0.
+pfs只记录peer_from自己的通道 peer_from->Path[0] 的结果,只有FailedHop为0时才会惩罚.
+Timestamp 是报告的时间,与pfs的时间相差超过FeedbackMaxClockSkew的报告会被拒绝,
+同一个节点对同一条路径同一时间的报告只接受一次
+*/
+type feedbackRequest struct {
+	PeerFrom     common.Address   `json:"peer_from"`
+	TokenAddress common.Address   `json:"token_address"`
+	Path         []common.Address `json:"path"`
+	Success      bool             `json:"success"`
+	FailedHop    int              `json:"failed_hop"`
+	Reason       string           `json:"reason"`
+	Timestamp    int64            `json:"timestamp"` //unix秒
+	Signature    []byte           `json:"signature"`
+}
+
+//penaltyInfo 当前受到惩罚的通道方向
+type penaltyInfo struct {
+	ChannelID   common.Hash    `json:"channel_identifier"`
+	Participant common.Address `json:"participant"`
+	Token       common.Address `json:"token"`
+	Penalty     float64        `json:"penalty"`
+	PenalizedAt time.Time      `json:"penalized_at"`
+	Reason      string         `json:"reason"`
+	Reporter    common.Address `json:"reporter"`
+	Reports     int            `json:"reports"`
+}
+
+//feedbackData peer_from签名的数据
+func feedbackData(req *feedbackRequest, chainID *big.Int) []byte {
+	tmpBuf := new(bytes.Buffer)
+	tmpBuf.Write(req.PeerFrom[:])
+	tmpBuf.Write(req.TokenAddress[:])
+	for _, addr := range req.Path {
+		tmpBuf.Write(addr[:])
+	}
+	if req.Success {
+		tmpBuf.WriteByte(1)
+	} else {
+		tmpBuf.WriteByte(0)
+	}
+	binary.Write(tmpBuf, binary.BigEndian, int64(req.FailedHop))
+	tmpBuf.Write([]byte(req.Reason))
+	binary.Write(tmpBuf, binary.BigEndian, req.Timestamp)
+	tmpBuf.Write(utils.BigIntTo32Bytes(chainID))
+	return tmpBuf.Bytes()
+}
+
+//verifyFeedback 检查参数,时间以及peer_from的签名
+func verifyFeedback(req *feedbackRequest, chainID *big.Int, now time.Time) error {
+	if len(req.Path) == 0 {
+		return errors.New("empty path")
+	}
+	skew := now.Sub(time.Unix(req.Timestamp, 0))
+	if skew > params.FeedbackMaxClockSkew || skew < -params.FeedbackMaxClockSkew {
+		return fmt.Errorf("feedback timestamp %d too far from now", req.Timestamp)
+	}
+	if !req.Success {
+		if req.FailedHop < 0 || req.FailedHop >= len(req.Path) {
+			return fmt.Errorf("failed_hop %d out of range", req.FailedHop)
+		}
+		if req.Reason != feedbackReasonRefused && req.Reason != feedbackReasonTimeout {
+			return fmt.Errorf("unknown reason %s", req.Reason)
+		}
+	}
+	signer, err := utils.Ecrecover(utils.Sha3(feedbackData(req, chainID)), req.Signature)
+	if err != nil || signer != req.PeerFrom {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+var errFeedbackRateLimit = errors.New("too many feedbacks, try later")
+
+/*
+feedbackGuard 防止转账结果报告被重放或者被滥用.
+同一个节点对同一条路径同一时间的报告只接受一次,时间窗口以外的报告已经被verifyFeedback拒绝,
+所以只需要记住最近2*FeedbackMaxClockSkew内的报告;
+每个节点一分钟内最多报告FeedbackRateLimit次
+*/
+type feedbackGuard struct {
+	lock    sync.Mutex
+	seen    map[common.Hash]time.Time      //报告 -> 收到的时间
+	reports map[common.Address][]time.Time //节点 -> 最近一分钟内每次报告的时间
+}
+
+var feedbacks = newFeedbackGuard()
+
+func newFeedbackGuard() *feedbackGuard {
+	return &feedbackGuard{
+		seen:    make(map[common.Hash]time.Time),
+		reports: make(map[common.Address][]time.Time),
+	}
+}
+
+//feedbackKey 去重用的key,与结果无关,同一时间不能对一条路径既报告成功又报告失败
+func feedbackKey(req *feedbackRequest, chainID *big.Int) common.Hash {
+	tmpBuf := new(bytes.Buffer)
+	tmpBuf.Write(req.PeerFrom[:])
+	tmpBuf.Write(req.TokenAddress[:])
+	for _, addr := range req.Path {
+		tmpBuf.Write(addr[:])
+	}
+	binary.Write(tmpBuf, binary.BigEndian, req.Timestamp)
+	tmpBuf.Write(utils.BigIntTo32Bytes(chainID))
+	return utils.Sha3(tmpBuf.Bytes())
+}
+
+//accept 检查是否重放以及是否超过频率限制,通过时记录下来
+func (g *feedbackGuard) accept(req *feedbackRequest, chainID *big.Int, now time.Time) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.expire(now)
+	key := feedbackKey(req, chainID)
+	if _, ok := g.seen[key]; ok {
+		return errors.New("duplicate feedback")
+	}
+	if len(g.reports[req.PeerFrom]) >= params.FeedbackRateLimit {
+		return errFeedbackRateLimit
+	}
+	g.seen[key] = now
+	g.reports[req.PeerFrom] = append(g.reports[req.PeerFrom], now)
+	return nil
+}
+
+//expire 删除已经不需要的记录
+func (g *feedbackGuard) expire(now time.Time) {
+	for key, t := range g.seen {
+		if now.Sub(t) > 2*params.FeedbackMaxClockSkew {
+			delete(g.seen, key)
+		}
+	}
+	for addr, ts := range g.reports {
+		i := 0
+		for i < len(ts) && now.Sub(ts[i]) >= time.Minute {
+			i++
+		}
+		if i == len(ts) {
+			delete(g.reports, addr)
+		} else {
+			g.reports[addr] = ts[i:]
+		}
+	}
+}
+
+// postFeedback report the result of a transfer along a path, implements POST /feedback
+func postFeedback(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	var req feedbackRequest
+	err := r.DecodeJsonPayload(&req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	err = verifyFeedback(&req, ce.ChainID(), now)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = feedbacks.accept(&req, ce.ChainID(), now)
+	if err == errFeedbackRateLimit {
+		rest.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path := append([]common.Address{req.PeerFrom}, req.Path...)
+	failedHop := req.FailedHop
+	if req.Success {
+		failedHop = -1
+	}
+	err = ce.TokenNetwork.HandlePathFeedback(req.TokenAddress, req.PeerFrom, path, failedHop, req.Reason)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// getPenalties list channel directions currently penalized by feedback, implements GET /admin/penalties
+func getPenalties(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	ps, err := ce.DB().GetChannelPenalties()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	infos := []*penaltyInfo{}
+	for _, p := range ps {
+		v := p.Value(now)
+		if v < params.MinChannelPenalty {
+			continue
+		}
+		infos = append(infos, &penaltyInfo{
+			ChannelID:   common.HexToHash(p.ChannelID),
+			Participant: common.HexToAddress(p.Participant),
+			Token:       common.HexToAddress(p.Token),
+			Penalty:     v,
+			PenalizedAt: p.PenalizedAt,
+			Reason:      p.Reason,
+			Reporter:    common.HexToAddress(p.Reporter),
+			Reports:     p.Reports,
+		})
+	}
+	err = w.WriteJson(infos)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyFeedback(t *testing.T) {
+	ast := assert.New(t)
+	chainID := big.NewInt(8888)
+	key, addr := utils.MakePrivateKeyAddress()
+	now := time.Now()
+	req := &feedbackRequest{
+		Timestamp:    now.Unix(),
+		PeerFrom:     addr,
+		TokenAddress: utils.NewRandomAddress(),
+		Path:         []common.Address{utils.NewRandomAddress(), utils.NewRandomAddress()},
+		FailedHop:    1,
+		Reason:       feedbackReasonTimeout,
+	}
+	sign := func() {
+		var err error
+		req.Signature, err = utils.SignData(key, feedbackData(req, chainID))
+		ast.Nil(err)
+	}
+	sign()
+	ast.Nil(verifyFeedback(req, chainID, now))
+	//其他链上的签名不能用
+	ast.NotNil(verifyFeedback(req, big.NewInt(1), now))
+	//签名以后修改了内容
+	req.FailedHop = 0
+	ast.NotNil(verifyFeedback(req, chainID, now))
+	sign()
+	ast.Nil(verifyFeedback(req, chainID, now))
+
+	req.FailedHop = 2
+	sign()
+	ast.NotNil(verifyFeedback(req, chainID, now))
+	req.FailedHop = 1
+	req.Reason = "unknown"
+	sign()
+	ast.NotNil(verifyFeedback(req, chainID, now))
+	//成功时不需要failed_hop和reason
+	req.Success = true
+	sign()
+	ast.Nil(verifyFeedback(req, chainID, now))
+	req.Path = nil
+	sign()
+	ast.NotNil(verifyFeedback(req, chainID, now))
+	//时间不对的报告认为是重放
+	req.Path = []common.Address{utils.NewRandomAddress()}
+	sign()
+	ast.Nil(verifyFeedback(req, chainID, now))
+	ast.NotNil(verifyFeedback(req, chainID, now.Add(params.FeedbackMaxClockSkew+time.Second)))
+	ast.NotNil(verifyFeedback(req, chainID, now.Add(-params.FeedbackMaxClockSkew-time.Second)))
+	//签名以后修改了时间
+	req.Timestamp++
+	ast.NotNil(verifyFeedback(req, chainID, now))
+}
+
+func TestFeedbackGuard(t *testing.T) {
+	ast := assert.New(t)
+	chainID := big.NewInt(8888)
+	g := newFeedbackGuard()
+	now := time.Now()
+	req := &feedbackRequest{
+		PeerFrom:     utils.NewRandomAddress(),
+		TokenAddress: utils.NewRandomAddress(),
+		Path:         []common.Address{utils.NewRandomAddress()},
+		Timestamp:    now.Unix(),
+	}
+	ast.Nil(g.accept(req, chainID, now))
+	//同一时间同一条路径的报告只接受一次,不管结果是什么
+	ast.NotNil(g.accept(req, chainID, now))
+	req.Success = true
+	ast.NotNil(g.accept(req, chainID, now))
+	//其他链上的报告不受影响
+	ast.Nil(g.accept(req, big.NewInt(1), now))
+
+	for i := 2; i < params.FeedbackRateLimit; i++ {
+		req.Timestamp++
+		ast.Nil(g.accept(req, chainID, now))
+	}
+	req.Timestamp++
+	ast.Equal(errFeedbackRateLimit, g.accept(req, chainID, now))
+	//其他节点不受影响
+	other := *req
+	other.PeerFrom = utils.NewRandomAddress()
+	ast.Nil(g.accept(&other, chainID, now))
+	//一分钟以后可以继续报告
+	ast.Nil(g.accept(req, chainID, now.Add(time.Minute)))
+	//过期的记录会被删除
+	g.expire(now.Add(time.Minute + 3*params.FeedbackMaxClockSkew))
+	ast.Equal(0, len(g.seen))
+	ast.Equal(0, len(g.reports))
+}
@@ -34,6 +34,10 @@ func verifyAndGetFeePolicy(req *model.SetFeeRateRequest) (policy int, err error)
 
 // setChannelRate save request data of set_fee_rate
 func setChannelRate(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
 	peerAddress := common.HexToAddress(r.PathParam("peer"))
 	channel := common.HexToHash(r.PathParam("channel"))
 	var req model.SetFeeRateRequest
@@ -58,7 +62,7 @@ func setChannelRate(w rest.ResponseWriter, r *rest.Request) {
 		FeePercent:  req.FeePercent,
 		FeeConstant: req.FeeConstant,
 	}
-	err = tn.UpdateChannelFeeRate(channel, peerAddress, fee)
+	err = ce.TokenNetwork.UpdateChannelFeeRate(channel, peerAddress, fee)
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -71,15 +75,18 @@ func setChannelRate(w rest.ResponseWriter, r *rest.Request) {
 
 // getChannelFeeRate reponse fee_rate data
 func getChannelRate(w rest.ResponseWriter, r *rest.Request) {
-
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
 	peerAddress := common.HexToAddress(r.PathParam("peer"))
 	channelID := common.HexToHash(r.PathParam("channel"))
-	c, err := model.GetChannel(channelID.String())
+	c, err := ce.DB().GetChannel(channelID.String())
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	fee := model.GetChannelFeeRate(channelID, peerAddress, common.HexToAddress(c.Token))
+	fee := ce.DB().GetChannelFeeRate(channelID, peerAddress, common.HexToAddress(c.Token))
 	err = w.WriteJson(fee)
 	if err != nil {
 		log.Error(fmt.Sprintf("write json err %s", err))
@@ -88,6 +95,10 @@ func getChannelRate(w rest.ResponseWriter, r *rest.Request) {
 }
 
 func setTokenRate(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
 	peerAddress := common.HexToAddress(r.PathParam("peer"))
 	token := common.HexToAddress(r.PathParam("token"))
 	var req model.SetFeeRateRequest
@@ -112,7 +123,7 @@ func setTokenRate(w rest.ResponseWriter, r *rest.Request) {
 		FeePercent:  req.FeePercent,
 		FeeConstant: req.FeeConstant,
 	}
-	err = model.UpdateAccountTokenFee(peerAddress, token, fee)
+	err = ce.DB().UpdateAccountTokenFee(peerAddress, token, fee)
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -123,9 +134,13 @@ func setTokenRate(w rest.ResponseWriter, r *rest.Request) {
 	}
 }
 func getTokenRate(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
 	peerAddress := common.HexToAddress(r.PathParam("peer"))
 	token := common.HexToAddress(r.PathParam("token"))
-	fee, err := model.GetAccountTokenFee(peerAddress, token)
+	fee, err := ce.DB().GetAccountTokenFee(peerAddress, token)
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -138,6 +153,10 @@ func getTokenRate(w rest.ResponseWriter, r *rest.Request) {
 	return
 }
 func setAccountRate(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
 	peerAddress := common.HexToAddress(r.PathParam("peer"))
 
 	var req model.SetFeeRateRequest
@@ -162,7 +181,7 @@ func setAccountRate(w rest.ResponseWriter, r *rest.Request) {
 		FeePercent:  req.FeePercent,
 		FeeConstant: req.FeeConstant,
 	}
-	err = model.UpdateAccountDefaultFeePolicy(peerAddress, fee)
+	err = ce.DB().UpdateAccountDefaultFeePolicy(peerAddress, fee)
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -173,9 +192,13 @@ func setAccountRate(w rest.ResponseWriter, r *rest.Request) {
 	}
 }
 func getAccountRate(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
 	peerAddress := common.HexToAddress(r.PathParam("peer"))
 
-	fee := model.GetAccountFeePolicy(peerAddress)
+	fee := ce.DB().GetAccountFeePolicy(peerAddress)
 
 	err := w.WriteJson(fee)
 	if err != nil {
@@ -200,6 +223,10 @@ func verifySetFeeRate(f *model.SetFeeRateRequest, peerAddress common.Address) (f
 	return
 }
 func setAllFeeRate(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
 	peerAddress := common.HexToAddress(r.PathParam("peer"))
 
 	var req model.SetAllFeeRateRequest
@@ -236,34 +263,34 @@ func setAllFeeRate(w rest.ResponseWriter, r *rest.Request) {
 		}
 	}
 	//务必先删除所有的记录,否则记录就变成只能增加修改,不能删除了.
-	err=model.DeleteAccountAllFeeRate(peerAddress)
+	err=ce.DB().DeleteAccountAllFeeRate(peerAddress)
 	if err!=nil{
 		rest.Error(w,err.Error(),http.StatusBadRequest)
 		return
 	}
 	//save fee rate to db
 	if req.AccountFee != nil {
-		err = model.UpdateAccountDefaultFeePolicy(peerAddress, req.AccountFee.Fee)
+		err = ce.DB().UpdateAccountDefaultFeePolicy(peerAddress, req.AccountFee.Fee)
 		if err != nil {
 			rest.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 	for t, f := range req.TokensFee {
-		err = model.UpdateAccountTokenFee(peerAddress, t, f.Fee)
+		err = ce.DB().UpdateAccountTokenFee(peerAddress, t, f.Fee)
 		if err != nil {
 			rest.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 	for c, f := range req.ChannelsFee {
-		err = tn.UpdateChannelFeeRate(c, peerAddress, f.Fee)
+		err = ce.TokenNetwork.UpdateChannelFeeRate(c, peerAddress, f.Fee)
 		if err != nil {
 			rest.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
-	tn.UpdateAccountFee(peerAddress,&req)
+	ce.TokenNetwork.UpdateAccountFee(peerAddress,&req)
 	err = w.WriteJson(&req)
 	if err != nil {
 		log.Error(fmt.Sprintf("write json err %s", err))
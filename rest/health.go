@@ -0,0 +1,97 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//startTime pfs启动的时间
+var startTime = time.Now()
+
+type healthInfo struct {
+	Status string `json:"status"`
+	Uptime int64  `json:"uptime"` //seconds
+}
+
+// getHealth pfs进程在运行并且能够响应http请求,不检查依赖的服务,implements GET /pfs/1/health
+func getHealth(w rest.ResponseWriter, r *rest.Request) {
+	err := w.WriteJson(&healthInfo{
+		Status: "ok",
+		Uptime: int64(time.Since(startTime).Seconds()),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+type readyInfo struct {
+	Ready    bool                            `json:"ready"`
+	Networks []*blockchainlistener.Readiness `json:"networks"`
+}
+
+/*
+getReady 是否可以提供路由服务,不能时返回503,implements GET /pfs/1/ready.
+没有指定chain id时检查所有网络,所有网络都可用才返回200
+*/
+func getReady(w rest.ResponseWriter, r *rest.Request) {
+	var ces []*blockchainlistener.ChainEvents
+	if _, ok := r.PathParams["chain_id"]; ok {
+		ce, ok := getNetwork(w, r)
+		if !ok {
+			return
+		}
+		ces = append(ces, ce)
+	} else {
+		for _, ce := range networks {
+			ces = append(ces, ce)
+		}
+	}
+	info := &readyInfo{Ready: true}
+	for _, ce := range ces {
+		readiness := ce.Readiness()
+		info.Ready = info.Ready && readiness.Ready
+		info.Networks = append(info.Networks, readiness)
+	}
+	sort.Slice(info.Networks, func(i, j int) bool {
+		return info.Networks[i].ChainID < info.Networks[j].ChainID
+	})
+	if !info.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := w.WriteJson(info)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+type versionInfo struct {
+	Version         string         `json:"version"`
+	GitCommit       string         `json:"git_commit,omitempty"`
+	ChainID         int64          `json:"chain_id"`
+	RegistryAddress common.Address `json:"registry_address"`
+}
+
+// getVersion returns build version, chain id and registry address of the network, implements GET /pfs/1/version
+func getVersion(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	err := w.WriteJson(&versionInfo{
+		Version:         params.Version,
+		GitCommit:       params.GitCommit,
+		ChainID:         ce.ChainID().Int64(),
+		RegistryAddress: ce.RegistryAddress(),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
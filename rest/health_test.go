@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthAndReady(t *testing.T) {
+	ast := assert.New(t)
+	networks = make(map[int64]*blockchainlistener.ChainEvents)
+	api := rest.NewApi()
+	router, err := rest.MakeRouter(
+		rest.Get("/pfs/1/health", getHealth),
+		rest.Get("/pfs/1/ready", getReady),
+		rest.Get(chainPrefix+"/ready", getReady),
+	)
+	if !ast.Nil(err) {
+		return
+	}
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	r := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/pfs/1/health", nil))
+	r.CodeIs(200)
+	var h healthInfo
+	ast.Nil(r.DecodeJsonPayload(&h))
+	ast.Equal("ok", h.Status)
+
+	//没有网络的时候认为可用
+	r = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/pfs/1/ready", nil))
+	r.CodeIs(200)
+	var ready readyInfo
+	ast.Nil(r.DecodeJsonPayload(&ready))
+	ast.True(ready.Ready)
+
+	r = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/pfs/1/chain/8888/ready", nil))
+	r.CodeIs(404)
+}
+
+func TestLocalOnly(t *testing.T) {
+	api := rest.NewApi()
+	router, err := rest.MakeRouter(
+		rest.Get("/pfs/1/admin/test", localOnly(func(w rest.ResponseWriter, r *rest.Request) {
+			w.WriteJson("ok")
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.SetApp(router)
+	handler := api.MakeHandler()
+	for addr, code := range map[string]int{
+		"127.0.0.1:3000": http.StatusOK,
+		"[::1]:3000":     http.StatusOK,
+		"10.0.0.1:3000":  http.StatusForbidden,
+		"":               http.StatusForbidden,
+	} {
+		req := test.MakeSimpleRequest("GET", "http://localhost/pfs/1/admin/test", nil)
+		req.RemoteAddr = addr
+		test.RunRequest(t, handler, req).CodeIs(code)
+	}
+}
@@ -1,25 +1,46 @@
 package rest
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
 
 	"github.com/SmartMeshFoundation/Photon-Path-Finder/params"
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
 )
 
-var ce *blockchainlistener.ChainEvents
-var tn *blockchainlistener.TokenNetwork
+//networks 每个registry合约对应一个ChainEvents,以chain id区分
+var networks map[int64]*blockchainlistener.ChainEvents
+
+//defaultNetwork 路径中没有指定chain id时使用的网络,兼容只有一个网络时的接口
+var defaultNetwork *blockchainlistener.ChainEvents
+
+//server http服务,Shutdown时停止接受新的请求
+var server *http.Server
+var serverLock sync.Mutex
+var shutdown bool
+
+// chainPrefix 所有接口都可以在路径中指定chain id,比如/pfs/1/chain/8888/paths
+const chainPrefix = "/pfs/1/chain/:chain_id"
 
 /*
-Start the restful server
+Start the restful server, the first one of ces is the default network.
+blocks until Shutdown is called
 */
-func Start(e *blockchainlistener.ChainEvents, t *blockchainlistener.TokenNetwork) {
-	ce = e
-	tn = t
+func Start(ces []*blockchainlistener.ChainEvents) {
+	networks = make(map[int64]*blockchainlistener.ChainEvents)
+	for _, ce := range ces {
+		networks[ce.ChainID().Int64()] = ce
+	}
+	defaultNetwork = ces[0]
 	api := rest.NewApi()
 	if params.DebugMode {
 		api.Use(rest.DefaultDevStack...)
@@ -27,22 +48,141 @@ func Start(e *blockchainlistener.ChainEvents, t *blockchainlistener.TokenNetwork
 		api.Use(rest.DefaultProdStack...)
 	}
 
-	router, err := rest.MakeRouter(
+	var routes []*rest.Route
+	for _, route := range []*rest.Route{
 		//peer 提交Partner的BalanceProof,更新Partner的余额
-		rest.Put("/pfs/1/:peer/balance", UpdateBalanceProof),
-		rest.Put("/pfs/1/channel_rate/:channel/:peer", setChannelRate),
-		rest.Get("/pfs/1/channel_rate/:channel/:peer", getChannelRate),
-		rest.Put("/pfs/1/token_rate/:token/:peer", setTokenRate),
-		rest.Get("/pfs/1/token_rate/:token/:peer", getTokenRate),
-		rest.Put("/pfs/1/account_rate/:peer", setAccountRate),
-		rest.Get("/pfs/1/account_rate/:peer", getAccountRate),
-		rest.Put("/pfs/1/feerate/:peer", setAllFeeRate),
-		rest.Post("/pfs/1/paths", GetPaths),
-	)
+		rest.Put("/:peer/balance", UpdateBalanceProof),
+		//一次提交多个通道的BalanceProof
+		rest.Put("/:peer/balances", UpdateBalanceProofs),
+		//使用http心跳发现节点上下线时,节点定时报告自己在线
+		rest.Put("/:peer/presence", putPresence),
+		rest.Put("/channel_rate/:channel/:peer", setChannelRate),
+		rest.Get("/channel_rate/:channel/:peer", getChannelRate),
+		rest.Put("/token_rate/:token/:peer", setTokenRate),
+		rest.Get("/token_rate/:token/:peer", getTokenRate),
+		rest.Put("/account_rate/:peer", setAccountRate),
+		rest.Get("/account_rate/:peer", getAccountRate),
+		rest.Put("/feerate/:peer", setAllFeeRate),
+		rest.Post("/paths", GetPaths),
+		rest.Get("/capacity", getCapacity),
+		//收款方查询收款能力和路由提示
+		rest.Get("/nodes/:address/inbound", getInbound),
+		//节点报告转账结果,失败的通道方向会受到惩罚
+		rest.Post("/feedback", postFeedback),
+		//管理接口只允许本机访问
+		rest.Get("/admin/penalties", localOnly(getPenalties)),
+		//上下线发现服务的连接状态
+		rest.Get("/admin/presence", localOnly(getPresenceHealth)),
+		rest.Get("/sync", getSyncProgress),
+		//负载均衡检查是否可以提供服务
+		rest.Get("/ready", getReady),
+		rest.Get("/version", getVersion),
+		//只读的查询接口,查看pfs中的通道状态
+		rest.Get("/channels/:channel", getChannel),
+		rest.Get("/nodes/:address/channels", getNodeChannels),
+		//节点上下线状态,包括下线宽限期和抖动抑制
+		rest.Get("/nodes/:address/status", getNodeStatus),
+		rest.Get("/tokens/:token/channels", getTokenChannels),
+	} {
+		routes = append(routes, &rest.Route{
+			HttpMethod: route.HttpMethod,
+			PathExp:    "/pfs/1" + route.PathExp,
+			Func:       route.Func,
+		}, &rest.Route{
+			HttpMethod: route.HttpMethod,
+			PathExp:    chainPrefix + route.PathExp,
+			Func:       route.Func,
+		})
+	}
+	routes = append(routes, rest.Get("/pfs/1/networks", getNetworks), rest.Get("/pfs/1/health", getHealth))
+	router, err := rest.MakeRouter(routes...)
 	if err != nil {
 		log.Crit(fmt.Sprintf("maker router :%s", err))
 	}
 	api.SetApp(router)
-	listen := fmt.Sprintf("0.0.0.0:%d", params.Port)
-	log.Crit(fmt.Sprintf("http listen and serve :%s", http.ListenAndServe(listen, api.MakeHandler())))
+	serverLock.Lock()
+	if shutdown {
+		serverLock.Unlock()
+		return
+	}
+	server = &http.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%d", params.Port),
+		Handler: api.MakeHandler(),
+	}
+	serverLock.Unlock()
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Crit(fmt.Sprintf("http listen and serve :%s", err))
+	}
+}
+
+/*
+Shutdown stop accepting new requests and wait for active requests to finish until ctx is done.
+Start returns after Shutdown is called
+*/
+func Shutdown(ctx context.Context) error {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	shutdown = true
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// localOnly responds 403 unless the request comes from localhost
+func localOnly(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			rest.Error(w, "only available from localhost", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// getNetwork returns the network specified by chain_id in path, or the default network.
+// responds 404 when the chain id is unknown
+func getNetwork(w rest.ResponseWriter, r *rest.Request) (ce *blockchainlistener.ChainEvents, ok bool) {
+	chainID, ok := r.PathParams["chain_id"]
+	if !ok {
+		return defaultNetwork, true
+	}
+	id, err := strconv.ParseInt(chainID, 10, 64)
+	if err == nil {
+		ce, ok = networks[id]
+	}
+	if err != nil || !ok {
+		rest.Error(w, fmt.Sprintf("unknown chain id %s", chainID), http.StatusNotFound)
+		return nil, false
+	}
+	return
+}
+
+type networkInfo struct {
+	ChainID         int64                           `json:"chain_id"`
+	RegistryAddress common.Address                  `json:"registry_address"`
+	Default         bool                            `json:"default"`
+	Sync            blockchainlistener.SyncProgress `json:"sync"`
+}
+
+// getNetworks list all the networks this pfs works on
+func getNetworks(w rest.ResponseWriter, r *rest.Request) {
+	var infos []*networkInfo
+	for id, ce := range networks {
+		infos = append(infos, &networkInfo{
+			ChainID:         id,
+			RegistryAddress: ce.RegistryAddress(),
+			Default:         ce == defaultNetwork,
+			Sync:            ce.SyncProgress(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ChainID < infos[j].ChainID
+	})
+	err := w.WriteJson(infos)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
 }
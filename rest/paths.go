@@ -5,6 +5,7 @@ import (
 	"math/big"
 	"net/http"
 
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
 	"github.com/nkbai/goutils"
 
 	"github.com/SmartMeshFoundation/Photon/log"
@@ -23,10 +24,32 @@ type pathRequest struct {
 	SortDemand        string         `json:"sort_demand"`
 	Signature         []byte
 	PeerFromChargeFee bool `json:"peer_from_charge_fee"`
+	//路由提示,不能经过的节点和通道,以及必须依次经过的节点
+	ExcludeNodes    []common.Address `json:"exclude_nodes,omitempty"`
+	ExcludeChannels []common.Hash    `json:"exclude_channels,omitempty"`
+	ViaNodes        []common.Address `json:"via_nodes,omitempty"`
+	//最多经过多少个中间节点,0表示不限制
+	MaxHops int `json:"max_hops,omitempty"`
+	//最多收取多少费用,不指定表示不限制
+	MaxFee *big.Int `json:"max_fee,omitempty"`
 }
 
-// GetPaths handle the request with GetPaths,implements POST /paths
+// pathDiagnosticsResponse 诊断模式下的返回结果,无论是否找到路径都返回诊断信息
+type pathDiagnosticsResponse struct {
+	Paths       []*blockchainlistener.PathResult    `json:"paths"`
+	Error       string                              `json:"error,omitempty"`
+	Diagnostics *blockchainlistener.PathDiagnostics `json:"diagnostics"`
+}
+
+/*
+GetPaths handle the request with GetPaths,implements POST /paths
+POST /paths?diagnose=true 时返回pathDiagnosticsResponse,解释为什么找不到路径
+*/
 func GetPaths(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok || !checkSynced(w, ce) {
+		return
+	}
 	var req pathRequest
 	err := r.DecodeJsonPayload(&req)
 	if err != nil {
@@ -39,8 +62,46 @@ func GetPaths(w rest.ResponseWriter, r *rest.Request) {
 	var limitPaths = req.LimitPaths
 	var sendAmount = req.SendAmount
 	var sortDemand = req.SortDemand
-	pathResult, err := tn.GetPaths(peerFrom, peerTo, tokenAddress, sendAmount, limitPaths, sortDemand, req.PeerFromChargeFee)
+	if sendAmount == nil || sendAmount.Sign() < 0 {
+		rest.Error(w, "invalid send_amount", http.StatusBadRequest)
+		return
+	}
+	if req.MaxHops < 0 {
+		rest.Error(w, "invalid max_hops", http.StatusBadRequest)
+		return
+	}
+	if req.MaxFee != nil && req.MaxFee.Sign() < 0 {
+		rest.Error(w, "invalid max_fee", http.StatusBadRequest)
+		return
+	}
+	//路由提示和限制都是签名的一部分,不能被别人修改
+	err = verifySinaturePaths(&req, peerFrom)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pathResult, err := ce.TokenNetwork.GetPathsWithConstraints(peerFrom, peerTo, tokenAddress, sendAmount, limitPaths, sortDemand, req.PeerFromChargeFee, &blockchainlistener.PathConstraints{
+		ExcludeNodes:    req.ExcludeNodes,
+		ExcludeChannels: req.ExcludeChannels,
+		ViaNodes:        req.ViaNodes,
+		MaxHops:         req.MaxHops,
+		MaxFee:          req.MaxFee,
+	})
 	log.Trace(fmt.Sprintf("GetPaths err=%s,result=%s", err, utils.StringInterface(pathResult, 3)))
+	if r.URL.Query().Get("diagnose") == "true" {
+		resp := &pathDiagnosticsResponse{
+			Paths:       pathResult,
+			Diagnostics: ce.TokenNetwork.DiagnosePaths(peerFrom, peerTo, tokenAddress, sendAmount),
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		err = w.WriteJson(resp)
+		if err != nil {
+			log.Error(fmt.Sprintf("write json err %s", err))
+		}
+		return
+	}
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -50,3 +111,63 @@ func GetPaths(w rest.ResponseWriter, r *rest.Request) {
 		log.Error(fmt.Sprintf("write json err %s", err))
 	}
 }
+
+/*
+getCapacity 查询peer_from当前最多能给peer_to转多少钱,
+implements GET /capacity?peer_from=0x...&peer_to=0x...&token_address=0x...
+*/
+func getCapacity(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok || !checkSynced(w, ce) {
+		return
+	}
+	q := r.URL.Query()
+	for _, name := range []string{"peer_from", "peer_to", "token_address"} {
+		if !common.IsHexAddress(q.Get(name)) {
+			rest.Error(w, fmt.Sprintf("invalid %s", name), http.StatusBadRequest)
+			return
+		}
+	}
+	c, err := ce.TokenNetwork.GetCapacity(common.HexToAddress(q.Get("peer_from")), common.HexToAddress(q.Get("peer_to")), common.HexToAddress(q.Get("token_address")))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(c)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+/*
+getInbound 查询address作为收款方的收款能力以及推荐的路由提示,
+implements GET /nodes/:address/inbound?token_address=0x...&amount=100
+*/
+func getInbound(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok || !checkSynced(w, ce) {
+		return
+	}
+	q := r.URL.Query()
+	if !common.IsHexAddress(q.Get("token_address")) {
+		rest.Error(w, "invalid token_address", http.StatusBadRequest)
+		return
+	}
+	amount := new(big.Int)
+	if s := q.Get("amount"); len(s) > 0 {
+		_, ok = amount.SetString(s, 10)
+		if !ok || amount.Sign() < 0 {
+			rest.Error(w, "invalid amount", http.StatusBadRequest)
+			return
+		}
+	}
+	in, err := ce.TokenNetwork.GetInbound(common.HexToAddress(r.PathParam("address")), common.HexToAddress(q.Get("token_address")), amount)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(in)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/model"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestPathsHandler 只有一个通道addr1-addr2的网络,addr1存了100,双方都在线
+func newTestPathsHandler(t *testing.T) (handler http.Handler, key *ecdsa.PrivateKey, addr1, addr2, token common.Address) {
+	db := model.SetupTestDB()
+	key, addr1 = utils.MakePrivateKeyAddress()
+	addr2 = utils.NewRandomAddress()
+	token = utils.NewRandomAddress()
+	channelID := utils.NewRandomHash()
+	_, err := db.AddChannel(token, addr1, addr2, channelID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UpdateChannelDeposit(channelID, addr1, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	presence := blockchainlistener.NewMemoryPresence()
+	tn, err := blockchainlistener.NewTokenNetwork(db, map[common.Address]common.Address{token: utils.NewRandomAddress()},
+		utils.NewRandomAddress(), map[common.Address]int{token: 0}, blockchainlistener.WithPresence(presence.Factory()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	presence.Online(addr1, "other")
+	presence.Online(addr2, "other")
+	networks = make(map[int64]*blockchainlistener.ChainEvents)
+	defaultNetwork = &blockchainlistener.ChainEvents{TokenNetwork: tn}
+	api := rest.NewApi()
+	router, err := rest.MakeRouter(rest.Post("/pfs/1/paths", GetPaths))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.SetApp(router)
+	return api.MakeHandler(), key, addr1, addr2, token
+}
+
+func TestGetPathsSignature(t *testing.T) {
+	ast := assert.New(t)
+	handler, key, addr1, addr2, token := newTestPathsHandler(t)
+	req := &pathRequest{
+		PeerFrom:     addr1,
+		PeerTo:       addr2,
+		TokenAddress: token,
+		LimitPaths:   5,
+		SendAmount:   big.NewInt(10),
+		ExcludeNodes: []common.Address{utils.NewRandomAddress()},
+	}
+	sign := func() {
+		var err error
+		req.Signature, err = utils.SignData(key, pathRequestData(req))
+		ast.Nil(err)
+	}
+	post := func() *test.Recorded {
+		return test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/pfs/1/paths", req))
+	}
+	rejected := func() {
+		r := post()
+		r.CodeIs(http.StatusBadRequest)
+		ast.Contains(r.Recorder.Body.String(), "Invalid signature")
+	}
+	sign()
+	post().CodeIs(http.StatusOK)
+
+	//签名以后修改了路由提示
+	req.ExcludeNodes = []common.Address{addr2}
+	rejected()
+	sign()
+	req.ExcludeNodes = nil
+	rejected()
+	sign()
+	req.ViaNodes = []common.Address{utils.NewRandomAddress()}
+	rejected()
+	sign()
+	req.ExcludeChannels = []common.Hash{utils.NewRandomHash()}
+	rejected()
+
+	//用别人的身份查询
+	req.ExcludeChannels = nil
+	req.ViaNodes = nil
+	sign()
+	post().CodeIs(http.StatusOK)
+	req.PeerFrom = utils.NewRandomAddress()
+	rejected()
+	req.PeerFrom = addr1
+	req.Signature = nil
+	rejected()
+}
+
+//最多跳数和最多费用也是签名的一部分
+func TestGetPathsSignatureLimits(t *testing.T) {
+	ast := assert.New(t)
+	handler, key, addr1, addr2, token := newTestPathsHandler(t)
+	req := &pathRequest{
+		PeerFrom:     addr1,
+		PeerTo:       addr2,
+		TokenAddress: token,
+		LimitPaths:   5,
+		SendAmount:   big.NewInt(10),
+		MaxHops:      3,
+		MaxFee:       big.NewInt(0),
+	}
+	sign := func() {
+		var err error
+		req.Signature, err = utils.SignData(key, pathRequestData(req))
+		ast.Nil(err)
+	}
+	post := func() *test.Recorded {
+		return test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/pfs/1/paths", req))
+	}
+	rejected := func() {
+		r := post()
+		r.CodeIs(http.StatusBadRequest)
+		ast.Contains(r.Recorder.Body.String(), "Invalid signature")
+	}
+	sign()
+	post().CodeIs(http.StatusOK)
+	req.MaxFee = big.NewInt(1000)
+	rejected()
+	req.MaxFee = nil
+	rejected()
+	req.MaxFee = big.NewInt(0)
+	req.MaxHops = 10
+	rejected()
+	req.MaxHops = 0
+	rejected()
+	req.MaxHops = 3
+	post().CodeIs(http.StatusOK)
+}
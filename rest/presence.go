@@ -0,0 +1,115 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//heartbeatRequest 节点定时发送的心跳
+type heartbeatRequest struct {
+	DeviceType             string `json:"device_type"`
+	IgnoreMediatedTransfer bool   `json:"ignore_mediated_transfer"`
+	Timestamp              int64  `json:"timestamp"` //unix秒
+	Signature              []byte `json:"signature"`
+}
+
+//heartbeatData peer签名的数据
+func heartbeatData(req *heartbeatRequest, chainID *big.Int) []byte {
+	tmpBuf := new(bytes.Buffer)
+	tmpBuf.Write([]byte(req.DeviceType))
+	if req.IgnoreMediatedTransfer {
+		tmpBuf.WriteByte(1)
+	} else {
+		tmpBuf.WriteByte(0)
+	}
+	binary.Write(tmpBuf, binary.BigEndian, req.Timestamp)
+	tmpBuf.Write(utils.BigIntTo32Bytes(chainID))
+	return tmpBuf.Bytes()
+}
+
+func verifyHeartbeat(req *heartbeatRequest, peer common.Address, chainID *big.Int) error {
+	signer, err := utils.Ecrecover(utils.Sha3(heartbeatData(req, chainID)), req.Signature)
+	if err != nil || signer != peer {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// putPresence peer reports it is online, implements PUT /:peer/presence
+func putPresence(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	peer := common.HexToAddress(r.PathParam("peer"))
+	var req heartbeatRequest
+	err := r.DecodeJsonPayload(&req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = verifyHeartbeat(&req, peer, ce.ChainID())
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = ce.TokenNetwork.Heartbeat(peer, &blockchainlistener.Heartbeat{
+		DeviceType:             req.DeviceType,
+		IgnoreMediatedTransfer: req.IgnoreMediatedTransfer,
+		Timestamp:              req.Timestamp,
+	})
+	if err == blockchainlistener.ErrHeartbeatNotEnabled {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// getNodeStatus returns node state used by path finding, implements GET /nodes/:address/status
+func getNodeStatus(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	address := common.HexToAddress(r.PathParam("address"))
+	state, ok := ce.TokenNetwork.GetNodeState(address)
+	if !ok {
+		rest.Error(w, fmt.Sprintf("node %s not found", address.String()), http.StatusNotFound)
+		return
+	}
+	err := w.WriteJson(state)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+// getPresenceHealth returns status of node presence backends, implements GET /admin/presence
+func getPresenceHealth(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	h := ce.TokenNetwork.PresenceHealth()
+	if h == nil {
+		rest.Error(w, "presence health not supported", http.StatusNotFound)
+		return
+	}
+	err := w.WriteJson(h)
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
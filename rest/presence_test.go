@@ -0,0 +1,28 @@
+package rest
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyHeartbeat(t *testing.T) {
+	ast := assert.New(t)
+	chainID := big.NewInt(8888)
+	key, addr := utils.MakePrivateKeyAddress()
+	req := &heartbeatRequest{
+		DeviceType: "other",
+		Timestamp:  time.Now().Unix(),
+	}
+	var err error
+	req.Signature, err = utils.SignData(key, heartbeatData(req, chainID))
+	ast.Nil(err)
+	ast.Nil(verifyHeartbeat(req, addr, chainID))
+	ast.NotNil(verifyHeartbeat(req, utils.NewRandomAddress(), chainID))
+	ast.NotNil(verifyHeartbeat(req, addr, big.NewInt(1)))
+	req.IgnoreMediatedTransfer = true
+	ast.NotNil(verifyHeartbeat(req, addr, chainID))
+}
@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/Photon-Path-Finder/blockchainlistener"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// getSyncProgress report how far the events on chain have been processed
+func getSyncProgress(w rest.ResponseWriter, r *rest.Request) {
+	ce, ok := getNetwork(w, r)
+	if !ok {
+		return
+	}
+	err := w.WriteJson(ce.SyncProgress())
+	if err != nil {
+		log.Error(fmt.Sprintf("write json err %s", err))
+	}
+}
+
+// checkSynced returns false and responds 503 when processed block is more than SyncedThreshold blocks behind chain head
+func checkSynced(w rest.ResponseWriter, ce *blockchainlistener.ChainEvents) bool {
+	if ce.IsSynced() {
+		return true
+	}
+	p := ce.SyncProgress()
+	rest.Error(w, fmt.Sprintf("syncing, current block %d, head block %d", p.CurrentBlock, p.HeadBlock), http.StatusServiceUnavailable)
+	return false
+}
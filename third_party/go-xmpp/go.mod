@@ -0,0 +1,3 @@
+module github.com/mattn/go-xmpp
+
+go 1.27.1